@@ -118,13 +118,10 @@ func (ts *Tileset) arcgisServiceJSON() ([]byte, error) {
 	tags, _ := metadata["tags"].(string)
 	credits, _ := metadata["credits"].(string)
 
-	// TODO: make sure that min and max zoom always populated
-	minZoom, _ := metadata["minzoom"].(int)
-	maxZoom, _ := metadata["maxzoom"].(int)
 	// TODO: extract dpi from the image instead
 	var lods []arcGISLOD
 
-	for i := minZoom; i <= maxZoom; i++ {
+	for i := ts.minzoom; i <= ts.maxzoom; i++ {
 		scale, resolution := calcScaleResolution(i, dpi)
 		lods = append(lods, arcGISLOD{
 			Level:      i,
@@ -136,11 +133,7 @@ func (ts *Tileset) arcgisServiceJSON() ([]byte, error) {
 	minScale := lods[0].Scale
 	maxScale := lods[len(lods)-1].Scale
 
-	bounds, ok := metadata["bounds"].([]float64)
-	if !ok {
-		bounds = []float64{-180, -85, 180, 85} // default to world bounds
-	}
-	extent := geoBoundsToWMExtent(bounds)
+	extent := geoBoundsToWMExtent(ts.bounds[:])
 
 	tileInfo := map[string]interface{}{
 		"rows": 256,
@@ -406,21 +399,17 @@ func (ts *Tileset) arcgisTileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// flip y to match the spec
-	tc.y = (1 << uint64(tc.z)) - 1 - tc.y
-
-	var data []byte
-	err = db.ReadTile(tc.z, tc.x, tc.y, &data)
-
+	data, err := ts.readTile(tc)
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		ts.svc.logError("cannot fetch tile from DB for z=%d, x=%d, y=%d for %v: %v", tc.z, tc.x, tc.y, r.URL.Path, err)
 		return
 	}
 
-	if data == nil || len(data) <= 1 {
+	if data == nil {
 		// Return blank PNG for all image types
 		w.Header().Set("Content-Type", "image/png")
+		setCacheControl(w, ts.cacheControl.MissingTiles)
 		_, err = w.Write(BlankPNG(ts.tilesize))
 
 		if err != nil {
@@ -429,6 +418,10 @@ func (ts *Tileset) arcgisTileHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	} else {
 		w.Header().Set("Content-Type", db.GetTileFormat().MimeType())
+		setCacheControl(w, ts.cacheControl.Tiles)
+		if checkNotModified(w, r, contentETag(data), db.GetTimestamp()) {
+			return
+		}
 		_, err = w.Write(data)
 
 		if err != nil {
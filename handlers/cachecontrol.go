@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CacheControl provides the Cache-Control header values used for each type of
+// endpoint, for example "max-age=3600, stale-while-revalidate=60".
+// An empty value does not set a Cache-Control header for that endpoint.
+type CacheControl struct {
+	Tiles        string // tiles that exist in the tileset
+	MissingTiles string // blank or empty responses for tiles that do not exist
+	TileJSON     string
+	Preview      string
+	ServiceList  string // only used for the ServiceSet; ignored for tilesets
+}
+
+// cacheControlDirectives lists the supported Cache-Control response
+// directives, and whether or not each takes a number of seconds as argument.
+var cacheControlDirectives = map[string]bool{
+	"max-age":                true,
+	"s-maxage":               true,
+	"stale-while-revalidate": true,
+	"stale-if-error":         true,
+	"immutable":              false,
+	"public":                 false,
+	"private":                false,
+	"no-cache":               false,
+	"no-store":               false,
+	"no-transform":           false,
+	"must-revalidate":        false,
+	"proxy-revalidate":       false,
+}
+
+// ParseCacheControl validates a Cache-Control header value consisting of
+// comma-delimited directives (e.g., "max-age=86400, immutable") and returns
+// it in normalized form.  An error is returned for unknown directives or
+// invalid arguments.
+func ParseCacheControl(value string) (string, error) {
+	var directives []string
+	for _, directive := range strings.Split(value, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		if directive == "" {
+			continue
+		}
+
+		name, arg, hasArg := strings.Cut(directive, "=")
+		name = strings.TrimSpace(name)
+		arg = strings.TrimSpace(arg)
+
+		takesArg, ok := cacheControlDirectives[name]
+		if !ok {
+			return "", fmt.Errorf("unsupported Cache-Control directive %q", name)
+		}
+		if takesArg {
+			if !hasArg {
+				return "", fmt.Errorf("Cache-Control directive %q requires a number of seconds", name)
+			}
+			if seconds, err := strconv.ParseUint(arg, 10, 32); err != nil {
+				return "", fmt.Errorf("invalid number of seconds for Cache-Control directive %q: %q", name, arg)
+			} else {
+				arg = strconv.FormatUint(seconds, 10)
+			}
+			directives = append(directives, name+"="+arg)
+		} else {
+			if hasArg {
+				return "", fmt.Errorf("Cache-Control directive %q does not take a value", name)
+			}
+			directives = append(directives, name)
+		}
+	}
+	return strings.Join(directives, ", "), nil
+}
+
+// validate parses and normalizes all values of c
+func (c CacheControl) validate() (CacheControl, error) {
+	var err error
+	for _, v := range []*string{&c.Tiles, &c.MissingTiles, &c.TileJSON, &c.Preview, &c.ServiceList} {
+		if *v, err = ParseCacheControl(*v); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// merge returns a copy of c where any non-empty values of override take
+// precedence
+func (c CacheControl) merge(override CacheControl) CacheControl {
+	if override.Tiles != "" {
+		c.Tiles = override.Tiles
+	}
+	if override.MissingTiles != "" {
+		c.MissingTiles = override.MissingTiles
+	}
+	if override.TileJSON != "" {
+		c.TileJSON = override.TileJSON
+	}
+	if override.Preview != "" {
+		c.Preview = override.Preview
+	}
+	if override.ServiceList != "" {
+		c.ServiceList = override.ServiceList
+	}
+	return c
+}
+
+// setCacheControl sets the Cache-Control header on w if value is not empty
+func setCacheControl(w http.ResponseWriter, value string) {
+	if value != "" {
+		w.Header().Set("Cache-Control", value)
+	}
+}
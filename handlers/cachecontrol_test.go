@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_ParseCacheControl(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{value: "", expected: ""},
+		{value: "max-age=3600", expected: "max-age=3600"},
+		{value: "Max-Age=3600, IMMUTABLE", expected: "max-age=3600, immutable"},
+		{value: " public,max-age = 60 , s-maxage=600,stale-while-revalidate=30 ", expected: "public, max-age=60, s-maxage=600, stale-while-revalidate=30"},
+		{value: "no-store", expected: "no-store"},
+	}
+
+	for _, tc := range tests {
+		value, err := ParseCacheControl(tc.value)
+		if err != nil {
+			t.Error("ParseCacheControl returned unexpected error for:", tc.value, err)
+			continue
+		}
+		if value != tc.expected {
+			t.Error("ParseCacheControl returned unexpected value:", value, "expected:", tc.expected)
+			continue
+		}
+	}
+}
+
+func Test_ParseCacheControl_Invalid(t *testing.T) {
+	tests := []struct {
+		value string
+		err   string
+	}{
+		{value: "max-age", err: "requires a number of seconds"},
+		{value: "max-age=-1", err: "invalid number of seconds"},
+		{value: "max-age=1h", err: "invalid number of seconds"},
+		{value: "immutable=true", err: "does not take a value"},
+		{value: "foo", err: "unsupported Cache-Control directive"},
+	}
+
+	for _, tc := range tests {
+		_, err := ParseCacheControl(tc.value)
+		if err == nil {
+			t.Error("ParseCacheControl did not raise expected error for:", tc.value)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.err) {
+			t.Error("ParseCacheControl returned unexpected error message:", err, "expected:", tc.err)
+			continue
+		}
+	}
+}
+
+func Test_CacheControlMerge(t *testing.T) {
+	base := CacheControl{Tiles: "max-age=60", TileJSON: "no-cache"}
+	merged := base.merge(CacheControl{Tiles: "max-age=31536000, immutable"})
+
+	if merged.Tiles != "max-age=31536000, immutable" {
+		t.Error("CacheControl.merge did not override Tiles:", merged.Tiles)
+	}
+	if merged.TileJSON != "no-cache" {
+		t.Error("CacheControl.merge did not keep TileJSON:", merged.TileJSON)
+	}
+	if base.Tiles != "max-age=60" {
+		t.Error("CacheControl.merge modified original value")
+	}
+}
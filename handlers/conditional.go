@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// contentETag returns a strong ETag for data, based on a SHA1 hash of its
+// contents.
+func contentETag(data []byte) string {
+	hash := sha1.Sum(data)
+	return `"` + base64.RawURLEncoding.EncodeToString(hash[:]) + `"`
+}
+
+// etagWithSuffix returns etag with suffix appended within the quotes, in order
+// to distinguish different representations (e.g., content encodings) of the
+// same content.
+func etagWithSuffix(etag string, suffix string) string {
+	return strings.TrimSuffix(etag, `"`) + "-" + suffix + `"`
+}
+
+// checkNotModified sets the ETag and Last-Modified validators on w, if
+// provided, and then evaluates the If-None-Match and If-Modified-Since headers
+// of the request against them.  If the client already has the current
+// version of the resource, a 304 Not Modified response is written and true
+// is returned; the caller must not write anything else to w in that case.
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag string, modTime time.Time) bool {
+	h := w.Header()
+	if etag != "" {
+		h.Set("ETag", etag)
+	}
+	if !modTime.IsZero() {
+		h.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since (RFC 9110 13.2.2)
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etag == "" || !etagMatches(inm, etag) {
+			return false
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modTime.IsZero() {
+		t, err := http.ParseTime(ims)
+		if err != nil || modTime.Truncate(time.Second).After(t) {
+			return false
+		}
+	} else {
+		return false
+	}
+
+	// headers that describe the body are not sent with a 304
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches returns true if etag is present in the comma-delimited list of
+// entity tags from an If-None-Match header, using the weak comparison
+// function required for If-None-Match.
+func etagMatches(header string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
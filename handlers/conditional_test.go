@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_CheckNotModified(t *testing.T) {
+	etag := contentETag([]byte("tile data"))
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{name: "no conditional headers", headers: map[string]string{}, status: http.StatusOK},
+		{name: "matching etag", headers: map[string]string{"If-None-Match": etag}, status: http.StatusNotModified},
+		{name: "matching weak etag", headers: map[string]string{"If-None-Match": "W/" + etag}, status: http.StatusNotModified},
+		{name: "etag in list", headers: map[string]string{"If-None-Match": `"foo", ` + etag}, status: http.StatusNotModified},
+		{name: "wildcard etag", headers: map[string]string{"If-None-Match": "*"}, status: http.StatusNotModified},
+		{name: "different etag", headers: map[string]string{"If-None-Match": `"foo"`}, status: http.StatusOK},
+		{name: "not modified since", headers: map[string]string{"If-Modified-Since": modTime.Format(http.TimeFormat)}, status: http.StatusNotModified},
+		{name: "modified since", headers: map[string]string{"If-Modified-Since": modTime.Add(-time.Hour).Format(http.TimeFormat)}, status: http.StatusOK},
+		{name: "invalid date", headers: map[string]string{"If-Modified-Since": "yesterday"}, status: http.StatusOK},
+		{
+			// If-None-Match takes precedence
+			name:    "different etag, not modified since",
+			headers: map[string]string{"If-None-Match": `"foo"`, "If-Modified-Since": modTime.Format(http.TimeFormat)},
+			status:  http.StatusOK,
+		},
+	}
+
+	for _, tc := range tests {
+		r := httptest.NewRequest("GET", "/services/foo/tiles/0/0/0.png", nil)
+		for k, v := range tc.headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		if !checkNotModified(w, r, etag, modTime) {
+			w.WriteHeader(http.StatusOK)
+		}
+
+		if w.Code != tc.status {
+			t.Error("checkNotModified returned unexpected status for", tc.name, ":", w.Code, "expected:", tc.status)
+			continue
+		}
+		if w.Header().Get("ETag") != etag {
+			t.Error("checkNotModified did not set expected ETag for", tc.name, ":", w.Header().Get("ETag"))
+			continue
+		}
+		if w.Header().Get("Last-Modified") != modTime.Format(http.TimeFormat) {
+			t.Error("checkNotModified did not set expected Last-Modified for", tc.name, ":", w.Header().Get("Last-Modified"))
+			continue
+		}
+	}
+}
+
+func Test_ContentETag(t *testing.T) {
+	a := contentETag([]byte("a"))
+	if a != contentETag([]byte("a")) {
+		t.Error("contentETag is not stable for the same content")
+	}
+	if a == contentETag([]byte("b")) {
+		t.Error("contentETag returned the same value for different content")
+	}
+	if a[0] != '"' || a[len(a)-1] != '"' {
+		t.Error("contentETag did not return a quoted strong ETag:", a)
+	}
+}
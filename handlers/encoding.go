@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Content encodings supported for vector tiles.  Note: HTTP "deflate" is the
+// zlib format.
+const (
+	encodingIdentity = "identity"
+	encodingGzip     = "gzip"
+	encodingDeflate  = "deflate"
+	encodingBrotli   = "br"
+)
+
+// detectEncoding detects the content encoding of data based on its first
+// bytes: gzip, zlib (deflate), or otherwise uncompressed (identity).
+// Brotli has no signature and is not expected to be stored in mbtiles files.
+func detectEncoding(data []byte) string {
+	if len(data) < 2 {
+		return encodingIdentity
+	}
+	if data[0] == 0x1f && data[1] == 0x8b {
+		return encodingGzip
+	}
+	// zlib header: compression method 8 and header checksum divisible by 31
+	if data[0]&0x0f == 8 && (uint16(data[0])<<8|uint16(data[1]))%31 == 0 {
+		return encodingDeflate
+	}
+	return encodingIdentity
+}
+
+// negotiateEncoding returns the content encoding to use for a response, based
+// on the Accept-Encoding header of r and the encoding of the stored data.
+// If there are multiple acceptable encodings with the same quality value, the
+// stored encoding is preferred to avoid recompressing the data.
+// An empty string is returned if none of the supported encodings are
+// acceptable to the client.
+func negotiateEncoding(r *http.Request, stored string) string {
+	values, ok := r.Header["Accept-Encoding"]
+	if !ok {
+		// any encoding is acceptable if the header is absent
+		return stored
+	}
+
+	qualities := make(map[string]float64)
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(item, ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "" {
+				continue
+			}
+			if coding == "x-gzip" {
+				coding = encodingGzip
+			}
+			q := 1.0
+			if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+				if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					q = v
+				}
+			}
+			qualities[coding] = q
+		}
+	}
+
+	quality := func(coding string) float64 {
+		if q, ok := qualities[coding]; ok {
+			return q
+		}
+		if q, ok := qualities["*"]; ok {
+			return q
+		}
+		// identity is always acceptable unless explicitly excluded
+		if coding == encodingIdentity {
+			return 0.001
+		}
+		return 0
+	}
+
+	best := ""
+	bestQ := 0.0
+	for _, coding := range []string{stored, encodingGzip, encodingBrotli, encodingDeflate, encodingIdentity} {
+		if q := quality(coding); q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// recode decompresses data from encoding from and recompresses it using
+// encoding to.
+func recode(data []byte, from string, to string) ([]byte, error) {
+	if from == to {
+		return data, nil
+	}
+
+	var raw []byte
+	var err error
+	switch from {
+	case encodingIdentity:
+		raw = data
+	case encodingGzip:
+		var r *gzip.Reader
+		if r, err = gzip.NewReader(bytes.NewReader(data)); err == nil {
+			raw, err = io.ReadAll(r)
+		}
+	case encodingDeflate:
+		var r io.ReadCloser
+		if r, err = zlib.NewReader(bytes.NewReader(data)); err == nil {
+			raw, err = io.ReadAll(r)
+		}
+	default:
+		err = fmt.Errorf("unsupported content encoding %q", from)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress %s data: %v", from, err)
+	}
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch to {
+	case encodingIdentity:
+		return raw, nil
+	case encodingGzip:
+		w = gzip.NewWriter(&buf)
+	case encodingDeflate:
+		w = zlib.NewWriter(&buf)
+	case encodingBrotli:
+		w = brotli.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", to)
+	}
+	if _, err = w.Write(raw); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
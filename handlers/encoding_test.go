@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_NegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header   string
+		absent   bool
+		stored   string
+		expected string
+	}{
+		{absent: true, stored: encodingGzip, expected: encodingGzip},
+		{absent: true, stored: encodingIdentity, expected: encodingIdentity},
+		{header: "", stored: encodingGzip, expected: encodingIdentity},
+		{header: "gzip, deflate, br", stored: encodingGzip, expected: encodingGzip},
+		{header: "gzip, deflate, br", stored: encodingDeflate, expected: encodingDeflate},
+		{header: "gzip, deflate, br", stored: encodingIdentity, expected: encodingGzip},
+		{header: "br", stored: encodingGzip, expected: encodingBrotli},
+		{header: "br;q=1.0, gzip;q=0.8", stored: encodingGzip, expected: encodingBrotli},
+		{header: "identity", stored: encodingGzip, expected: encodingIdentity},
+		{header: "deflate", stored: encodingGzip, expected: encodingDeflate},
+		{header: "x-gzip", stored: encodingIdentity, expected: encodingGzip},
+		{header: "*", stored: encodingDeflate, expected: encodingDeflate},
+		{header: "gzip;q=0, identity;q=0.5", stored: encodingGzip, expected: encodingIdentity},
+		{header: "compress", stored: encodingGzip, expected: encodingIdentity},
+		{header: "gzip;q=0, identity;q=0", stored: encodingGzip, expected: ""},
+		{header: "*;q=0", stored: encodingGzip, expected: ""},
+	}
+
+	for _, tc := range tests {
+		r := httptest.NewRequest("GET", "/services/foo/tiles/0/0/0.pbf", nil)
+		if !tc.absent {
+			r.Header["Accept-Encoding"] = []string{tc.header}
+		}
+
+		encoding := negotiateEncoding(r, tc.stored)
+		if encoding != tc.expected {
+			t.Errorf("negotiateEncoding returned unexpected encoding for %q (stored: %s): %q, expected: %q", tc.header, tc.stored, encoding, tc.expected)
+			continue
+		}
+	}
+}
+
+func Test_Recode(t *testing.T) {
+	raw := bytes.Repeat([]byte("\x1a\x0bvector tile data"), 20)
+
+	encodings := []string{encodingIdentity, encodingGzip, encodingDeflate}
+
+	for _, from := range encodings {
+		stored, err := recode(raw, encodingIdentity, from)
+		if err != nil {
+			t.Error("Could not encode data using:", from, err)
+			continue
+		}
+		if detectEncoding(stored) != from {
+			t.Error("detectEncoding returned unexpected encoding:", detectEncoding(stored), "expected:", from)
+			continue
+		}
+
+		for _, to := range append(encodings, encodingBrotli) {
+			encoded, err := recode(stored, from, to)
+			if err != nil {
+				t.Error("Could not recode data from:", from, "to:", to, err)
+				continue
+			}
+
+			if to == encodingBrotli {
+				continue
+			}
+
+			decoded, err := recode(encoded, to, encodingIdentity)
+			if err != nil {
+				t.Error("Could not decode data from:", to, err)
+				continue
+			}
+			if !bytes.Equal(decoded, raw) {
+				t.Error("recode did not round-trip data from:", from, "to:", to)
+				continue
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+
+	"crawshaw.io/sqlite"
+)
+
+// maxZoomLevel is the maximum valid zoom level in the metadata of an mbtiles
+// file
+const maxZoomLevel = 30
+
+// MaxZoomLevel is the maximum zoom level of tiles that can be requested,
+// including overzoomed tiles
+const MaxZoomLevel = maxZoomLevel
+
+// worldBounds are the bounds of the web mercator tile grid, used when the
+// bounds of a tileset cannot be determined
+var worldBounds = [4]float64{-180, -maxMercatorLatitude, 180, maxMercatorLatitude}
+
+// tilesetExtent provides the zoom range, bounds, and center of a tileset
+type tilesetExtent struct {
+	minzoom int
+	maxzoom int
+	// [west, south, east, north] in degrees
+	bounds [4]float64
+	// [longitude, latitude, zoom]
+	center [3]float64
+}
+
+// readExtent returns the extent of the mbtiles file from its metadata.  Any
+// values that are missing or invalid in the metadata are derived from the
+// tiles table of the mbtiles file: the zoom range from the minimum and maximum
+// zoom levels of the tiles, the bounds from the tiles at each zoom level, and
+// the center from the center of the bounds at the minimum zoom level.
+func readExtent(filename string, metadata map[string]interface{}) (*tilesetExtent, error) {
+	extent := &tilesetExtent{}
+
+	minzoom, ok1 := metadata["minzoom"].(int)
+	maxzoom, ok2 := metadata["maxzoom"].(int)
+	validZoom := ok1 && ok2 && minzoom >= 0 && minzoom <= maxzoom && maxzoom <= maxZoomLevel
+	extent.minzoom, extent.maxzoom = minzoom, maxzoom
+
+	bounds, validBounds := validBounds(metadata["bounds"])
+	extent.bounds = bounds
+
+	if !(validZoom && validBounds) {
+		scanned, err := scanTiles(filename)
+		if err != nil {
+			return nil, fmt.Errorf("could not read tiles: %v", err)
+		}
+		if !validZoom {
+			extent.minzoom, extent.maxzoom = scanned.minzoom, scanned.maxzoom
+		}
+		if !validBounds {
+			extent.bounds = scanned.bounds
+		}
+	}
+
+	center, ok := metadata["center"].([]float64)
+	if ok && len(center) >= 2 && isFinite(center...) && center[0] >= -180 && center[0] <= 180 && center[1] >= -90 && center[1] <= 90 {
+		extent.center = [3]float64{center[0], center[1], float64(extent.minzoom)}
+		if len(center) >= 3 && isFinite(center[2]) {
+			extent.center[2] = math.Max(math.Min(math.Round(center[2]), float64(extent.maxzoom)), float64(extent.minzoom))
+		}
+	} else {
+		b := extent.bounds
+		extent.center = [3]float64{(b[0] + b[2]) / 2, (b[1] + b[3]) / 2, float64(extent.minzoom)}
+	}
+
+	return extent, nil
+}
+
+// validBounds returns the bounds from the bounds metadata value if they are
+// four numbers within -180 to 180 longitude and -90 to 90 latitude with west
+// less than east and south less than north.
+func validBounds(value interface{}) ([4]float64, bool) {
+	bounds, ok := value.([]float64)
+	if !ok || len(bounds) != 4 || !isFinite(bounds...) {
+		return worldBounds, false
+	}
+	if bounds[0] < -180 || bounds[2] > 180 || bounds[1] < -90 || bounds[3] > 90 || bounds[0] >= bounds[2] || bounds[1] >= bounds[3] {
+		return worldBounds, false
+	}
+	return [4]float64{bounds[0], bounds[1], bounds[2], bounds[3]}, true
+}
+
+// isFinite returns true if all values are neither NaN nor infinite
+func isFinite(values ...float64) bool {
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// scanTiles returns the zoom range and bounds of the tiles in the tiles table
+// of the mbtiles file.  The bounds are those of the tiles at the maximum zoom
+// level, which most closely match the extent of the data.  If there are no
+// tiles, the zoom range is 0 to 0 and the bounds are the world bounds.
+func scanTiles(filename string) (*tilesetExtent, error) {
+	con, err := sqlite.OpenConn(filename, sqlite.SQLITE_OPEN_READONLY|sqlite.SQLITE_OPEN_NOMUTEX)
+	if err != nil {
+		return nil, err
+	}
+	defer con.Close()
+
+	extent := &tilesetExtent{bounds: worldBounds}
+
+	query, _, err := con.PrepareTransient("SELECT min(zoom_level), max(zoom_level) FROM tiles WHERE zoom_level BETWEEN 0 AND $maxzoom")
+	if err != nil {
+		return nil, err
+	}
+	defer query.Finalize()
+	query.SetInt64("$maxzoom", maxZoomLevel)
+	if _, err = query.Step(); err != nil {
+		return nil, err
+	}
+	if query.ColumnType(0) == sqlite.SQLITE_NULL {
+		// no tiles
+		return extent, nil
+	}
+	extent.minzoom, extent.maxzoom = query.ColumnInt(0), query.ColumnInt(1)
+
+	query, _, err = con.PrepareTransient("SELECT min(tile_column), max(tile_column), min(tile_row), max(tile_row) FROM tiles WHERE zoom_level = $z")
+	if err != nil {
+		return nil, err
+	}
+	defer query.Finalize()
+	query.SetInt64("$z", int64(extent.maxzoom))
+	if _, err = query.Step(); err != nil {
+		return nil, err
+	}
+
+	// tile rows are stored in the TMS scheme, with row 0 at the bottom
+	n := int64(1) << uint(extent.maxzoom)
+	minCol, maxCol := query.ColumnInt64(0), query.ColumnInt64(1)
+	minRow, maxRow := n-1-query.ColumnInt64(3), n-1-query.ColumnInt64(2)
+	west, north := tileToLonLat(float64(minCol), float64(minRow), extent.maxzoom)
+	east, south := tileToLonLat(float64(maxCol+1), float64(maxRow+1), extent.maxzoom)
+	bounds := [4]float64{
+		math.Max(west, -180),
+		math.Max(south, -maxMercatorLatitude),
+		math.Min(east, 180),
+		math.Min(north, maxMercatorLatitude),
+	}
+	if bounds[0] < bounds[2] && bounds[1] < bounds[3] {
+		extent.bounds = bounds
+	}
+	return extent, nil
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func Test_ReadExtent(t *testing.T) {
+	// tiles at zoom 6 are columns 10 - 63 and rows 18 - 39
+	west, north := tileToLonLat(10, 18, 6)
+	east, south := tileToLonLat(64, 40, 6)
+	scanned := [4]float64{west, south, east, north}
+
+	tests := []struct {
+		name     string
+		filename string
+		metadata map[string]interface{}
+		expected tilesetExtent
+	}{
+		{
+			name:     "valid metadata",
+			filename: "world_cities",
+			metadata: map[string]interface{}{
+				"minzoom": 1,
+				"maxzoom": 4,
+				"bounds":  []float64{-10, -20, 30, 40},
+				"center":  []float64{5, 10, 2},
+			},
+			expected: tilesetExtent{minzoom: 1, maxzoom: 4, bounds: [4]float64{-10, -20, 30, 40}, center: [3]float64{5, 10, 2}},
+		},
+		{
+			name:     "missing metadata",
+			filename: "world_cities",
+			metadata: map[string]interface{}{},
+			expected: tilesetExtent{minzoom: 0, maxzoom: 6, bounds: scanned, center: [3]float64{(west + east) / 2, (south + north) / 2, 0}},
+		},
+		{
+			name:     "invalid zoom range",
+			filename: "world_cities",
+			metadata: map[string]interface{}{"minzoom": 5, "maxzoom": 2, "bounds": []float64{-10, -20, 30, 40}},
+			expected: tilesetExtent{minzoom: 0, maxzoom: 6, bounds: [4]float64{-10, -20, 30, 40}, center: [3]float64{10, 10, 0}},
+		},
+		{
+			name:     "invalid bounds",
+			filename: "world_cities",
+			metadata: map[string]interface{}{"minzoom": 0, "maxzoom": 6, "bounds": []float64{30, -20, -10, 40}, "center": []float64{5, 10}},
+			expected: tilesetExtent{minzoom: 0, maxzoom: 6, bounds: scanned, center: [3]float64{5, 10, 0}},
+		},
+		{
+			name:     "too few bounds values",
+			filename: "world_cities",
+			metadata: map[string]interface{}{"minzoom": 0, "maxzoom": 6, "bounds": []float64{-10, -20, 30}, "center": []float64{5, 10, 3}},
+			expected: tilesetExtent{minzoom: 0, maxzoom: 6, bounds: scanned, center: [3]float64{5, 10, 3}},
+		},
+		{
+			name:     "center zoom outside zoom range",
+			filename: "world_cities",
+			metadata: map[string]interface{}{"minzoom": 0, "maxzoom": 6, "bounds": []float64{-10, -20, 30, 40}, "center": []float64{5, 10, 12}},
+			expected: tilesetExtent{minzoom: 0, maxzoom: 6, bounds: [4]float64{-10, -20, 30, 40}, center: [3]float64{5, 10, 6}},
+		},
+		{
+			name:     "invalid center",
+			filename: "world_cities",
+			metadata: map[string]interface{}{"minzoom": 0, "maxzoom": 6, "bounds": []float64{-10, -20, 30, 40}, "center": []float64{200, 10, 2}},
+			expected: tilesetExtent{minzoom: 0, maxzoom: 6, bounds: [4]float64{-10, -20, 30, 40}, center: [3]float64{10, 10, 0}},
+		},
+		{
+			name:     "world tiles",
+			filename: "geography-class-png-no-bounds",
+			metadata: map[string]interface{}{"minzoom": 0, "maxzoom": 1},
+			expected: tilesetExtent{minzoom: 0, maxzoom: 1, bounds: worldBounds, center: [3]float64{0, 0, 0}},
+		},
+	}
+
+	for _, tc := range tests {
+		extent, err := readExtent("../testdata/"+tc.filename+".mbtiles", tc.metadata)
+		if err != nil {
+			t.Error("Could not read extent for:", tc.name, err)
+			continue
+		}
+		if extent.minzoom != tc.expected.minzoom || extent.maxzoom != tc.expected.maxzoom {
+			t.Errorf("Unexpected zoom range for %s: %d - %d, expected: %d - %d", tc.name, extent.minzoom, extent.maxzoom, tc.expected.minzoom, tc.expected.maxzoom)
+		}
+		if !floatsEqual(extent.bounds[:], tc.expected.bounds[:]) {
+			t.Errorf("Unexpected bounds for %s: %v, expected: %v", tc.name, extent.bounds, tc.expected.bounds)
+		}
+		if !floatsEqual(extent.center[:], tc.expected.center[:]) {
+			t.Errorf("Unexpected center for %s: %v, expected: %v", tc.name, extent.center, tc.expected.center)
+		}
+	}
+}
+
+func Test_ExtentFromTiles(t *testing.T) {
+	svc := newTestServiceSet(t, &ServiceSetConfig{EnableTileJSON: true, EnableArcGIS: true}, "geography-class-png-no-bounds", "world_cities_missing_center")
+
+	ts := svc.tilesets["geography-class-png-no-bounds"]
+	if !floatsEqual(ts.bounds[:], worldBounds[:]) {
+		t.Error("Unexpected bounds for tileset without bounds:", ts.bounds)
+	}
+
+	data, err := ts.arcgisServiceJSON()
+	if err != nil {
+		t.Fatal("Could not create ArcGIS service JSON:", err)
+	}
+	var svcJSON struct {
+		TileInfo struct {
+			LODs []arcGISLOD `json:"lods"`
+		} `json:"tileInfo"`
+	}
+	if err = json.Unmarshal(data, &svcJSON); err != nil {
+		t.Fatal("Could not parse ArcGIS service JSON:", err)
+	}
+	if len(svcJSON.TileInfo.LODs) != 2 {
+		t.Error("Unexpected number of ArcGIS LODs:", len(svcJSON.TileInfo.LODs), "expected: 2")
+	}
+
+	ts = svc.tilesets["world_cities_missing_center"]
+	expected := [3]float64{(-123.123590 + 174.763027) / 2, (-37.818085 + 59.352706) / 2, 0}
+	if !floatsEqual(ts.center[:], expected[:]) {
+		t.Error("Unexpected center for tileset without center:", ts.center, "expected:", expected)
+	}
+
+	tileJSON, err := ts.TileJSON("http://example.com/services/world_cities_missing_center", "")
+	if err != nil {
+		t.Fatal("Could not create TileJSON:", err)
+	}
+	if tileJSON["center"] != ts.center {
+		t.Error("Unexpected TileJSON center:", tileJSON["center"], "expected:", ts.center)
+	}
+}
+
+// floatsEqual returns true if a and b are equal within floating point error
+func floatsEqual(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
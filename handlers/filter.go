@@ -0,0 +1,528 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+)
+
+// maxFilterLength is the maximum length of filter expressions
+const maxFilterLength = 4096
+
+// tileFilter selects the layers and features of vector tiles
+type tileFilter struct {
+	// names of layers to include, or nil to include all layers
+	layers map[string]bool
+	// expression that features must match, or nil to include all features
+	expr filterExpr
+}
+
+// parseTileFilter parses a tile filter from the layers query parameter, a
+// comma-delimited list of layer names, and the filter query parameter, a
+// filter expression.  Returns nil if neither is present.
+func parseTileFilter(query url.Values) (*tileFilter, error) {
+	layers, filter := query.Get("layers"), query.Get("filter")
+	if layers == "" && filter == "" {
+		return nil, nil
+	}
+
+	f := &tileFilter{}
+	if layers != "" {
+		f.layers = make(map[string]bool)
+		for _, name := range strings.Split(layers, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				f.layers[name] = true
+			}
+		}
+	}
+	if filter != "" {
+		expr, err := parseFilterExpr(filter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter: %v", err)
+		}
+		f.expr = expr
+	}
+	return f, nil
+}
+
+// includesLayer returns true if layers with name are included by the filter
+func (f *tileFilter) includesLayer(name string) bool {
+	return f == nil || f.layers == nil || f.layers[name]
+}
+
+// apply returns the layers and features included by the filter.  Layers
+// without any included features are removed.
+func (f *tileFilter) apply(layers mvt.Layers) mvt.Layers {
+	if f == nil {
+		return layers
+	}
+	out := layers[:0]
+	for _, layer := range layers {
+		if !f.includesLayer(layer.Name) {
+			continue
+		}
+		if f.expr != nil {
+			features := layer.Features[:0]
+			for _, feature := range layer.Features {
+				if f.expr.match(layer.Name, feature) {
+					features = append(features, feature)
+				}
+			}
+			layer.Features = features
+		}
+		if len(layer.Features) > 0 {
+			out = append(out, layer)
+		}
+	}
+	return out
+}
+
+// filterVectorLayers returns the items of vector_layers of TileJSON (either
+// from metadata or as returned by tileJSONVectorLayers) whose id is included
+// by the filter
+func (f *tileFilter) filterVectorLayers(value interface{}) interface{} {
+	if f == nil || f.layers == nil {
+		return value
+	}
+	switch items := value.(type) {
+	case []map[string]interface{}:
+		out := []map[string]interface{}{}
+		for _, item := range items {
+			if id, _ := item["id"].(string); f.layers[id] {
+				out = append(out, item)
+			}
+		}
+		return out
+	case []interface{}:
+		out := []interface{}{}
+		for _, item := range items {
+			if layer, ok := item.(map[string]interface{}); ok {
+				if id, _ := layer["id"].(string); f.layers[id] {
+					out = append(out, item)
+				}
+			}
+		}
+		return out
+	}
+	return value
+}
+
+// filterVectorTile returns vector tile data with only the layers and features
+// included by filter f.  The returned data is uncompressed.
+func filterVectorTile(data []byte, f *tileFilter) ([]byte, error) {
+	layers, err := decodeVectorTile(data)
+	if err != nil {
+		return nil, err
+	}
+	return encodeVectorTile(f.apply(layers))
+}
+
+// filterExpr is a filter expression that features are matched against
+type filterExpr interface {
+	match(layer string, f *geojson.Feature) bool
+}
+
+type andExpr struct{ left, right filterExpr }
+
+func (e andExpr) match(layer string, f *geojson.Feature) bool {
+	return e.left.match(layer, f) && e.right.match(layer, f)
+}
+
+type orExpr struct{ left, right filterExpr }
+
+func (e orExpr) match(layer string, f *geojson.Feature) bool {
+	return e.left.match(layer, f) || e.right.match(layer, f)
+}
+
+type notExpr struct{ expr filterExpr }
+
+func (e notExpr) match(layer string, f *geojson.Feature) bool {
+	return !e.expr.match(layer, f)
+}
+
+// compareExpr compares the value of a field of features to values: equal to
+// any of values for "=" and "in", or ordered relative to the value for "<",
+// "<=", ">", and ">="
+type compareExpr struct {
+	field  string
+	op     string
+	values []interface{}
+}
+
+func (e compareExpr) match(layer string, f *geojson.Feature) bool {
+	value := fieldValue(e.field, layer, f)
+	switch e.op {
+	case "=", "in":
+		for _, v := range e.values {
+			if value == v {
+				return true
+			}
+		}
+		return false
+	}
+
+	cmp, ok := compareValues(value, e.values[0])
+	if !ok {
+		return false
+	}
+	switch e.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// fieldValue returns the value of field of feature f in layer, or nil if it is
+// missing.  Numbers are float64.  The special fields $type, $id, and $layer
+// are the geometry type (Point, LineString, or Polygon), feature ID, and
+// layer name.
+func fieldValue(field string, layer string, f *geojson.Feature) interface{} {
+	switch field {
+	case "$type":
+		if f.Geometry == nil {
+			return nil
+		}
+		return filterGeometryType(f.Geometry)
+	case "$id":
+		if id, ok := f.ID.(uint64); ok {
+			return float64(id)
+		}
+		return f.ID
+	case "$layer":
+		return layer
+	}
+	return f.Properties[field]
+}
+
+// filterGeometryType returns the geometry type of g for filter expressions,
+// where multi-part geometries use the type of their parts
+func filterGeometryType(g orb.Geometry) string {
+	switch g.Dimensions() {
+	case 0:
+		return "Point"
+	case 1:
+		return "LineString"
+	}
+	return "Polygon"
+}
+
+// compareValues returns -1, 0, or 1 if a is less than, equal to, or greater
+// than b.  Returns false if a and b are not both numbers or both strings.
+func compareValues(a, b interface{}) (int, bool) {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			switch {
+			case a < b:
+				return -1, true
+			case a > b:
+				return 1, true
+			}
+			return 0, true
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	}
+	return 0, false
+}
+
+// filterToken is a token of a filter expression
+type filterToken struct {
+	kind  string // "ident", "keyword", "value", "op", "(", ")", ",", or "" at the end
+	text  string
+	value interface{}
+}
+
+// tokenizeFilter splits a filter expression into tokens
+func tokenizeFilter(s string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '(' || c == ')' || c == ',':
+			tokens = append(tokens, filterToken{kind: string(c), text: string(c)})
+			i++
+
+		case c == '=' || c == '!' || c == '<' || c == '>':
+			op := string(c)
+			if i+1 < len(s) && (s[i+1] == '=' || (c == '<' && s[i+1] == '>')) {
+				op = s[i : i+2]
+			}
+			i += len(op)
+			switch op {
+			case "!":
+				return nil, fmt.Errorf("unexpected character %q", c)
+			case "<>":
+				op = "!="
+			case "==":
+				op = "="
+			}
+			tokens = append(tokens, filterToken{kind: "op", text: op})
+
+		case c == '\'' || c == '"':
+			// single quoted strings and double quoted identifiers, where the
+			// quote character is escaped by repeating it
+			var b strings.Builder
+			j := i + 1
+			for {
+				if j >= len(s) {
+					return nil, fmt.Errorf("unterminated quoted value")
+				}
+				if s[j] == c {
+					if j+1 < len(s) && s[j+1] == c {
+						b.WriteByte(c)
+						j += 2
+						continue
+					}
+					break
+				}
+				b.WriteByte(s[j])
+				j++
+			}
+			if c == '\'' {
+				tokens = append(tokens, filterToken{kind: "value", text: s[i : j+1], value: b.String()})
+			} else {
+				tokens = append(tokens, filterToken{kind: "ident", text: b.String()})
+			}
+			i = j + 1
+
+		case c == '-' || c == '.' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(s) && strings.IndexByte("0123456789.eE+-", s[j]) >= 0 {
+				// signs are only part of numbers after an exponent
+				if (s[j] == '+' || s[j] == '-') && s[j-1] != 'e' && s[j-1] != 'E' {
+					break
+				}
+				j++
+			}
+			v, err := strconv.ParseFloat(s[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q", s[i:j])
+			}
+			tokens = append(tokens, filterToken{kind: "value", text: s[i:j], value: v})
+			i = j
+
+		case c == '$' || isFilterIdentChar(c) && !(c >= '0' && c <= '9') && c != ':' && c != '-':
+			j := i + 1
+			for j < len(s) && isFilterIdentChar(s[j]) {
+				j++
+			}
+			word := s[i:j]
+			switch strings.ToLower(word) {
+			case "and", "or", "not", "in":
+				tokens = append(tokens, filterToken{kind: "keyword", text: strings.ToLower(word)})
+			case "true", "false":
+				tokens = append(tokens, filterToken{kind: "value", text: word, value: strings.ToLower(word) == "true"})
+			case "null":
+				tokens = append(tokens, filterToken{kind: "value", text: word, value: nil})
+			default:
+				tokens = append(tokens, filterToken{kind: "ident", text: word})
+			}
+			i = j
+
+		default:
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+// isFilterIdentChar returns true if c can be part of an unquoted field name:
+// letters, digits, _, :, ., -, or part of a non-ASCII character
+func isFilterIdentChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == ':' || c == '.' || c == '-' || c >= 0x80
+}
+
+// filterParser is a recursive descent parser of filter expressions
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+// parseFilterExpr parses a filter expression of the form:
+//
+//	expr       = term { "or" term }
+//	term       = factor { "and" factor }
+//	factor     = "not" factor | "(" expr ")" | comparison
+//	comparison = field op value | field [ "not" ] "in" "(" value { "," value } ")"
+//
+// where op is one of =, !=, <, <=, >, or >=; field is an attribute name,
+// double quoted if it contains other characters than letters, digits, _, :,
+// ., or -, or one of $type, $id, or $layer; and value is a number, single
+// quoted string, true, false, or null.  Keywords are not case sensitive.
+func parseFilterExpr(s string) (filterExpr, error) {
+	if len(s) > maxFilterLength {
+		return nil, fmt.Errorf("filter must be at most %d characters", maxFilterLength)
+	}
+	tokens, err := tokenizeFilter(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "" {
+		return nil, fmt.Errorf("unexpected %q", t.text)
+	}
+	return expr, nil
+}
+
+// peek returns the next token without consuming it
+func (p *filterParser) peek() filterToken {
+	if p.pos >= len(p.tokens) {
+		return filterToken{}
+	}
+	return p.tokens[p.pos]
+}
+
+// next consumes and returns the next token
+func (p *filterParser) next() filterToken {
+	t := p.peek()
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+	return t
+}
+
+// expect consumes the next token, which must be of kind
+func (p *filterParser) expect(kind string) (filterToken, error) {
+	t := p.next()
+	if t.kind != kind {
+		if t.kind == "" {
+			return t, fmt.Errorf("unexpected end of filter")
+		}
+		return t, fmt.Errorf("unexpected %q", t.text)
+	}
+	return t, nil
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.kind == "keyword" && t.text == "or"; t = p.peek() {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.kind == "keyword" && t.text == "and"; t = p.peek() {
+		p.next()
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseFactor() (filterExpr, error) {
+	t := p.peek()
+	switch {
+	case t.kind == "keyword" && t.text == "not":
+		p.next()
+		expr, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{expr}, nil
+
+	case t.kind == "(":
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterExpr, error) {
+	field, err := p.expect("ident")
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.next()
+	switch {
+	case t.kind == "op":
+		value, err := p.expect("value")
+		if err != nil {
+			return nil, err
+		}
+		if t.text == "!=" {
+			return notExpr{compareExpr{field: field.text, op: "=", values: []interface{}{value.value}}}, nil
+		}
+		return compareExpr{field: field.text, op: t.text, values: []interface{}{value.value}}, nil
+
+	case t.kind == "keyword" && (t.text == "in" || t.text == "not"):
+		negate := t.text == "not"
+		if negate {
+			if in := p.next(); in.kind != "keyword" || in.text != "in" {
+				return nil, fmt.Errorf("expected in after not")
+			}
+		}
+		if _, err := p.expect("("); err != nil {
+			return nil, err
+		}
+		var values []interface{}
+		for {
+			value, err := p.expect("value")
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value.value)
+			if p.peek().kind != "," {
+				break
+			}
+			p.next()
+		}
+		if _, err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		var expr filterExpr = compareExpr{field: field.text, op: "in", values: values}
+		if negate {
+			expr = notExpr{expr}
+		}
+		return expr, nil
+
+	case t.kind == "":
+		return nil, fmt.Errorf("unexpected end of filter")
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+)
+
+func Test_ParseFilterExpr(t *testing.T) {
+	valid := []string{
+		"class = 'primary'",
+		"class == 'primary'",
+		"class != 'primary'",
+		"class <> 'primary'",
+		"rank >= 3 AND rank < 10",
+		"not (class in ('primary', 'secondary') or rank > -1.5e3)",
+		"class not in ('path')",
+		"name = 'O''Brien'",
+		`"name:en" = 'London'`,
+		"name:en = 'London' and $type = 'Polygon'",
+		"$layer = 'water' or $id = 1",
+		"bridge = true and tunnel = false and oneway = null",
+		"größe > 10",
+	}
+	for _, s := range valid {
+		if _, err := parseFilterExpr(s); err != nil {
+			t.Error("parseFilterExpr raised unexpected error for:", s, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"class",
+		"class =",
+		"class = primary",
+		"= 'primary'",
+		"class ! 'primary'",
+		"class = 'primary",
+		"(class = 'primary'",
+		"class = 'primary')",
+		"class = 'primary' and",
+		"class = 'primary' rank = 1",
+		"class in 'primary'",
+		"class in ()",
+		"class not 'primary'",
+		"rank = 1.2.3",
+		"class = 'primary' & rank = 1",
+	}
+	for _, s := range invalid {
+		if _, err := parseFilterExpr(s); err == nil {
+			t.Error("parseFilterExpr did not raise expected error for:", s)
+		}
+	}
+}
+
+func Test_FilterExprMatch(t *testing.T) {
+	f := geojson.NewFeature(orb.MultiLineString{{{0, 0}, {1, 1}}})
+	f.ID = float64(7)
+	f.Properties = geojson.Properties{
+		"class":   "primary",
+		"rank":    float64(5),
+		"bridge":  true,
+		"name:en": "Main Street",
+	}
+
+	tests := []struct {
+		filter string
+		match  bool
+	}{
+		{filter: "class = 'primary'", match: true},
+		{filter: "class = 'Primary'", match: false},
+		{filter: "class != 'secondary'", match: true},
+		{filter: "rank = 5", match: true},
+		{filter: "rank = '5'", match: false},
+		{filter: "rank > 4.5 and rank <= 5", match: true},
+		{filter: "rank < 5", match: false},
+		{filter: "class > 'p'", match: true},
+		// values of different types cannot be ordered
+		{filter: "class > 1", match: false},
+		{filter: "bridge = true", match: true},
+		{filter: "bridge = false", match: false},
+		{filter: "class in ('secondary', 'primary')", match: true},
+		{filter: "class not in ('secondary', 'primary')", match: false},
+		// missing attributes are null
+		{filter: "tunnel = null", match: true},
+		{filter: "class = null", match: false},
+		{filter: "tunnel != true", match: true},
+		{filter: "tunnel = true", match: false},
+		{filter: "tunnel > 1", match: false},
+		{filter: `"name:en" = 'Main Street'`, match: true},
+		{filter: "$type = 'LineString'", match: true},
+		{filter: "$id = 7", match: true},
+		{filter: "$layer = 'roads'", match: true},
+		{filter: "class = 'secondary' or rank = 5 and bridge = true", match: true},
+		{filter: "(class = 'secondary' or rank = 5) and bridge = false", match: false},
+		{filter: "not class = 'primary'", match: false},
+		{filter: "NOT (class = 'secondary' OR rank > 10)", match: true},
+	}
+
+	for _, tc := range tests {
+		expr, err := parseFilterExpr(tc.filter)
+		if err != nil {
+			t.Error("parseFilterExpr raised unexpected error for:", tc.filter, err)
+			continue
+		}
+		if match := expr.match("roads", f); match != tc.match {
+			t.Error("Unexpected match for:", tc.filter, match, "expected:", tc.match)
+		}
+	}
+}
+
+func Test_TileFilterApply(t *testing.T) {
+	newLayers := func() mvt.Layers {
+		roads := geojson.NewFeatureCollection()
+		for _, class := range []string{"primary", "secondary", "primary"} {
+			f := geojson.NewFeature(orb.LineString{{0, 0}, {10, 10}})
+			f.Properties["class"] = class
+			roads.Append(f)
+		}
+		water := geojson.NewFeatureCollection()
+		water.Append(geojson.NewFeature(orb.Polygon{{{0, 0}, {10, 0}, {10, 10}, {0, 0}}}))
+		return mvt.Layers{mvt.NewLayer("roads", roads), mvt.NewLayer("water", water)}
+	}
+
+	tests := []struct {
+		query  string
+		counts map[string]int
+	}{
+		{query: "", counts: map[string]int{"roads": 3, "water": 1}},
+		{query: "layers=roads", counts: map[string]int{"roads": 3}},
+		{query: "layers=roads,%20water,foo", counts: map[string]int{"roads": 3, "water": 1}},
+		{query: "filter=class%3D'primary'", counts: map[string]int{"roads": 2}},
+		{query: "filter=class%3D'primary'%20or%20$layer%3D'water'", counts: map[string]int{"roads": 2, "water": 1}},
+		{query: "layers=water&filter=class%3D'primary'", counts: map[string]int{}},
+	}
+
+	for _, tc := range tests {
+		values, _ := url.ParseQuery(tc.query)
+		f, err := parseTileFilter(values)
+		if err != nil {
+			t.Error("parseTileFilter raised unexpected error for:", tc.query, err)
+			continue
+		}
+		if (f == nil) != (tc.query == "") {
+			t.Error("parseTileFilter returned unexpected filter for:", tc.query, f)
+		}
+		counts := map[string]int{}
+		for _, layer := range f.apply(newLayers()) {
+			counts[layer.Name] = len(layer.Features)
+		}
+		if len(counts) != len(tc.counts) {
+			t.Error("Unexpected layers for:", tc.query, counts, "expected:", tc.counts)
+			continue
+		}
+		for name, count := range tc.counts {
+			if counts[name] != count {
+				t.Error("Unexpected number of features in layer for:", tc.query, name, counts[name], "expected:", count)
+			}
+		}
+	}
+
+	values, _ := url.ParseQuery("filter=class%3D")
+	if _, err := parseTileFilter(values); err == nil {
+		t.Error("parseTileFilter did not raise expected error for invalid filter")
+	}
+}
+
+func Test_FilterVectorTile_Integers(t *testing.T) {
+	fc := geojson.NewFeatureCollection()
+	for _, rank := range []int64{1, 2} {
+		f := geojson.NewFeature(orb.Point{100, 100})
+		f.ID = uint64(rank)
+		f.Properties["rank"] = rank
+		f.Properties["offset"] = -rank
+		f.Properties["scale"] = 0.5
+		fc.Append(f)
+	}
+	data, err := encodeVectorTile(mvt.Layers{mvt.NewLayer("places", fc)})
+	if err != nil {
+		t.Fatal("Could not encode vector tile:", err)
+	}
+
+	values, _ := url.ParseQuery("filter=rank%3E1")
+	f, err := parseTileFilter(values)
+	if err != nil {
+		t.Fatal("Could not parse filter:", err)
+	}
+	filtered, err := filterVectorTile(data, f)
+	if err != nil {
+		t.Fatal("Could not filter vector tile:", err)
+	}
+
+	// filtered features keep the types of their values
+	id, types := tileValueTypes(t, filtered)
+	if id != 2 {
+		t.Error("Unexpected feature in filtered tile:", id, "expected:", 2)
+	}
+	expected := map[string]string{"rank": "uint", "offset": "int", "scale": "double"}
+	if !reflect.DeepEqual(types, expected) {
+		t.Error("Unexpected value types in filtered tile:", types, "expected:", expected)
+	}
+}
+
+func Test_FilterTileHandler(t *testing.T) {
+	svc := newTestServiceSet(t, &ServiceSetConfig{EnableTileJSON: true}, "world_cities", "geography-class-png")
+
+	countFeatures := func(path string) (int, int) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "identity")
+		svc.Handler().ServeHTTP(rec, req)
+		if rec.Code != 200 {
+			return rec.Code, 0
+		}
+		layers, err := decodeVectorTile(rec.Body.Bytes())
+		if err != nil {
+			t.Error("Could not decode tile for:", path, err)
+		}
+		count := 0
+		for _, layer := range layers {
+			count += len(layer.Features)
+		}
+		return rec.Code, count
+	}
+
+	_, total := countFeatures("/services/world_cities/tiles/1/0/0.pbf")
+	if total == 0 {
+		t.Fatal("Expected features in unfiltered tile")
+	}
+
+	tests := []struct {
+		path   string
+		status int
+		count  int
+	}{
+		{path: "/services/world_cities/tiles/1/0/0.pbf?layers=cities", status: 200, count: total},
+		{path: "/services/world_cities/tiles/1/0/0.pbf?layers=roads", status: 200, count: 0},
+		{path: "/services/world_cities/tiles/1/0/0.pbf?filter=name%3D'San%20Francisco'", status: 200, count: 1},
+		{path: "/services/world_cities/tiles/1/0/0.pbf?filter=name%20not%20in%20('San%20Francisco')", status: 200, count: total - 1},
+		{path: "/services/world_cities/tiles/1/0/0.pbf?filter=name%3D", status: 400},
+		{path: "/services/geography-class-png/tiles/1/0/0.png?layers=cities", status: 400},
+	}
+	for _, tc := range tests {
+		status, count := countFeatures(tc.path)
+		if status != tc.status || count != tc.count {
+			t.Error("Unexpected response for:", tc.path, status, count, "expected:", tc.status, tc.count)
+		}
+	}
+
+	// the filter is carried by the TileJSON and style
+	for _, version := range []string{tileJSONVersion2, tileJSONVersion3} {
+		rec := httptest.NewRecorder()
+		svc.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/services/world_cities?layers=roads&tilejson="+version, nil))
+		var tileJSON struct {
+			Tiles        []string      `json:"tiles"`
+			VectorLayers []interface{} `json:"vector_layers"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &tileJSON); err != nil {
+			t.Error("Could not parse TileJSON:", version, err)
+			continue
+		}
+		if expected := "http://example.com/services/world_cities/tiles/{z}/{x}/{y}.pbf?layers=roads&tilejson=" + version; tileJSON.Tiles[0] != expected {
+			t.Error("Unexpected tile URL in TileJSON:", version, tileJSON.Tiles[0], "expected:", expected)
+		}
+		if len(tileJSON.VectorLayers) != 0 {
+			t.Error("Expected vector_layers to be filtered in TileJSON:", version, tileJSON.VectorLayers)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	svc.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/services/world_cities/style.json?layers=roads", nil))
+	var style struct {
+		Layers []interface{} `json:"layers"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &style); err != nil || len(style.Layers) != 0 {
+		t.Error("Expected style layers to be filtered:", style.Layers, err)
+	}
+
+	for _, path := range []string{"/services/world_cities?filter=(", "/services/world_cities/style.json?filter=("} {
+		rec := httptest.NewRecorder()
+		svc.Handler().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != 400 {
+			t.Error("Unexpected status code for:", path, rec.Code, "expected:", 400)
+		}
+	}
+}
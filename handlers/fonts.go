@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paulmach/protoscan"
+)
+
+// FontsRoot is the root URL of glyph fonts served from the fonts directory
+const FontsRoot = "/fonts"
+
+// glyphRangePattern matches the name of a glyph range, for example 0-255
+var glyphRangePattern = regexp.MustCompile(`^(\d+)-(\d+)$`)
+
+// Field numbers of the glyph PBF protobuf messages
+const (
+	glyphsFieldStacks   = 1 // glyphs.stacks
+	fontstackFieldName  = 1 // fontstack.name
+	fontstackFieldRange = 2 // fontstack.range
+	fontstackFieldGlyph = 3 // fontstack.glyphs
+	glyphFieldID        = 1 // glyph.id
+)
+
+// validGlyphRange returns true if value is a range of 256 character codes
+// starting on a multiple of 256, for example 0-255 or 256-511
+func validGlyphRange(value string) bool {
+	m := glyphRangePattern.FindStringSubmatch(value)
+	if m == nil {
+		return false
+	}
+	start, err1 := strconv.Atoi(m[1])
+	end, err2 := strconv.Atoi(m[2])
+	return err1 == nil && err2 == nil && start%256 == 0 && end == start+255 && end <= 65535
+}
+
+// validFileName returns true if name can be used as the name of a file or
+// directory within a directory: it must not be empty, hidden, or contain path
+// separators.
+func validFileName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, `/\`) && filepath.Base(name) == name
+}
+
+// decodeGlyphs returns the encoded glyph messages in glyph PBF data, keyed
+// by their character code.
+func decodeGlyphs(data []byte) (map[uint32][]byte, error) {
+	glyphs := make(map[uint32][]byte)
+	msg := protoscan.New(data)
+	for msg.Next() {
+		if msg.FieldNumber() != glyphsFieldStacks {
+			msg.Skip()
+			continue
+		}
+		stackData, err := msg.MessageData()
+		if err != nil {
+			return nil, err
+		}
+
+		stack := protoscan.New(stackData)
+		for stack.Next() {
+			if stack.FieldNumber() != fontstackFieldGlyph {
+				stack.Skip()
+				continue
+			}
+			glyphData, err := stack.MessageData()
+			if err != nil {
+				return nil, err
+			}
+
+			glyph := protoscan.New(glyphData)
+			for glyph.Next() {
+				if glyph.FieldNumber() != glyphFieldID {
+					glyph.Skip()
+					continue
+				}
+				id, err := glyph.Uint32()
+				if err != nil {
+					return nil, err
+				}
+				glyphs[id] = glyphData
+				break
+			}
+			if glyph.Err() != nil {
+				return nil, glyph.Err()
+			}
+		}
+		if stack.Err() != nil {
+			return nil, stack.Err()
+		}
+	}
+	if msg.Err() != nil {
+		return nil, msg.Err()
+	}
+	return glyphs, nil
+}
+
+// appendProtoBytes appends a length delimited protobuf field to buf
+func appendProtoBytes(buf []byte, field int, data []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(field)<<3|2)
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+// combineGlyphs returns glyph PBF data for a font stack named name, with the
+// glyphs in glyphRange from each of fonts in order.  Glyphs from earlier fonts
+// take precedence over those from later fonts with the same character code.
+func combineGlyphs(name string, glyphRange string, fonts [][]byte) ([]byte, error) {
+	glyphs := make(map[uint32][]byte)
+	for _, data := range fonts {
+		fontGlyphs, err := decodeGlyphs(data)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode glyphs: %v", err)
+		}
+		for id, glyph := range fontGlyphs {
+			if _, ok := glyphs[id]; !ok {
+				glyphs[id] = glyph
+			}
+		}
+	}
+
+	ids := make([]uint32, 0, len(glyphs))
+	for id := range glyphs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var stack []byte
+	stack = appendProtoBytes(stack, fontstackFieldName, []byte(name))
+	stack = appendProtoBytes(stack, fontstackFieldRange, []byte(glyphRange))
+	for _, id := range ids {
+		stack = appendProtoBytes(stack, fontstackFieldGlyph, glyphs[id])
+	}
+	return appendProtoBytes(nil, glyphsFieldStacks, stack), nil
+}
+
+// readGlyphs returns the uncompressed glyph PBF data for glyphRange of font
+// from the fonts directory, and the time it was last modified.  Returns nil
+// if the font or range does not exist.
+func (s *ServiceSet) readGlyphs(font string, glyphRange string) ([]byte, time.Time, error) {
+	if !validFileName(font) {
+		return nil, time.Time{}, nil
+	}
+	filename := filepath.Join(s.fontsDir, font, glyphRange+".pbf")
+	stat, err := os.Stat(filename)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, time.Time{}, nil
+		}
+		return nil, time.Time{}, err
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	// glyphs may be stored compressed
+	data, err = recode(data, detectEncoding(data), encodingIdentity)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return data, stat.ModTime(), nil
+}
+
+// listFonts returns the names of the fonts in the fonts directory, which are
+// its subdirectories.
+func (s *ServiceSet) listFonts() ([]string, error) {
+	entries, err := os.ReadDir(s.fontsDir)
+	if err != nil {
+		return nil, err
+	}
+	fonts := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && validFileName(entry.Name()) {
+			fonts = append(fonts, entry.Name())
+		}
+	}
+	return fonts, nil
+}
+
+// fontsHandler is an http.HandlerFunc that returns the list of fonts at
+// /fonts, or glyphs for a font stack at /fonts/<fontstack>/<range>.pbf.  A
+// font stack is a comma-delimited list of fonts; glyphs missing from the
+// first font are added from the next font in the list that has them.
+func (s *ServiceSet) fontsHandler(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, FontsRoot), "/")
+
+	if p == "" {
+		fonts, err := s.listFonts()
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			s.logError("Could not list fonts: %v", err)
+			return
+		}
+		bytes, err := json.Marshal(fonts)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			s.logError("Could not render font list: %v", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		setCacheControl(w, s.cacheControl.ServiceList)
+		if checkNotModified(w, r, contentETag(bytes), time.Time{}) {
+			return
+		}
+		w.Write(bytes)
+		return
+	}
+
+	fontstack, filename, ok := strings.Cut(p, "/")
+	glyphRange := strings.TrimSuffix(filename, ".pbf")
+	if !ok || !strings.HasSuffix(filename, ".pbf") || !validGlyphRange(glyphRange) {
+		http.NotFound(w, r)
+		return
+	}
+
+	var names []string
+	var fonts [][]byte
+	var modTime time.Time
+	for _, font := range strings.Split(fontstack, ",") {
+		font = strings.TrimSpace(font)
+		data, fontModTime, err := s.readGlyphs(font, glyphRange)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			s.logError("Could not read glyphs for %v: %v", r.URL.Path, err)
+			return
+		}
+		if data == nil {
+			continue
+		}
+		names = append(names, font)
+		fonts = append(fonts, data)
+		if fontModTime.After(modTime) {
+			modTime = fontModTime
+		}
+	}
+	if len(fonts) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := fonts[0]
+	if len(fonts) > 1 {
+		var err error
+		data, err = combineGlyphs(strings.Join(names, ","), glyphRange, fonts)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			s.logError("Could not combine glyphs for %v: %v", r.URL.Path, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	setCacheControl(w, s.cacheControl.Tiles)
+	if checkNotModified(w, r, contentETag(data), modTime) {
+		return
+	}
+	w.Write(data)
+}
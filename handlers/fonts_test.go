@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testGlyph returns an encoded glyph message with id and width
+func testGlyph(id uint32, width uint32) []byte {
+	var glyph []byte
+	glyph = binary.AppendUvarint(glyph, glyphFieldID<<3)
+	glyph = binary.AppendUvarint(glyph, uint64(id))
+	glyph = binary.AppendUvarint(glyph, 3<<3) // glyph.width
+	glyph = binary.AppendUvarint(glyph, uint64(width))
+	return glyph
+}
+
+// testGlyphs returns glyph PBF data for font name with glyphs
+func testGlyphs(name string, glyphs ...[]byte) []byte {
+	var stack []byte
+	stack = appendProtoBytes(stack, fontstackFieldName, []byte(name))
+	stack = appendProtoBytes(stack, fontstackFieldRange, []byte("0-255"))
+	for _, glyph := range glyphs {
+		stack = appendProtoBytes(stack, fontstackFieldGlyph, glyph)
+	}
+	return appendProtoBytes(nil, glyphsFieldStacks, stack)
+}
+
+// newTestFontsDir returns a fonts directory with fonts A and B
+func newTestFontsDir(t *testing.T) string {
+	dir := t.TempDir()
+	fonts := map[string][]byte{
+		"Font A": testGlyphs("Font A", testGlyph(65, 1), testGlyph(66, 1)),
+		"Font B": testGlyphs("Font B", testGlyph(66, 2), testGlyph(67, 2)),
+	}
+	for name, data := range fonts {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name, "0-255.pbf"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func Test_ValidGlyphRange(t *testing.T) {
+	tests := []struct {
+		value string
+		valid bool
+	}{
+		{value: "0-255", valid: true},
+		{value: "256-511", valid: true},
+		{value: "65280-65535", valid: true},
+		{value: "0-256", valid: false},
+		{value: "1-256", valid: false},
+		{value: "65536-65791", valid: false},
+		{value: "0-255.pbf", valid: false},
+		{value: "a-b", valid: false},
+		{value: "", valid: false},
+	}
+
+	for _, tc := range tests {
+		if valid := validGlyphRange(tc.value); valid != tc.valid {
+			t.Error("validGlyphRange returned unexpected value for:", tc.value, valid, "expected:", tc.valid)
+		}
+	}
+}
+
+func Test_CombineGlyphs(t *testing.T) {
+	data, err := combineGlyphs("Font A,Font B", "0-255", [][]byte{
+		testGlyphs("Font A", testGlyph(65, 1), testGlyph(66, 1)),
+		testGlyphs("Font B", testGlyph(66, 2), testGlyph(67, 2)),
+	})
+	if err != nil {
+		t.Fatal("Could not combine glyphs:", err)
+	}
+
+	expected := testGlyphs("Font A,Font B", testGlyph(65, 1), testGlyph(66, 1), testGlyph(67, 2))
+	if !bytes.Equal(data, expected) {
+		t.Error("Unexpected combined glyphs:", data, "expected:", expected)
+	}
+
+	if _, err = combineGlyphs("invalid", "0-255", [][]byte{{0x0a, 0xff}}); err == nil {
+		t.Error("Expected error for invalid glyphs")
+	}
+}
+
+func Test_FontsHandler(t *testing.T) {
+	dir := newTestFontsDir(t)
+	svc := newTestServiceSet(t, &ServiceSetConfig{FontsDir: dir})
+	handler := svc.Handler()
+
+	tests := []struct {
+		path     string
+		status   int
+		expected []byte
+	}{
+		{path: "/fonts", status: 200, expected: []byte(`["Font A","Font B"]`)},
+		{path: "/fonts/Font A/0-255.pbf", status: 200, expected: testGlyphs("Font A", testGlyph(65, 1), testGlyph(66, 1))},
+		{path: "/fonts/Font%20B/0-255.pbf", status: 200, expected: testGlyphs("Font B", testGlyph(66, 2), testGlyph(67, 2))},
+		{path: "/fonts/Font B,Font A/0-255.pbf", status: 200, expected: testGlyphs("Font B,Font A", testGlyph(65, 1), testGlyph(66, 2), testGlyph(67, 2))},
+		// missing fonts in the font stack are skipped
+		{path: "/fonts/Missing,Font A/0-255.pbf", status: 200, expected: testGlyphs("Font A", testGlyph(65, 1), testGlyph(66, 1))},
+		{path: "/fonts/Missing/0-255.pbf", status: 404},
+		{path: "/fonts/Font A/256-511.pbf", status: 404},
+		{path: "/fonts/Font A/0-100.pbf", status: 404},
+		{path: "/fonts/Font A/0-255.json", status: 404},
+		{path: "/fonts/.hidden/0-255.pbf", status: 404},
+		{path: "/fonts/Font A", status: 404},
+	}
+
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.URL.Path = strings.ReplaceAll(tc.path, "%20", " ")
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Error("Unexpected status code for:", tc.path, rec.Code, "expected:", tc.status)
+			continue
+		}
+		if tc.expected != nil && !bytes.Equal(rec.Body.Bytes(), tc.expected) {
+			t.Error("Unexpected response for:", tc.path, rec.Body.Bytes(), "expected:", tc.expected)
+		}
+	}
+
+	// fonts are not available if fonts directory is not set
+	svc = newTestServiceSet(t, &ServiceSetConfig{})
+	rec := httptest.NewRecorder()
+	svc.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/fonts/Font%20A/0-255.pbf", nil))
+	if rec.Code != 404 {
+		t.Error("Expected 404 for fonts when fonts directory is not set, got:", rec.Code)
+	}
+
+	if _, err := New(&ServiceSetConfig{FontsDir: filepath.Join(dir, "missing")}); err == nil {
+		t.Error("Expected error for missing fonts directory")
+	}
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	mbtiles "github.com/brendan-ward/mbtiles-go"
+)
+
+// tileFormatFromExtension returns the tile format for a filename extension
+// from a tile URL (e.g., ".png").  Returns false if the extension is not a
+// known tile format.
+func tileFormatFromExtension(ext string) (mbtiles.TileFormat, bool) {
+	switch strings.ToLower(ext) {
+	case ".png":
+		return mbtiles.PNG, true
+	case ".jpg", ".jpeg":
+		return mbtiles.JPG, true
+	case ".webp":
+		return mbtiles.WEBP, true
+	case ".pbf", ".mvt":
+		return mbtiles.PBF, true
+	}
+	return mbtiles.UNKNOWN, false
+}
+
+// tileFormatFromMimeType returns the tile format for a MIME type.
+// Returns false if the MIME type is not a known tile format.
+func tileFormatFromMimeType(mimeType string) (mbtiles.TileFormat, bool) {
+	switch mimeType {
+	case "image/png":
+		return mbtiles.PNG, true
+	case "image/jpeg", "image/jpg":
+		return mbtiles.JPG, true
+	case "image/webp":
+		return mbtiles.WEBP, true
+	case "application/x-protobuf", "application/vnd.mapbox-vector-tile":
+		return mbtiles.PBF, true
+	}
+	return mbtiles.UNKNOWN, false
+}
+
+// isImageFormat returns true if f is an image tile format
+func isImageFormat(f mbtiles.TileFormat) bool {
+	return f == mbtiles.PNG || f == mbtiles.JPG || f == mbtiles.WEBP
+}
+
+// canTranscode returns true if tiles in format from can be converted to
+// format to.  Image tiles can be converted to PNG or JPG.
+func canTranscode(from, to mbtiles.TileFormat) bool {
+	if from == to {
+		return true
+	}
+	return isImageFormat(from) && (to == mbtiles.PNG || to == mbtiles.JPG)
+}
+
+// negotiateTileFormat returns the tile format to use for a response for a
+// tileset with tile format native, based on the Accept header of r.  The
+// native format is preferred over formats that require transcoding.
+// Returns false if none of the available formats are acceptable.
+func negotiateTileFormat(r *http.Request, native mbtiles.TileFormat) (mbtiles.TileFormat, bool) {
+	values, ok := r.Header["Accept"]
+	if !ok {
+		return native, true
+	}
+
+	specific := make(map[mbtiles.TileFormat]float64)
+	var anyImage, anyType float64 = -1, -1
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			parts := strings.Split(item, ";")
+			mimeType := strings.ToLower(strings.TrimSpace(parts[0]))
+			q := 1.0
+			for _, param := range parts[1:] {
+				if name, value, ok := strings.Cut(strings.TrimSpace(param), "="); ok && strings.TrimSpace(name) == "q" {
+					if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+						q = v
+					}
+				}
+			}
+
+			switch mimeType {
+			case "*/*":
+				anyType = q
+			case "image/*":
+				anyImage = q
+			default:
+				if f, ok := tileFormatFromMimeType(mimeType); ok {
+					specific[f] = q
+				}
+			}
+		}
+	}
+
+	quality := func(f mbtiles.TileFormat) float64 {
+		if q, ok := specific[f]; ok {
+			return q
+		}
+		if isImageFormat(f) && anyImage >= 0 {
+			return anyImage
+		}
+		if anyType >= 0 {
+			return anyType
+		}
+		return 0
+	}
+
+	best, bestQ := native, 0.0
+	for _, f := range []mbtiles.TileFormat{native, mbtiles.PNG, mbtiles.JPG} {
+		if !canTranscode(native, f) {
+			continue
+		}
+		if q := quality(f); q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+// transcodeImage converts image tile data to tile format to.
+func transcodeImage(data []byte, to mbtiles.TileFormat, quality int) ([]byte, error) {
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err = encodeImage(img, to, quality)
+	return data, err
+}
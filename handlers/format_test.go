@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"bytes"
+	"image"
+	"net/http/httptest"
+	"testing"
+
+	mbtiles "github.com/brendan-ward/mbtiles-go"
+)
+
+func Test_NegotiateTileFormat(t *testing.T) {
+	tests := []struct {
+		header   string
+		absent   bool
+		native   mbtiles.TileFormat
+		expected mbtiles.TileFormat
+		ok       bool
+	}{
+		{absent: true, native: mbtiles.PNG, expected: mbtiles.PNG, ok: true},
+		{absent: true, native: mbtiles.PBF, expected: mbtiles.PBF, ok: true},
+		{header: "*/*", native: mbtiles.JPG, expected: mbtiles.JPG, ok: true},
+		{header: "image/*", native: mbtiles.WEBP, expected: mbtiles.WEBP, ok: true},
+		{header: "image/jpeg", native: mbtiles.PNG, expected: mbtiles.JPG, ok: true},
+		{header: "image/png", native: mbtiles.JPG, expected: mbtiles.PNG, ok: true},
+		{header: "image/png", native: mbtiles.WEBP, expected: mbtiles.PNG, ok: true},
+		{header: "image/png;q=0.5, image/jpeg", native: mbtiles.PNG, expected: mbtiles.JPG, ok: true},
+		{header: "image/jpeg, image/png", native: mbtiles.PNG, expected: mbtiles.PNG, ok: true},
+		{header: "image/avif,image/webp,image/apng,image/*,*/*;q=0.8", native: mbtiles.PNG, expected: mbtiles.PNG, ok: true},
+		{header: "image/webp", native: mbtiles.PNG, ok: false},
+		{header: "image/png", native: mbtiles.PBF, ok: false},
+		{header: "application/vnd.mapbox-vector-tile", native: mbtiles.PBF, expected: mbtiles.PBF, ok: true},
+		{header: "application/x-protobuf", native: mbtiles.PNG, ok: false},
+		{header: "image/*;q=0", native: mbtiles.PNG, ok: false},
+	}
+
+	for _, tc := range tests {
+		r := httptest.NewRequest("GET", "/services/foo/tiles/0/0/0", nil)
+		if !tc.absent {
+			r.Header.Set("Accept", tc.header)
+		}
+
+		format, ok := negotiateTileFormat(r, tc.native)
+		if ok != tc.ok {
+			t.Errorf("negotiateTileFormat returned unexpected result for %q (native: %s): %v, expected: %v", tc.header, tc.native.String(), ok, tc.ok)
+			continue
+		}
+		if ok && format != tc.expected {
+			t.Errorf("negotiateTileFormat returned unexpected format for %q (native: %s): %s, expected: %s", tc.header, tc.native.String(), format.String(), tc.expected.String())
+			continue
+		}
+	}
+}
+
+func Test_TranscodeImage(t *testing.T) {
+	src, _, err := encodeImage(quadrantImage(), mbtiles.PNG, 0)
+	if err != nil {
+		t.Fatal("Could not encode PNG image:", err)
+	}
+
+	tests := []struct {
+		ext      string
+		expected mbtiles.TileFormat
+	}{
+		{ext: ".jpg", expected: mbtiles.JPG},
+		{ext: ".jpeg", expected: mbtiles.JPG},
+		{ext: ".png", expected: mbtiles.PNG},
+	}
+
+	for _, tc := range tests {
+		format, ok := tileFormatFromExtension(tc.ext)
+		if !ok || format != tc.expected {
+			t.Error("tileFormatFromExtension returned unexpected format for:", tc.ext, format.String(), "expected:", tc.expected.String())
+			continue
+		}
+
+		data, err := transcodeImage(src, format, 50)
+		if err != nil {
+			t.Error("Could not transcode image to:", tc.ext, err)
+			continue
+		}
+		_, name, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Error("Could not decode transcoded image:", err)
+			continue
+		}
+		if "image/"+name != tc.expected.MimeType() {
+			t.Error("transcodeImage returned unexpected format:", name, "expected:", tc.expected.String())
+			continue
+		}
+	}
+
+	if _, ok := tileFormatFromExtension(".gif"); ok {
+		t.Error("tileFormatFromExtension should not support .gif")
+	}
+}
@@ -0,0 +1,498 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	mbtiles "github.com/brendan-ward/mbtiles-go"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+)
+
+const (
+	// geojsonLayerProperty is the property of exported features that is set
+	// to the name of their source layer
+	geojsonLayerProperty = "vt_layer"
+
+	// defaultGeoJSONLimit is the default maximum number of features returned
+	// by a feature export
+	defaultGeoJSONLimit = 1000
+	// geojsonMaxLimit is the maximum number of features that can be
+	// requested from a feature export
+	geojsonMaxLimit = 10000
+	// geojsonMaxTiles is the maximum number of tiles read by a feature export
+	geojsonMaxTiles = 256
+
+	// geojsonJoinTolerance is the maximum distance in degrees between the
+	// ends of lines that are joined when merging features
+	geojsonJoinTolerance = 1e-9
+)
+
+// featureExport describes a request to export the features of a vector
+// tileset within a bounding box
+type featureExport struct {
+	bounds [4]float64 // [west, south, east, north] in degrees
+	zoom   int
+	limit  int
+	filter *tileFilter
+}
+
+// parseBBox parses a bounding box from a comma-delimited list of
+// <west>,<south>,<east>,<north> in degrees.
+func parseBBox(value string) ([4]float64, error) {
+	var bounds [4]float64
+	pcs := strings.Split(value, ",")
+	if len(pcs) != 4 {
+		return bounds, errors.New("bbox must be <west>,<south>,<east>,<north>")
+	}
+	for i, pc := range pcs {
+		v, err := strconv.ParseFloat(strings.TrimSpace(pc), 64)
+		if err != nil || !isFinite(v) {
+			return bounds, errors.New("bbox values must be numeric")
+		}
+		bounds[i] = v
+	}
+	if bounds[0] < -180 || bounds[2] > 180 || bounds[1] < -90 || bounds[3] > 90 {
+		return bounds, errors.New("bbox must be within -180,-90,180,90")
+	}
+	if bounds[0] >= bounds[2] || bounds[1] >= bounds[3] {
+		return bounds, errors.New("bbox minimum values must be less than maximum values")
+	}
+	return bounds, nil
+}
+
+// parseFeatureExport parses a feature export from the bbox, zoom, limit,
+// layer, and filter query parameters.  zoom defaults to defaultZoom and limit
+// to defaultGeoJSONLimit.
+func parseFeatureExport(query url.Values, defaultZoom int) (*featureExport, error) {
+	q := &featureExport{limit: defaultGeoJSONLimit}
+
+	var err error
+	if q.bounds, err = parseBBox(query.Get("bbox")); err != nil {
+		return nil, err
+	}
+	if q.zoom, err = parseQueryZoom(query.Get("zoom"), defaultZoom); err != nil {
+		return nil, err
+	}
+	if value := query.Get("limit"); value != "" {
+		if q.limit, err = strconv.Atoi(value); err != nil || q.limit < 1 || q.limit > geojsonMaxLimit {
+			return nil, fmt.Errorf("limit must be an integer between 1 and %d", geojsonMaxLimit)
+		}
+	}
+	// the layer parameter selects layers the same way as the layers
+	// parameter of tiles
+	q.filter, err = parseTileFilter(url.Values{"layers": query["layer"], "filter": query["filter"]})
+	if err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// tileFeatures returns the features of the vector tile for XYZ tile
+// coordinate tc that are selected by filter, if not nil, with geometries
+// clipped to clip (west, south, east, north in degrees) and to the tile
+// itself, excluding its buffer, and projected to WGS84.  The name of the
+// source layer of each feature is added to its properties.  Returns nil if
+// the tile does not exist.
+func (ts *Tileset) tileFeatures(tc tileCoord, filter *tileFilter, clip [4]float64) ([]*geojson.Feature, error) {
+	data, _, err := ts.getTile(tc)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	layers, err := decodeVectorTile(data)
+	if err != nil {
+		return nil, err
+	}
+	if filter != nil {
+		layers = filter.apply(layers)
+	}
+
+	// bounds to clip to in tile coordinates; mercator is monotonic on each
+	// axis, so these are a rectangle within the tile
+	x0, y0 := lonLatToTile(clip[0], clip[3], int(tc.z))
+	x1, y1 := lonLatToTile(clip[2], clip[1], int(tc.z))
+
+	features := make([]*geojson.Feature, 0)
+	for _, layer := range layers {
+		extent := float64(layer.Extent)
+		if extent == 0 {
+			extent = mvt.DefaultExtent
+		}
+		local := func(v float64, origin int64) float64 {
+			return math.Min(math.Max((v-float64(origin))*extent, 0), extent)
+		}
+		layer.Clip(orb.Bound{
+			Min: orb.Point{local(x0, tc.x), local(y0, tc.y)},
+			Max: orb.Point{local(x1, tc.x), local(y1, tc.y)},
+		})
+		projectLayerToWGS84(layer, tc)
+
+		for _, f := range layer.Features {
+			if f.Properties == nil {
+				f.Properties = make(geojson.Properties)
+			}
+			f.Properties[geojsonLayerProperty] = layer.Name
+			features = append(features, f)
+		}
+	}
+	return features, nil
+}
+
+// featureKey identifies the parts of a feature that is split across tiles
+type featureKey struct {
+	layer      string
+	id         interface{}
+	dimensions int
+}
+
+// featureMerger merges the parts of features that are split across tiles,
+// using the feature ID within each layer.  Features are returned in the order
+// they were first added.
+type featureMerger struct {
+	keys  []featureKey
+	parts map[featureKey][]*geojson.Feature
+}
+
+// newFeatureMerger returns a new, empty featureMerger
+func newFeatureMerger() *featureMerger {
+	return &featureMerger{parts: make(map[featureKey][]*geojson.Feature)}
+}
+
+// key returns the key used to merge feature f, or false if f has no ID or
+// geometry
+func (m *featureMerger) key(f *geojson.Feature) (featureKey, bool) {
+	if f.ID == nil || f.Geometry == nil {
+		return featureKey{}, false
+	}
+	layer, _ := f.Properties[geojsonLayerProperty].(string)
+	return featureKey{layer: layer, id: f.ID, dimensions: f.Geometry.Dimensions()}, true
+}
+
+// has returns true if a part of feature f has already been added
+func (m *featureMerger) has(f *geojson.Feature) bool {
+	key, ok := m.key(f)
+	if !ok {
+		return false
+	}
+	_, ok = m.parts[key]
+	return ok
+}
+
+// add adds feature f, which must have an ID and geometry, as a part of the
+// feature with its ID.
+func (m *featureMerger) add(f *geojson.Feature) {
+	key, _ := m.key(f)
+	if _, ok := m.parts[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.parts[key] = append(m.parts[key], f)
+}
+
+// len returns the number of merged features
+func (m *featureMerger) len() int {
+	return len(m.keys)
+}
+
+// features returns the merged features.  The properties of each are those of
+// its first part.
+func (m *featureMerger) features() []*geojson.Feature {
+	features := make([]*geojson.Feature, 0, len(m.keys))
+	for _, key := range m.keys {
+		parts := m.parts[key]
+		f := parts[0]
+		if len(parts) > 1 {
+			geometries := make([]orb.Geometry, len(parts))
+			for i, part := range parts {
+				geometries[i] = part.Geometry
+			}
+			f.Geometry = mergeGeometries(geometries)
+		}
+		features = append(features, f)
+	}
+	return features
+}
+
+// mergeGeometries merges the parts of a geometry that were clipped to
+// different tiles, which must all have the same dimensions.  Duplicate points
+// are removed and lines that continue across tile edges are joined; polygons
+// are combined into a MultiPolygon without dissolving their shared edges.
+func mergeGeometries(geometries []orb.Geometry) orb.Geometry {
+	switch geometries[0].Dimensions() {
+	case 0:
+		var points orb.MultiPoint
+		seen := make(map[orb.Point]bool)
+		for _, g := range geometries {
+			for _, p := range flattenPoints(g) {
+				if !seen[p] {
+					seen[p] = true
+					points = append(points, p)
+				}
+			}
+		}
+		if len(points) == 1 {
+			return points[0]
+		}
+		return points
+
+	case 1:
+		var lines []orb.LineString
+		for _, g := range geometries {
+			lines = append(lines, flattenLines(g)...)
+		}
+		lines = joinLines(lines)
+		if len(lines) == 1 {
+			return lines[0]
+		}
+		return orb.MultiLineString(lines)
+	}
+
+	var polygons orb.MultiPolygon
+	for _, g := range geometries {
+		switch g := g.(type) {
+		case orb.Polygon:
+			polygons = append(polygons, g)
+		case orb.MultiPolygon:
+			polygons = append(polygons, g...)
+		}
+	}
+	if len(polygons) == 1 {
+		return polygons[0]
+	}
+	return polygons
+}
+
+// flattenPoints returns the points of a Point or MultiPoint geometry
+func flattenPoints(g orb.Geometry) []orb.Point {
+	switch g := g.(type) {
+	case orb.Point:
+		return []orb.Point{g}
+	case orb.MultiPoint:
+		return g
+	}
+	return nil
+}
+
+// flattenLines returns the lines of a LineString or MultiLineString geometry
+func flattenLines(g orb.Geometry) []orb.LineString {
+	switch g := g.(type) {
+	case orb.LineString:
+		return []orb.LineString{g}
+	case orb.MultiLineString:
+		return g
+	}
+	return nil
+}
+
+// joinLines joins lines where the last point of one line is the first point
+// of another, within geojsonJoinTolerance.
+func joinLines(lines []orb.LineString) []orb.LineString {
+	near := func(a, b orb.Point) bool {
+		return math.Abs(a[0]-b[0]) <= geojsonJoinTolerance && math.Abs(a[1]-b[1]) <= geojsonJoinTolerance
+	}
+
+	for joined := true; joined; {
+		joined = false
+		for i := 0; i < len(lines) && !joined; i++ {
+			for j := 0; j < len(lines); j++ {
+				if i == j || len(lines[i]) == 0 || len(lines[j]) == 0 {
+					continue
+				}
+				if near(lines[i][len(lines[i])-1], lines[j][0]) {
+					lines[i] = append(lines[i], lines[j][1:]...)
+					lines = append(lines[:j], lines[j+1:]...)
+					joined = true
+					break
+				}
+			}
+		}
+	}
+	return lines
+}
+
+// featureCollectionWriter streams the features of a GeoJSON
+// FeatureCollection to an http.ResponseWriter.
+type featureCollectionWriter struct {
+	w     http.ResponseWriter
+	count int
+}
+
+// write writes feature f, preceded by the start of the FeatureCollection if
+// it is the first feature.
+func (fw *featureCollectionWriter) write(f *geojson.Feature) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	prefix := ","
+	if fw.count == 0 {
+		prefix = `{"type":"FeatureCollection","features":[`
+	}
+	fw.count++
+	if _, err = fw.w.Write([]byte(prefix)); err != nil {
+		return err
+	}
+	_, err = fw.w.Write(data)
+	return err
+}
+
+// close writes the end of the FeatureCollection, including whether it was
+// truncated to the feature limit.
+func (fw *featureCollectionWriter) close(truncated bool) error {
+	prefix := "]"
+	if fw.count == 0 {
+		prefix = `{"type":"FeatureCollection","features":[]`
+	}
+	_, err := fmt.Fprintf(fw.w, `%s,"truncated":%t}`, prefix, truncated)
+	return err
+}
+
+// writeGeoJSONTile writes the features of the vector tile for XYZ tile
+// coordinate tc to w as a GeoJSON FeatureCollection, with geometries clipped
+// to the tile and projected to WGS84.  Only the layers and features selected
+// by the layers and filter query parameters are included, if present.
+func (ts *Tileset) writeGeoJSONTile(w http.ResponseWriter, r *http.Request, tc tileCoord) {
+	filter, err := parseTileFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	features, err := ts.tileFeatures(tc, filter, worldBounds)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		ts.svc.logError("cannot read features for z=%d, x=%d, y=%d at path %v: %v", tc.z, tc.x, tc.y, r.URL.Path, err)
+		return
+	}
+	if features == nil {
+		setCacheControl(w, ts.cacheControl.MissingTiles)
+		tileNotFoundHandler(w, r, ts.tileformat, ts.tilesize, ts.svc.returnMissingImageTile404)
+		return
+	}
+
+	fc := geojson.NewFeatureCollection()
+	fc.Features = features
+	bytes, err := json.Marshal(fc)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		ts.svc.logError("Could not encode features for %v: %v", r.URL.Path, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	setCacheControl(w, ts.cacheControl.Tiles)
+	if checkNotModified(w, r, contentETag(bytes), ts.db.GetTimestamp()) {
+		return
+	}
+	w.Write(bytes)
+}
+
+// featuresHandler is an http.HandlerFunc that exports the features of a
+// vector tileset within a bounding box as a GeoJSON FeatureCollection, of the
+// form /services/<id>/features?bbox=<west>,<south>,<east>,<north>[&zoom=<zoom>][&layer=<layers>][&filter=<filter>][&limit=<n>]
+// Features are read from the tiles covering the bounding box at the zoom
+// level, which defaults to the maximum zoom level of the tileset, with
+// geometries clipped to the bounding box.  Parts of features with the same ID
+// in different tiles are merged.  Features are streamed to the client up to
+// the limit; the truncated member of the FeatureCollection is true if more
+// features are available.
+func (ts *Tileset) featuresHandler(w http.ResponseWriter, r *http.Request) {
+	if !ts.published {
+		http.NotFound(w, r)
+		return
+	}
+
+	// wait up to 30 seconds to see if tileset is ready and return it if possible
+	if ts.isLockedWithTimeout(30 * time.Second) {
+		tilesetLockedHandler(w, r)
+		return
+	}
+
+	if ts.tileformat != mbtiles.PBF {
+		http.Error(w, "feature exports are only available for vector tilesets", http.StatusBadRequest)
+		return
+	}
+
+	q, err := parseFeatureExport(r.URL.Query(), ts.maxzoom)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// native tiles have the most detail; overzoomed tiles add nothing
+	z := min(max(q.zoom, ts.minzoom), ts.maxzoom)
+	minX, minY, maxX, maxY := tileRangeForBounds(q.bounds[:], z)
+	if n := (maxX - minX + 1) * (maxY - minY + 1); n > geojsonMaxTiles {
+		http.Error(w, fmt.Sprintf("bbox covers %d tiles at zoom level %d; use a smaller bbox or lower zoom level to cover at most %d tiles", n, z, geojsonMaxTiles), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	setCacheControl(w, ts.cacheControl.Tiles)
+	if checkNotModified(w, r, "", ts.db.GetTimestamp()) {
+		return
+	}
+
+	fw := &featureCollectionWriter{w: w}
+	merger := newFeatureMerger()
+	truncated := false
+
+	writeErr := func(err error) {
+		if fw.count == 0 {
+			w.Header().Del("Content-Type")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		if !errors.Is(err, syscall.EPIPE) && !errors.Is(err, syscall.EPROTOTYPE) {
+			ts.svc.logError("Could not export features for %v: %v", r.URL.Path, err)
+		}
+	}
+
+tiles:
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			features, err := ts.tileFeatures(tileCoord{z: int64(z), x: x, y: y}, q.filter, q.bounds)
+			if err != nil {
+				writeErr(err)
+				return
+			}
+			for _, f := range features {
+				if merger.has(f) {
+					merger.add(f)
+					continue
+				}
+				if fw.count+merger.len() >= q.limit {
+					truncated = true
+					break tiles
+				}
+				if _, ok := merger.key(f); ok {
+					merger.add(f)
+					continue
+				}
+				// features without IDs cannot be merged, so are written
+				// immediately
+				if err := fw.write(f); err != nil {
+					writeErr(err)
+					return
+				}
+			}
+		}
+	}
+
+	for _, f := range merger.features() {
+		if err := fw.write(f); err != nil {
+			writeErr(err)
+			return
+		}
+	}
+	if err := fw.close(truncated); err != nil {
+		writeErr(err)
+	}
+}
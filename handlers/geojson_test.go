@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+)
+
+// newTestVectorFile creates a vector mbtiles file in a temporary directory with
+// the tiles at zoom level 1 of features, which have geometries in the
+// coordinates of the tile at zoom level 0 (4096 extent), in a layer named
+// "data".  Features are clipped to each tile and its buffer.
+func newTestVectorFile(t *testing.T, features ...*geojson.Feature) string {
+	metadata := map[string]string{
+		"name":    "Vector",
+		"format":  "pbf",
+		"minzoom": "1",
+		"maxzoom": "1",
+		"bounds":  "-180,-85.05113,180,85.05113",
+	}
+	return newTestMBTiles(t, metadata, func(insert func(z, x, y int, data []byte)) {
+		for x := 0; x < 2; x++ {
+			for y := 0; y < 2; y++ {
+				fc := geojson.NewFeatureCollection()
+				for _, f := range features {
+					g := orb.Clone(f.Geometry)
+					fc.Append(&geojson.Feature{ID: f.ID, Geometry: g, Properties: f.Properties.Clone()})
+				}
+				layer := mvt.NewLayer("data", fc)
+				overzoomLayers(mvt.Layers{layer}, 1, int64(x), int64(y))
+				if len(layer.Features) == 0 {
+					continue
+				}
+				data, err := encodeVectorTile(mvt.Layers{layer})
+				if err == nil {
+					// the tile format of mbtiles files is detected from
+					// gzipped vector tiles
+					data, err = recode(data, encodingIdentity, encodingGzip)
+				}
+				if err != nil {
+					t.Fatal("Could not encode tile:", err)
+				}
+				insert(1, x, y, data)
+			}
+		}
+	})
+}
+
+func Test_ParseBBox(t *testing.T) {
+	bounds, err := parseBBox("-100.5, 20,-90,30.25")
+	if err != nil {
+		t.Error("parseBBox raised unexpected error:", err)
+	}
+	if expected := [4]float64{-100.5, 20, -90, 30.25}; bounds != expected {
+		t.Error("Unexpected bounds:", bounds, "expected:", expected)
+	}
+
+	invalid := []string{
+		"",
+		"1,2,3",
+		"1,2,3,4,5",
+		"a,2,3,4",
+		"NaN,2,3,4",
+		"-181,0,10,10",
+		"0,0,10,91",
+		"10,0,0,10",
+		"0,10,10,10",
+	}
+	for _, value := range invalid {
+		if _, err := parseBBox(value); err == nil {
+			t.Error("parseBBox did not raise expected error for:", value)
+		}
+	}
+}
+
+func Test_ParseFeatureExport(t *testing.T) {
+	values, _ := url.ParseQuery("bbox=-10,-10,10,10&zoom=3&limit=10&layer=roads,water&filter=class%3D'primary'")
+	q, err := parseFeatureExport(values, 5)
+	if err != nil {
+		t.Fatal("parseFeatureExport raised unexpected error:", err)
+	}
+	if q.zoom != 3 || q.limit != 10 || q.filter == nil || !q.filter.includesLayer("water") || q.filter.includesLayer("cities") {
+		t.Error("Unexpected feature export:", q)
+	}
+
+	values, _ = url.ParseQuery("bbox=-10,-10,10,10")
+	if q, err = parseFeatureExport(values, 5); err != nil || q.zoom != 5 || q.limit != defaultGeoJSONLimit || q.filter != nil {
+		t.Error("Unexpected feature export with defaults:", q, err)
+	}
+
+	invalid := []string{
+		"",
+		"bbox=-10,-10,10",
+		"bbox=-10,-10,10,10&zoom=31",
+		"bbox=-10,-10,10,10&limit=0",
+		"bbox=-10,-10,10,10&limit=10001",
+		"bbox=-10,-10,10,10&filter=class%3D",
+	}
+	for _, query := range invalid {
+		values, _ := url.ParseQuery(query)
+		if _, err := parseFeatureExport(values, 5); err == nil {
+			t.Error("parseFeatureExport did not raise expected error for:", query)
+		}
+	}
+}
+
+func Test_MergeGeometries(t *testing.T) {
+	tests := []struct {
+		name       string
+		geometries []orb.Geometry
+		expected   orb.Geometry
+	}{
+		{
+			name:       "points",
+			geometries: []orb.Geometry{orb.Point{1, 2}, orb.MultiPoint{{1, 2}, {3, 4}}},
+			expected:   orb.MultiPoint{{1, 2}, {3, 4}},
+		},
+		{
+			name:       "duplicate points",
+			geometries: []orb.Geometry{orb.Point{1, 2}, orb.Point{1, 2}},
+			expected:   orb.Point{1, 2},
+		},
+		{
+			name: "joined lines",
+			geometries: []orb.Geometry{
+				orb.LineString{{1, 0}, {2, 0}},
+				orb.LineString{{0, 0}, {1, 0}},
+				orb.MultiLineString{{{2, 0}, {3, 1}}, {{5, 5}, {6, 6}}},
+			},
+			expected: orb.MultiLineString{{{0, 0}, {1, 0}, {2, 0}, {3, 1}}, {{5, 5}, {6, 6}}},
+		},
+		{
+			name: "polygons",
+			geometries: []orb.Geometry{
+				orb.Polygon{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}},
+				orb.MultiPolygon{{{{1, 0}, {2, 0}, {2, 1}, {1, 0}}}},
+			},
+			expected: orb.MultiPolygon{
+				{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}},
+				{{{1, 0}, {2, 0}, {2, 1}, {1, 0}}},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		if g := mergeGeometries(tc.geometries); !reflect.DeepEqual(g, tc.expected) {
+			t.Error("Unexpected merged geometry for:", tc.name, g, "expected:", tc.expected)
+		}
+	}
+}
+
+func Test_GeoJSONHandler(t *testing.T) {
+	svc := newTestServiceSet(t, &ServiceSetConfig{EnableGeoJSON: true}, "geography-class-png", "world_cities")
+
+	// a line and polygon that cross the edge between tiles 1/0/0 and 1/1/0,
+	// and features without IDs
+	line := geojson.NewFeature(orb.LineString{{1024, 512}, {3072, 512}})
+	line.ID = 1
+	line.Properties["class"] = "primary"
+	polygon := geojson.NewFeature(orb.Polygon{{{1024, 1024}, {3072, 1024}, {3072, 1536}, {1024, 1536}, {1024, 1024}}})
+	polygon.ID = 2
+	polygon.Properties["class"] = "park"
+	point := geojson.NewFeature(orb.Point{512, 512})
+	point.Properties["class"] = "city"
+	point2 := geojson.NewFeature(orb.Point{2560, 2560})
+	point2.Properties["class"] = "city"
+	if err := svc.AddTileset(newTestVectorFile(t, line, polygon, point, point2), "vector"); err != nil {
+		t.Fatal("Could not add tileset:", err)
+	}
+
+	type featureCollection struct {
+		Features  []*geojson.Feature `json:"features"`
+		Truncated bool               `json:"truncated"`
+	}
+	get := func(path string) (int, *featureCollection) {
+		rec := httptest.NewRecorder()
+		svc.Handler().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != 200 {
+			return rec.Code, nil
+		}
+		if contentType := rec.Header().Get("Content-Type"); contentType != "application/geo+json" {
+			t.Error("Unexpected Content-Type for:", path, contentType)
+		}
+		fc := &featureCollection{}
+		if err := json.Unmarshal(rec.Body.Bytes(), fc); err != nil {
+			t.Error("Could not parse GeoJSON for:", path, err)
+		}
+		return rec.Code, fc
+	}
+
+	// features are clipped to the tile and are not merged
+	status, fc := get("/services/vector/tiles/1/0/0.geojson")
+	if status != 200 || len(fc.Features) != 3 {
+		t.Fatal("Unexpected features in tile:", status, fc)
+	}
+	for _, f := range fc.Features {
+		if f.Properties[geojsonLayerProperty] != "data" {
+			t.Error("Expected layer property to be set:", f.Properties)
+		}
+		if b := f.Geometry.Bound(); b.Min[0] < -180 || b.Max[0] > 0 || b.Min[1] < 0 {
+			t.Error("Expected geometry to be clipped to tile:", f.Geometry)
+		}
+	}
+
+	if status, fc = get("/services/vector/tiles/1/0/0.geojson?filter=class%3D'city'"); status != 200 || len(fc.Features) != 1 {
+		t.Error("Unexpected features in filtered tile:", status, fc)
+	}
+	if status, fc = get("/services/vector/tiles/1/0/0.geojson?layers=foo"); status != 200 || len(fc.Features) != 0 {
+		t.Error("Unexpected features in filtered tile:", status, fc)
+	}
+
+	// features are merged across tiles using their IDs
+	status, fc = get("/services/vector/features?bbox=-180,-85,180,85")
+	if status != 200 || len(fc.Features) != 4 || fc.Truncated {
+		t.Fatal("Unexpected features in export:", status, fc)
+	}
+	// features without IDs are written first
+	merged := map[string]orb.Geometry{}
+	for _, f := range fc.Features[2:] {
+		merged[f.Properties["class"].(string)] = f.Geometry
+	}
+	if g, ok := merged["primary"].(orb.LineString); !ok || len(g) != 3 || g[0][0] != -90 || g[1][0] != 0 || g[2][0] != 90 {
+		t.Error("Expected line to be joined across tiles:", merged["primary"])
+	}
+	if g, ok := merged["park"].(orb.MultiPolygon); !ok || len(g) != 2 {
+		t.Error("Expected polygon parts to be combined across tiles:", merged["park"])
+	}
+
+	// geometries are clipped to the bbox
+	status, fc = get("/services/vector/features?bbox=-45,0,45,85&layer=data&filter=class%3D'primary'")
+	if status != 200 || len(fc.Features) != 1 {
+		t.Fatal("Unexpected features in clipped export:", status, fc)
+	}
+	if g, ok := fc.Features[0].Geometry.(orb.LineString); !ok || len(g) != 3 || g[0][0] != -45 || g[2][0] != 45 {
+		t.Error("Expected line to be clipped to bbox:", fc.Features[0].Geometry)
+	}
+
+	if status, fc = get("/services/vector/features?bbox=-180,-85,180,85&limit=2"); status != 200 || len(fc.Features) != 2 || !fc.Truncated {
+		t.Error("Unexpected features in limited export:", status, fc)
+	}
+
+	if status, fc = get("/services/world_cities/features?bbox=-180,-85,180,85&zoom=2&layer=cities&limit=10000"); status != 200 || len(fc.Features) == 0 || fc.Truncated {
+		t.Error("Unexpected features in export of world_cities:", status, fc)
+	}
+
+	for _, path := range []string{
+		"/services/vector/features",
+		"/services/vector/features?bbox=-180,-85,180,85&filter=(",
+		"/services/world_cities/features?bbox=-180,-85,180,85&zoom=6",
+		"/services/geography-class-png/features?bbox=-180,-85,180,85",
+	} {
+		if status, _ := get(path); status != 400 {
+			t.Error("Unexpected status code for:", path, status, "expected:", 400)
+		}
+	}
+
+	if status, _ := get("/services/vector/tiles/0/0/0.geojson"); status != 204 {
+		t.Error("Unexpected status code for missing tile:", status, "expected:", 204)
+	}
+	if status, _ := get("/services/geography-class-png/tiles/1/0/0.geojson"); status != 406 {
+		t.Error("Unexpected status code for image tileset:", status, "expected:", 406)
+	}
+
+	// GeoJSON is not available unless enabled
+	svc = newTestServiceSet(t, &ServiceSetConfig{}, "world_cities")
+	if status, _ := get("/services/world_cities/tiles/1/0/0.geojson"); status != 406 {
+		t.Error("Unexpected status code for tile when disabled:", status, "expected:", 406)
+	}
+	if status, _ := get("/services/world_cities/features?bbox=-180,-85,180,85"); status != 404 {
+		t.Error("Unexpected status code for features when disabled:", status, "expected:", 404)
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+
+	mbtiles "github.com/brendan-ward/mbtiles-go"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+	_ "golang.org/x/image/webp" // register WEBP decoder
+)
+
+// defaultJPEGQuality is the quality used when encoding JPEG tiles
+const defaultJPEGQuality = 90
+
+// decodeImage decodes a PNG, JPG, or WEBP image tile
+func decodeImage(data []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode image tile: %v", err)
+	}
+	return img, nil
+}
+
+// encodeImage encodes img using the requested tile format, and returns the
+// encoded bytes and the format actually used.  WEBP encoding is not
+// supported, so WEBP images are encoded as PNG instead.
+func encodeImage(img image.Image, format mbtiles.TileFormat, quality int) ([]byte, mbtiles.TileFormat, error) {
+	var buf bytes.Buffer
+	switch format {
+	case mbtiles.JPG:
+		if quality <= 0 {
+			quality = defaultJPEGQuality
+		}
+		// JPEG does not support transparency, so flatten onto white
+		if err := jpeg.Encode(&buf, flatten(img, color.White), &jpeg.Options{Quality: quality}); err != nil {
+			return nil, format, fmt.Errorf("could not encode JPEG image: %v", err)
+		}
+	case mbtiles.PNG, mbtiles.WEBP:
+		format = mbtiles.PNG
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, format, fmt.Errorf("could not encode PNG image: %v", err)
+		}
+	default:
+		return nil, format, fmt.Errorf("cannot encode image tiles in %q format", format.String())
+	}
+	return buf.Bytes(), format, nil
+}
+
+// flatten composites img onto a solid background color if it is not
+// already opaque.
+func flatten(img image.Image, background color.Color) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+	out := image.NewRGBA(img.Bounds())
+	draw.Draw(out, out.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(out, out.Bounds(), img, img.Bounds().Min, draw.Over)
+	return out
+}
+
+// scaleSubImage returns a new image of size x size pixels, resampled from the
+// region of src starting at pixel offset (x0, y0) with width and height of
+// span pixels.  The region does not need to fall on whole pixels.  Pixels
+// outside the region are used for interpolation along its edges.
+func scaleSubImage(src image.Image, x0, y0, span float64, size int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	b := src.Bounds()
+	k := float64(size) / span
+	s2d := f64.Aff3{
+		k, 0, -k * (x0 + float64(b.Min.X)),
+		0, k, -k * (y0 + float64(b.Min.Y)),
+	}
+	xdraw.BiLinear.Transform(dst, s2d, src, b, xdraw.Src, nil)
+	return dst
+}
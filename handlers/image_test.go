@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	mbtiles "github.com/brendan-ward/mbtiles-go"
+)
+
+// quadrantImage returns a 256px image where each quadrant has a different color
+func quadrantImage() *image.RGBA {
+	colors := []color.RGBA{
+		{255, 0, 0, 255}, {0, 255, 0, 255},
+		{0, 0, 255, 255}, {255, 255, 0, 255},
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			img.SetRGBA(x, y, colors[(y/128)*2+x/128])
+		}
+	}
+	return img
+}
+
+func Test_ScaleSubImage(t *testing.T) {
+	src := quadrantImage()
+
+	tests := []struct {
+		x0, y0, span float64
+		size         int
+		expected     color.RGBA
+	}{
+		{x0: 0, y0: 0, span: 128, size: 256, expected: color.RGBA{255, 0, 0, 255}},
+		{x0: 128, y0: 0, span: 128, size: 256, expected: color.RGBA{0, 255, 0, 255}},
+		{x0: 0, y0: 128, span: 128, size: 256, expected: color.RGBA{0, 0, 255, 255}},
+		{x0: 128, y0: 128, span: 128, size: 512, expected: color.RGBA{255, 255, 0, 255}},
+		// fractional regions, 10 zoom levels down
+		{x0: 255.75, y0: 255.75, span: 0.25, size: 256, expected: color.RGBA{255, 255, 0, 255}},
+	}
+
+	for _, tc := range tests {
+		out := scaleSubImage(src, tc.x0, tc.y0, tc.span, tc.size)
+		if out.Bounds().Dx() != tc.size || out.Bounds().Dy() != tc.size {
+			t.Error("scaleSubImage returned unexpected size:", out.Bounds(), "expected:", tc.size)
+			continue
+		}
+		c := out.RGBAAt(tc.size/2, tc.size/2)
+		if c != tc.expected {
+			t.Error("scaleSubImage returned unexpected color for region:", tc.x0, tc.y0, tc.span, ":", c, "expected:", tc.expected)
+			continue
+		}
+	}
+}
+
+func Test_EncodeImage(t *testing.T) {
+	src := quadrantImage()
+
+	tests := []struct {
+		format   mbtiles.TileFormat
+		expected mbtiles.TileFormat
+	}{
+		{format: mbtiles.PNG, expected: mbtiles.PNG},
+		{format: mbtiles.JPG, expected: mbtiles.JPG},
+		{format: mbtiles.WEBP, expected: mbtiles.PNG},
+	}
+
+	for _, tc := range tests {
+		data, format, err := encodeImage(src, tc.format, 0)
+		if err != nil {
+			t.Error("Could not encode image as:", tc.format.String(), err)
+			continue
+		}
+		if format != tc.expected {
+			t.Error("encodeImage returned unexpected format:", format.String(), "expected:", tc.expected.String())
+			continue
+		}
+		img, err := decodeImage(data)
+		if err != nil {
+			t.Error("Could not decode encoded image:", err)
+			continue
+		}
+		if img.Bounds() != src.Bounds() {
+			t.Error("encodeImage returned unexpected size:", img.Bounds())
+		}
+	}
+
+	if _, _, err := encodeImage(src, mbtiles.PBF, 0); err == nil {
+		t.Error("encodeImage did not raise error for unsupported format")
+	}
+}
@@ -63,7 +63,7 @@ func HMACAuthMiddleware(secretKey string, serviceSet *ServiceSet) HandlerWrapper
 			}
 			salt, signature := signatureParts[0], signatureParts[1]
 
-			tilesetID := serviceSet.IDFromURLPath(r.URL.Path)
+			tilesetID := serviceSet.idFromRequest(r)
 
 			key := sha1.New()
 			key.Write([]byte(salt + secretKey))
@@ -0,0 +1,607 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	mbtiles "github.com/brendan-ward/mbtiles-go"
+)
+
+// root of OGC API - Tiles is at /ogcapi
+// tilesets are served as collections at /ogcapi/collections/<id>
+
+const OGCAPIRoot = "/ogcapi"
+
+const (
+	ogcTileMatrixSet      = "WebMercatorQuad"
+	ogcTileMatrixSetTitle = "Google Maps Compatible for the World"
+	ogcTileMatrixSetURI   = "http://www.opengis.net/def/tilematrixset/OGC/1.0/WebMercatorQuad"
+	ogcWellKnownScaleSet  = "http://www.opengis.net/def/wkss/OGC/1.0/GoogleMapsCompatible"
+	ogcCRS84              = "http://www.opengis.net/def/crs/OGC/1.3/CRS84"
+	ogcCRS3857            = "http://www.opengis.net/def/crs/EPSG/0/3857"
+
+	// maximum zoom level defined for WebMercatorQuad
+	ogcTileMatrixSetMaxZoom = 24
+
+	ogcRelConformance   = "http://www.opengis.net/def/rel/ogc/1.0/conformance"
+	ogcRelData          = "http://www.opengis.net/def/rel/ogc/1.0/data"
+	ogcRelTilingSchemes = "http://www.opengis.net/def/rel/ogc/1.0/tiling-schemes"
+	ogcRelTilingScheme  = "http://www.opengis.net/def/rel/ogc/1.0/tiling-scheme"
+	ogcRelTilesets      = "http://www.opengis.net/def/rel/ogc/1.0/tilesets-"
+)
+
+var ogcConformance = []string{
+	"http://www.opengis.net/spec/ogcapi-common-1/1.0/conf/core",
+	"http://www.opengis.net/spec/ogcapi-common-1/1.0/conf/landing-page",
+	"http://www.opengis.net/spec/ogcapi-common-1/1.0/conf/json",
+	"http://www.opengis.net/spec/ogcapi-common-1/1.0/conf/html",
+	"http://www.opengis.net/spec/ogcapi-common-2/1.0/conf/collections",
+	"http://www.opengis.net/spec/ogcapi-tiles-1/1.0/conf/core",
+	"http://www.opengis.net/spec/ogcapi-tiles-1/1.0/conf/tileset",
+	"http://www.opengis.net/spec/ogcapi-tiles-1/1.0/conf/tilesets-list",
+	"http://www.opengis.net/spec/ogcapi-tiles-1/1.0/conf/geodata-tilesets",
+	"http://www.opengis.net/spec/ogcapi-tiles-1/1.0/conf/jpeg",
+	"http://www.opengis.net/spec/ogcapi-tiles-1/1.0/conf/png",
+	"http://www.opengis.net/spec/ogcapi-tiles-1/1.0/conf/mvt",
+	"http://www.opengis.net/spec/tms/2.0/conf/tilematrixset",
+	"http://www.opengis.net/spec/tms/2.0/conf/json-tilematrixset",
+	"http://www.opengis.net/spec/tms/2.0/conf/tilesetmetadata",
+	"http://www.opengis.net/spec/tms/2.0/conf/json-tilesetmetadata",
+}
+
+type ogcLink struct {
+	Href      string `json:"href"`
+	Rel       string `json:"rel"`
+	Type      string `json:"type,omitempty"`
+	Title     string `json:"title,omitempty"`
+	Templated bool   `json:"templated,omitempty"`
+}
+
+// ogcItem is an item listed in the HTML representation of a resource
+type ogcItem struct {
+	Title string
+	Href  string
+}
+
+// ogcResource is a resource of the OGC API, which can be represented as JSON
+// or HTML.
+type ogcResource struct {
+	title       string
+	description string
+	body        map[string]interface{}
+	itemsTitle  string
+	items       []ogcItem
+	modTime     time.Time
+}
+
+// ogcFormat returns the representation requested using the "f" query
+// parameter or the Accept header: "json" (default) or "html".
+func ogcFormat(r *http.Request) (string, error) {
+	switch f := r.URL.Query().Get("f"); f {
+	case "json", "html":
+		return f, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unsupported format: %q; must be json or html", f)
+	}
+
+	accept := r.Header.Get("Accept")
+	html := strings.Index(accept, "text/html")
+	jsonIndex := strings.Index(accept, "application/json")
+	if html >= 0 && (jsonIndex < 0 || html < jsonIndex) {
+		return "html", nil
+	}
+	return "json", nil
+}
+
+// ogcSelfLinks returns the self and alternate links for the resource at url
+// in the representation f
+func ogcSelfLinks(url string, f string) []ogcLink {
+	links := []ogcLink{
+		{Href: url + "?f=json", Rel: "self", Type: "application/json", Title: "This document as JSON"},
+		{Href: url + "?f=html", Rel: "alternate", Type: "text/html", Title: "This document as HTML"},
+	}
+	if f == "html" {
+		links[0].Rel, links[1].Rel = "alternate", "self"
+	}
+	return links
+}
+
+// writeOGCResource writes the resource to w as JSON or HTML depending on f
+func writeOGCResource(w http.ResponseWriter, r *http.Request, res *ogcResource, f string) error {
+	w.Header().Add("Vary", "Accept")
+
+	var out []byte
+	var err error
+	if f == "html" {
+		indented, err := json.MarshalIndent(res.body, "", "  ")
+		if err != nil {
+			return err
+		}
+		links, _ := res.body["links"].([]ogcLink)
+		data := struct {
+			Title       string
+			Description string
+			ItemsTitle  string
+			Items       []ogcItem
+			Links       []ogcLink
+			JSON        string
+		}{res.title, res.description, res.itemsTitle, res.items, links, string(indented)}
+
+		t := templates.Lookup("ogcapi")
+		if t == nil {
+			return fmt.Errorf("template not found %q", "ogcapi")
+		}
+		buf := &bytes.Buffer{}
+		if err = t.Execute(buf, data); err != nil {
+			return err
+		}
+		out = buf.Bytes()
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	} else {
+		if out, err = json.Marshal(res.body); err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+	}
+
+	if checkNotModified(w, r, contentETag(out), res.modTime) {
+		return nil
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// ogcapiHandler is an http.HandlerFunc for all OGC API - Tiles resources
+func (s *ServiceSet) ogcapiHandler(w http.ResponseWriter, r *http.Request) {
+	p := strings.Trim(strings.TrimPrefix(r.URL.Path, OGCAPIRoot), "/")
+	if p == r.URL.Path {
+		http.NotFound(w, r)
+		return
+	}
+
+	baseURL := fmt.Sprintf("%s://%s%s", scheme(r), getRequestHost(r), OGCAPIRoot)
+
+	// collections are handled by the tileset
+	if strings.HasPrefix(p, "collections/") {
+		id, rest, ok := s.splitTilesetPath(strings.TrimPrefix(p, "collections/"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		s.tilesets[id].ogcapiHandler(w, r, baseURL, rest)
+		return
+	}
+
+	f, err := ogcFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var res *ogcResource
+	switch p {
+	case "":
+		res = s.ogcLandingPage(baseURL, f)
+	case "conformance":
+		res = &ogcResource{
+			title: "Conformance",
+			body: map[string]interface{}{
+				"conformsTo": ogcConformance,
+				"links":      ogcSelfLinks(baseURL+"/conformance", f),
+			},
+		}
+	case "collections":
+		res, err = s.ogcCollections(baseURL, f)
+	case "tileMatrixSets":
+		res = ogcTileMatrixSets(baseURL, f)
+	case "tileMatrixSets/" + ogcTileMatrixSet:
+		res = ogcWebMercatorQuad(baseURL, f)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.logError("Could not create OGC API content for %v: %v", r.URL.Path, err)
+		return
+	}
+
+	setCacheControl(w, s.cacheControl.ServiceList)
+	if err = writeOGCResource(w, r, res, f); err != nil {
+		s.logError("Could not write OGC API content for %v: %v", r.URL.Path, err)
+	}
+}
+
+// ogcLandingPage returns the landing page of the OGC API
+func (s *ServiceSet) ogcLandingPage(baseURL string, f string) *ogcResource {
+	links := append(ogcSelfLinks(baseURL, f),
+		ogcLink{Href: baseURL + "/conformance", Rel: ogcRelConformance, Type: "application/json", Title: "Conformance classes"},
+		ogcLink{Href: baseURL + "/collections", Rel: ogcRelData, Type: "application/json", Title: "Collections"},
+		ogcLink{Href: baseURL + "/tileMatrixSets", Rel: ogcRelTilingSchemes, Type: "application/json", Title: "Tile matrix sets"},
+	)
+	description := "Tiles from mbtiles files"
+	return &ogcResource{
+		title:       "mbtileserver",
+		description: description,
+		body: map[string]interface{}{
+			"title":       "mbtileserver",
+			"description": description,
+			"links":       links,
+		},
+	}
+}
+
+// ogcCollections returns the list of collections, one for each tileset
+func (s *ServiceSet) ogcCollections(baseURL string, f string) (*ogcResource, error) {
+	// sort ids alpabetically
+	var ids []string
+	for id := range s.tilesets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	res := &ogcResource{
+		title:      "Collections",
+		itemsTitle: "Collections",
+	}
+	collections := []map[string]interface{}{}
+	for _, id := range ids {
+		ts := s.tilesets[id]
+		if ts.db == nil || !ts.published {
+			continue
+		}
+		collection, err := ts.ogcCollection(baseURL)
+		if err != nil {
+			return nil, fmt.Errorf("could not create collection for tileset %q: %v", id, err)
+		}
+		collections = append(collections, collection)
+		res.items = append(res.items, ogcItem{Title: ts.name, Href: baseURL + "/collections/" + id + "?f=html"})
+
+		if ts.db.GetTimestamp().After(res.modTime) {
+			res.modTime = ts.db.GetTimestamp()
+		}
+	}
+
+	res.body = map[string]interface{}{
+		"collections": collections,
+		"links":       ogcSelfLinks(baseURL+"/collections", f),
+	}
+	return res, nil
+}
+
+// ogcTileMatrixSets returns the list of supported tile matrix sets
+func ogcTileMatrixSets(baseURL string, f string) *ogcResource {
+	href := baseURL + "/tileMatrixSets/" + ogcTileMatrixSet
+	return &ogcResource{
+		title:      "Tile matrix sets",
+		itemsTitle: "Tile matrix sets",
+		items:      []ogcItem{{Title: ogcTileMatrixSetTitle, Href: href + "?f=html"}},
+		body: map[string]interface{}{
+			"tileMatrixSets": []map[string]interface{}{{
+				"id":    ogcTileMatrixSet,
+				"title": ogcTileMatrixSetTitle,
+				"uri":   ogcTileMatrixSetURI,
+				"links": []ogcLink{{Href: href, Rel: "self", Type: "application/json", Title: ogcTileMatrixSetTitle}},
+			}},
+			"links": ogcSelfLinks(baseURL+"/tileMatrixSets", f),
+		},
+	}
+}
+
+// ogcWebMercatorQuad returns the definition of the WebMercatorQuad tile
+// matrix set
+func ogcWebMercatorQuad(baseURL string, f string) *ogcResource {
+	var matrices []map[string]interface{}
+	for z := 0; z <= ogcTileMatrixSetMaxZoom; z++ {
+		n := int64(1) << uint(z)
+		cellSize := initialResolution / math.Exp2(float64(z))
+		matrices = append(matrices, map[string]interface{}{
+			"id":               strconv.Itoa(z),
+			"scaleDenominator": cellSize / wmtsPixelSize,
+			"cellSize":         cellSize,
+			"cornerOfOrigin":   "topLeft",
+			"pointOfOrigin":    []float64{-earthCircumference, earthCircumference},
+			"tileWidth":        tileSize256,
+			"tileHeight":       tileSize256,
+			"matrixWidth":      n,
+			"matrixHeight":     n,
+		})
+	}
+
+	return &ogcResource{
+		title: ogcTileMatrixSetTitle,
+		body: map[string]interface{}{
+			"id":                ogcTileMatrixSet,
+			"title":             ogcTileMatrixSetTitle,
+			"uri":               ogcTileMatrixSetURI,
+			"crs":               ogcCRS3857,
+			"orderedAxes":       []string{"X", "Y"},
+			"wellKnownScaleSet": ogcWellKnownScaleSet,
+			"tileMatrices":      matrices,
+			"links":             ogcSelfLinks(baseURL+"/tileMatrixSets/"+ogcTileMatrixSet, f),
+		},
+	}
+}
+
+// ogcapiHandler handles OGC API - Tiles resources for the collection of the
+// tileset.  p is the path within the collection.
+func (ts *Tileset) ogcapiHandler(w http.ResponseWriter, r *http.Request, baseURL string, p string) {
+	if ts == nil || !ts.published {
+		http.NotFound(w, r)
+		return
+	}
+
+	// wait up to 30 seconds to see if tileset is ready and return it if possible
+	if ts.isLockedWithTimeout(30 * time.Second) {
+		tilesetLockedHandler(w, r)
+		return
+	}
+
+	tilesetPath := "tiles/" + ogcTileMatrixSet
+	if strings.HasPrefix(p, tilesetPath+"/") {
+		// {tileMatrix}/{tileRow}/{tileCol}
+		pcs := strings.Split(strings.TrimPrefix(p, tilesetPath+"/"), "/")
+		if len(pcs) != 3 {
+			http.NotFound(w, r)
+			return
+		}
+		ts.ogcTileHandler(w, r, pcs[0], pcs[1], pcs[2])
+		return
+	}
+
+	f, err := ogcFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	collectionURL := baseURL + "/collections/" + ts.id
+	var res *ogcResource
+	switch p {
+	case "":
+		var collection map[string]interface{}
+		if collection, err = ts.ogcCollection(baseURL); err == nil {
+			collection["links"] = append(ogcSelfLinks(collectionURL, f), collection["links"].([]ogcLink)[1:]...)
+			res = &ogcResource{title: ts.name, body: collection}
+		}
+	case "tiles":
+		res = ts.ogcTilesets(baseURL, f)
+	case tilesetPath:
+		res, err = ts.ogcTileset(baseURL, f)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		ts.svc.logError("Could not create OGC API content for %v: %v", r.URL.Path, err)
+		return
+	}
+
+	if res.description == "" {
+		res.description, _ = res.body["description"].(string)
+	}
+	res.modTime = ts.db.GetTimestamp()
+	setCacheControl(w, ts.cacheControl.TileJSON)
+	if err = writeOGCResource(w, r, res, f); err != nil {
+		ts.svc.logError("Could not write OGC API content for %v: %v", r.URL.Path, err)
+	}
+}
+
+// ogcDataType returns the OGC API data type of the tileset
+func (ts *Tileset) ogcDataType() string {
+	if isImageFormat(ts.tileformat) {
+		return "map"
+	}
+	return "vector"
+}
+
+// ogcCollection returns the collection describing the tileset
+func (ts *Tileset) ogcCollection(baseURL string) (map[string]interface{}, error) {
+	metadata, err := ts.db.ReadMetadata()
+	if err != nil {
+		return nil, err
+	}
+	description, _ := metadata["description"].(string)
+	bounds := ts.bounds[:]
+
+	collectionURL := baseURL + "/collections/" + ts.id
+	dataType := ts.ogcDataType()
+	return map[string]interface{}{
+		"id":          ts.id,
+		"title":       ts.name,
+		"description": description,
+		"extent": map[string]interface{}{
+			"spatial": map[string]interface{}{
+				"bbox": [][]float64{bounds},
+				"crs":  ogcCRS84,
+			},
+		},
+		"dataType": dataType,
+		"links": []ogcLink{
+			{Href: collectionURL, Rel: "self", Type: "application/json", Title: ts.name},
+			{Href: collectionURL + "/tiles", Rel: ogcRelTilesets + dataType, Type: "application/json", Title: "Tilesets"},
+		},
+	}, nil
+}
+
+// ogcTilesets returns the list of tilesets available for the collection of
+// the tileset, which only includes WebMercatorQuad
+func (ts *Tileset) ogcTilesets(baseURL string, f string) *ogcResource {
+	collectionURL := baseURL + "/collections/" + ts.id
+	tilesetURL := collectionURL + "/tiles/" + ogcTileMatrixSet
+	return &ogcResource{
+		title:      ts.name + " tilesets",
+		itemsTitle: "Tilesets",
+		items:      []ogcItem{{Title: ogcTileMatrixSet, Href: tilesetURL + "?f=html"}},
+		body: map[string]interface{}{
+			"tilesets": []map[string]interface{}{{
+				"title":            ts.name,
+				"dataType":         ts.ogcDataType(),
+				"crs":              ogcCRS3857,
+				"tileMatrixSetURI": ogcTileMatrixSetURI,
+				"links": []ogcLink{
+					{Href: tilesetURL, Rel: "self", Type: "application/json", Title: ts.name + " " + ogcTileMatrixSet},
+					{Href: baseURL + "/tileMatrixSets/" + ogcTileMatrixSet, Rel: ogcRelTilingScheme, Type: "application/json", Title: ogcTileMatrixSetTitle},
+				},
+			}},
+			"links": ogcSelfLinks(collectionURL+"/tiles", f),
+		},
+	}
+}
+
+// ogcTileset returns the tileset metadata for the collection of the tileset
+// in the WebMercatorQuad tile matrix set, based on the TileJSON metadata of
+// the tileset.
+func (ts *Tileset) ogcTileset(baseURL string, f string) (*ogcResource, error) {
+	metadata, err := ts.db.ReadMetadata()
+	if err != nil {
+		return nil, err
+	}
+	description, _ := metadata["description"].(string)
+	bounds := ts.bounds[:]
+
+	maxzoom := ts.availableMaxZoom()
+	var limits []map[string]interface{}
+	for z := ts.minzoom; z <= maxzoom; z++ {
+		minCol, minRow, maxCol, maxRow := tileRangeForBounds(bounds, z)
+		limits = append(limits, map[string]interface{}{
+			"tileMatrix": strconv.Itoa(z),
+			"minTileRow": minRow,
+			"maxTileRow": maxRow,
+			"minTileCol": minCol,
+			"maxTileCol": maxCol,
+		})
+	}
+
+	tilesetURL := baseURL + "/collections/" + ts.id + "/tiles/" + ogcTileMatrixSet
+	links := append(ogcSelfLinks(tilesetURL, f),
+		ogcLink{Href: baseURL + "/tileMatrixSets/" + ogcTileMatrixSet, Rel: ogcRelTilingScheme, Type: "application/json", Title: ogcTileMatrixSetTitle},
+	)
+	// link to tiles in each available format
+	formats := []string{"mvt"}
+	if ts.ogcDataType() == "map" {
+		formats = []string{"png", "jpg"}
+		if ts.tileformat == mbtiles.WEBP {
+			formats = append(formats, "webp")
+		}
+	}
+	for _, format := range formats {
+		tf, _ := tileFormatFromExtension("." + format)
+		links = append(links, ogcLink{
+			Href:      tilesetURL + "/{tileMatrix}/{tileRow}/{tileCol}?f=" + format,
+			Rel:       "item",
+			Type:      tf.MimeType(),
+			Title:     "Tiles as " + strings.ToUpper(format),
+			Templated: true,
+		})
+	}
+
+	out := map[string]interface{}{
+		"title":               ts.name,
+		"description":         description,
+		"dataType":            ts.ogcDataType(),
+		"crs":                 ogcCRS3857,
+		"tileMatrixSetURI":    ogcTileMatrixSetURI,
+		"tileMatrixSetLimits": limits,
+		"boundingBox": map[string]interface{}{
+			"lowerLeft":  bounds[:2],
+			"upperRight": bounds[2:],
+			"crs":        ogcCRS84,
+		},
+		"links": links,
+	}
+
+	if attribution, ok := metadata["attribution"].(string); ok && attribution != "" {
+		out["attribution"] = attribution
+	}
+
+	out["centerPoint"] = map[string]interface{}{
+		"coordinates": ts.center[:2],
+		"tileMatrix":  strconv.Itoa(int(ts.center[2])),
+		"crs":         ogcCRS84,
+	}
+
+	if vectorLayers, ok := metadata["vector_layers"].([]interface{}); ok {
+		out["layers"] = ogcLayers(vectorLayers, ts.minzoom, ts.maxzoom)
+	}
+
+	return &ogcResource{title: ts.name, description: description, body: out}, nil
+}
+
+// ogcLayers converts TileJSON vector_layers to OGC tileset metadata layers.
+// Zoom levels not specified for a layer default to minzoom and maxzoom.
+func ogcLayers(vectorLayers []interface{}, minzoom, maxzoom int) []map[string]interface{} {
+	layers := []map[string]interface{}{}
+	for _, v := range vectorLayers {
+		vectorLayer, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id, _ := vectorLayer["id"].(string)
+
+		layer := map[string]interface{}{
+			"id":            id,
+			"dataType":      "vector",
+			"minTileMatrix": strconv.Itoa(minzoom),
+			"maxTileMatrix": strconv.Itoa(maxzoom),
+		}
+		if description, ok := vectorLayer["description"].(string); ok && description != "" {
+			layer["description"] = description
+		}
+		if z, ok := vectorLayer["minzoom"].(float64); ok {
+			layer["minTileMatrix"] = strconv.Itoa(int(z))
+		}
+		if z, ok := vectorLayer["maxzoom"].(float64); ok {
+			layer["maxTileMatrix"] = strconv.Itoa(int(z))
+		}
+
+		properties := map[string]interface{}{}
+		if fields, ok := vectorLayer["fields"].(map[string]interface{}); ok {
+			for name, fieldType := range fields {
+				t, _ := fieldType.(string)
+				switch strings.ToLower(t) {
+				case "number", "boolean", "string":
+					t = strings.ToLower(t)
+				default:
+					t = "string"
+				}
+				properties[name] = map[string]string{"type": t}
+			}
+		}
+		layer["propertiesSchema"] = map[string]interface{}{
+			"type":       "object",
+			"properties": properties,
+		}
+
+		layers = append(layers, layer)
+	}
+	return layers
+}
+
+// ogcTileHandler writes the tile at the tile matrix, row, and column in the
+// WebMercatorQuad tile matrix set, in the format requested by the "f" query
+// parameter or the Accept header.
+func (ts *Tileset) ogcTileHandler(w http.ResponseWriter, r *http.Request, tileMatrix, row, col string) {
+	tc, _, err := tileCoordFromString(tileMatrix, col, row)
+	if err != nil {
+		http.Error(w, "invalid tile coordinates", http.StatusBadRequest)
+		return
+	}
+
+	ext := ""
+	if f := r.URL.Query().Get("f"); f != "" {
+		ext = "." + f
+	}
+
+	// tiles in WebMercatorQuad are 256 pixels
+	ts.writeTile(w, r, tc, ext, ts.standardTileSize())
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_OGCAPIHandlers(t *testing.T) {
+	svc := newTestServiceSet(t, &ServiceSetConfig{EnableOGCAPI: true}, "geography-class-png", "world_cities")
+	handler := svc.Handler()
+
+	tests := []struct {
+		path        string
+		accept      string
+		status      int
+		contentType string
+		contains    []string
+	}{
+		{path: "/ogcapi", status: 200, contentType: "application/json", contains: []string{
+			`"href":"http://example.com/ogcapi/collections"`,
+			`"href":"http://example.com/ogcapi/tileMatrixSets"`,
+		}},
+		{path: "/ogcapi", accept: "text/html,application/xhtml+xml", status: 200, contentType: "text/html; charset=utf-8", contains: []string{
+			`<h1>mbtileserver</h1>`,
+		}},
+		{path: "/ogcapi?f=html", status: 200, contentType: "text/html; charset=utf-8"},
+		{path: "/ogcapi?f=xml", status: 400},
+		{path: "/ogcapi/conformance", status: 200, contentType: "application/json", contains: []string{
+			`"http://www.opengis.net/spec/ogcapi-tiles-1/1.0/conf/core"`,
+		}},
+		{path: "/ogcapi/collections", status: 200, contentType: "application/json", contains: []string{
+			`"id":"geography-class-png"`,
+			`"id":"world_cities"`,
+			`"rel":"http://www.opengis.net/def/rel/ogc/1.0/tilesets-vector"`,
+		}},
+		{path: "/ogcapi/collections/world_cities", status: 200, contentType: "application/json", contains: []string{
+			`"dataType":"vector"`,
+			`"crs":"http://www.opengis.net/def/crs/OGC/1.3/CRS84"`,
+		}},
+		{path: "/ogcapi/collections/world_cities/tiles", status: 200, contentType: "application/json", contains: []string{
+			`"tileMatrixSetURI":"http://www.opengis.net/def/tilematrixset/OGC/1.0/WebMercatorQuad"`,
+		}},
+		{path: "/ogcapi/collections/world_cities/tiles/WebMercatorQuad", status: 200, contentType: "application/json", contains: []string{
+			`"id":"cities"`,
+			`"name":{"type":"string"}`,
+			`{"maxTileCol":0,"maxTileRow":0,"minTileCol":0,"minTileRow":0,"tileMatrix":"0"}`,
+			`"href":"http://example.com/ogcapi/collections/world_cities/tiles/WebMercatorQuad/{tileMatrix}/{tileRow}/{tileCol}?f=mvt"`,
+		}},
+		{path: "/ogcapi/collections/geography-class-png/tiles/WebMercatorQuad", status: 200, contentType: "application/json", contains: []string{
+			`"dataType":"map"`,
+			`?f=png"`,
+			`?f=jpg"`,
+		}},
+		{path: "/ogcapi/tileMatrixSets/WebMercatorQuad", status: 200, contentType: "application/json", contains: []string{
+			`"scaleDenominator":559082264.0287178`,
+			`"cornerOfOrigin":"topLeft"`,
+		}},
+		{path: "/ogcapi/collections/geography-class-png/tiles/WebMercatorQuad/1/0/1?f=png", status: 200, contentType: "image/png"},
+		{path: "/ogcapi/collections/geography-class-png/tiles/WebMercatorQuad/1/0/1?f=jpg", status: 200, contentType: "image/jpeg"},
+		{path: "/ogcapi/collections/geography-class-png/tiles/WebMercatorQuad/1/0/1", accept: "image/jpeg", status: 200, contentType: "image/jpeg"},
+		{path: "/ogcapi/collections/world_cities/tiles/WebMercatorQuad/0/0/0?f=mvt", status: 200, contentType: "application/x-protobuf"},
+		{path: "/ogcapi/collections/world_cities/tiles/WebMercatorQuad/0/0/0?f=png", status: 406},
+		{path: "/ogcapi/collections/world_cities/tiles/WebMercatorQuad/0/0/foo", status: 400},
+		{path: "/ogcapi/collections/world_cities/tiles/WebMercatorQuad/-1/0/0", status: 400},
+		{path: "/ogcapi/collections/foo", status: 404},
+		{path: "/ogcapi/collections/world_cities/foo", status: 404},
+		{path: "/ogcapi/foo", status: 404},
+	}
+
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", tc.path, nil)
+		if tc.accept != "" {
+			r.Header.Set("Accept", tc.accept)
+		}
+		handler.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Error("Unexpected status code for:", tc.path, w.Code, "expected:", tc.status)
+			continue
+		}
+		if tc.contentType != "" && w.Header().Get("Content-Type") != tc.contentType {
+			t.Error("Unexpected content type for:", tc.path, w.Header().Get("Content-Type"), "expected:", tc.contentType)
+			continue
+		}
+		for _, s := range tc.contains {
+			if !strings.Contains(w.Body.String(), s) {
+				t.Errorf("Response for %s does not contain %q", tc.path, s)
+			}
+		}
+	}
+
+	ids := []struct {
+		path string
+		id   string
+	}{
+		{path: "/ogcapi/collections/world_cities", id: "world_cities"},
+		{path: "/ogcapi/collections/world_cities/tiles/WebMercatorQuad/0/0/0", id: "world_cities"},
+		{path: "/ogcapi/collections/foo/tiles", id: ""},
+		{path: "/ogcapi/collections", id: ""},
+	}
+	for _, tc := range ids {
+		if id := svc.IDFromURLPath(tc.path); id != tc.id {
+			t.Error("IDFromURLPath returned unexpected ID for:", tc.path, id, "expected:", tc.id)
+		}
+	}
+}
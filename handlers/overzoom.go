@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	mbtiles "github.com/brendan-ward/mbtiles-go"
+)
+
+// defaultOverzoomMaxZoom is the maximum zoom level that tiles are synthesized
+// for when overzoom is enabled, unless otherwise configured.
+const defaultOverzoomMaxZoom = 22
+
+// findAncestor searches for the nearest ancestor of XYZ tile coordinate tc
+// that exists in the tileset, starting from the tileset's maximum zoom level
+// or the parent of tc (whichever is lower) down to its minimum zoom level.
+// Returns the coordinate and data of the ancestor, or nil data if none exists.
+func (ts *Tileset) findAncestor(tc tileCoord) (tileCoord, []byte, error) {
+	z := tc.z - 1
+	if z > int64(ts.maxzoom) {
+		z = int64(ts.maxzoom)
+	}
+	for ; z >= int64(ts.minzoom) && z >= 0; z-- {
+		dz := uint64(tc.z - z)
+		parent := tileCoord{z: z, x: tc.x >> dz, y: tc.y >> dz}
+		data, err := ts.readTile(parent)
+		if err != nil {
+			return parent, nil, err
+		}
+		if data != nil {
+			return parent, data, nil
+		}
+	}
+	return tc, nil, nil
+}
+
+// overzoomTile synthesizes a tile for XYZ tile coordinate tc, which is either
+// above the maximum zoom level of the tileset or missing within it, from its
+// nearest ancestor tile.
+//
+// Image tiles are created by cropping the corresponding region of the
+// ancestor and resampling it to the tile size of the tileset.  Vector tiles
+// are created by clipping the geometries of the ancestor to the region and
+// rescaling them to the default extent of 4096.
+//
+// Returns the encoded tile and its format, which is PNG for WEBP tilesets.
+// Vector tiles are returned uncompressed.
+// Returns nil data if the tile cannot be synthesized.
+func (ts *Tileset) overzoomTile(tc tileCoord) ([]byte, mbtiles.TileFormat, error) {
+	switch ts.tileformat {
+	case mbtiles.PNG, mbtiles.JPG, mbtiles.WEBP, mbtiles.PBF:
+	default:
+		return nil, ts.tileformat, nil
+	}
+
+	if tc.z <= int64(ts.minzoom) || tc.z > int64(ts.overzoomMaxZoom) {
+		return nil, ts.tileformat, nil
+	}
+
+	parent, data, err := ts.findAncestor(tc)
+	if err != nil || data == nil {
+		return nil, ts.tileformat, err
+	}
+
+	// offset of tc within the descendants of parent at zoom level of tc
+	dz := uint64(tc.z - parent.z)
+	dx, dy := tc.x-parent.x<<dz, tc.y-parent.y<<dz
+
+	if ts.tileformat == mbtiles.PBF {
+		layers, err := decodeVectorTile(data)
+		if err != nil {
+			return nil, ts.tileformat, err
+		}
+		overzoomLayers(layers, dz, dx, dy)
+		data, err = encodeVectorTile(layers)
+		return data, ts.tileformat, err
+	}
+
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil, ts.tileformat, err
+	}
+
+	size := int(ts.tilesize)
+	if size == 0 {
+		size = img.Bounds().Dx()
+	}
+
+	// the region of the ancestor covered by tc, in pixels
+	span := float64(img.Bounds().Dx()) / float64(uint64(1)<<dz)
+	x0 := float64(dx) * span
+	y0 := float64(dy) * span
+
+	return encodeImage(scaleSubImage(img, x0, y0, span, size), ts.tileformat, ts.svc.jpegQuality)
+}
@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// elevation encodings of raster DEM tilesets, set using the "encoding"
+	// metadata key
+	demEncodingMapbox    = "mapbox"
+	demEncodingTerrarium = "terrarium"
+
+	// defaultPixelSamples is the default number of locations sampled along
+	// a line
+	defaultPixelSamples = 100
+	// pixelMaxSamples is the maximum number of locations sampled along a
+	// line
+	pixelMaxSamples = 1000
+)
+
+// demEncodingFromMetadata returns the elevation encoding of a raster DEM
+// tileset from the "encoding" metadata key: "mapbox" (Terrain-RGB) or
+// "terrarium".  Returns an empty string if there is no supported encoding.
+func demEncodingFromMetadata(metadata map[string]interface{}) string {
+	value, _ := metadata["encoding"].(string)
+	switch value = strings.ToLower(strings.TrimSpace(value)); value {
+	case demEncodingMapbox, demEncodingTerrarium:
+		return value
+	}
+	return ""
+}
+
+// decodeElevation returns the elevation in meters encoded in color c using
+// encoding.  Returns false if c is transparent.
+func decodeElevation(encoding string, c color.NRGBA) (float64, bool) {
+	if c.A == 0 {
+		return 0, false
+	}
+	r, g, b := float64(c.R), float64(c.G), float64(c.B)
+	if encoding == demEncodingTerrarium {
+		return r*256 + g + b/256 - 32768, true
+	}
+	return -10000 + (r*256*256+g*256+b)*0.1, true
+}
+
+// pixelReader reads pixels from the image tiles of a tileset, keeping the
+// most recently read tile so that consecutive nearby pixels, such as along a
+// line, can be read from the same tile without keeping every tile in memory.
+type pixelReader struct {
+	ts *Tileset
+
+	// most recently read tile; img is nil if the tile does not exist
+	tile    tileCoord
+	img     image.Image
+	hasTile bool
+
+	// most recently read elevation tile; elevation is nil if the tile does
+	// not exist
+	elevationTile tileCoord
+	elevation     *elevationTile
+	hasElevation  bool
+}
+
+// newPixelReader returns a new pixelReader for tileset ts
+func newPixelReader(ts *Tileset) *pixelReader {
+	return &pixelReader{ts: ts}
+}
+
+// read returns the color of the pixel at a longitude and latitude in the tile
+// at zoom level z that contains it.  Returns false if the tile does not exist.
+func (pr *pixelReader) read(lon, lat float64, z int) (color.NRGBA, bool, error) {
+	tc, fx, fy := tileAt(lon, lat, z)
+	if !pr.hasTile || pr.tile != tc {
+		img, err := pr.ts.readImage(tc)
+		if err != nil {
+			return color.NRGBA{}, false, err
+		}
+		pr.tile, pr.img, pr.hasTile = tc, img, true
+	}
+	img := pr.img
+	if img == nil {
+		return color.NRGBA{}, false, nil
+	}
+
+	b := img.Bounds()
+	x := min(b.Min.X+int(fx*float64(b.Dx())), b.Max.X-1)
+	y := min(b.Min.Y+int(fy*float64(b.Dy())), b.Max.Y-1)
+	return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA), true, nil
+}
+
+// readElevation returns the elevation of the pixel at a longitude and
+// latitude in the elevation tile at zoom level z that contains it.  Returns
+// NaN if the tile does not exist or the pixel is transparent.
+func (pr *pixelReader) readElevation(lon, lat float64, z int) (float64, error) {
+	tc, fx, fy := tileAt(lon, lat, z)
+	if !pr.hasElevation || pr.elevationTile != tc {
+		e, err := pr.ts.readElevation(tc)
+		if err != nil {
+			return math.NaN(), err
+		}
+		pr.elevationTile, pr.elevation, pr.hasElevation = tc, e, true
+	}
+	e := pr.elevation
+	if e == nil {
+		return math.NaN(), nil
+	}
+	return e.at(int(fx*float64(e.width)), int(fy*float64(e.height))), nil
+}
+
+// value returns the value of the pixel at a longitude and latitude at
+// zoom level z: the elevation if the tileset has an elevation encoding,
+// otherwise the [red, green, blue, alpha] values.  Returns nil if the tile
+// does not exist or, for elevation, the pixel is transparent.
+func (pr *pixelReader) value(lon, lat float64, z int) (interface{}, error) {
+	if pr.ts.demEncoding != "" {
+		elevation, err := pr.readElevation(lon, lat, z)
+		if err != nil || math.IsNaN(elevation) {
+			return nil, err
+		}
+		// round to the precision of the encodings
+		return math.Round(elevation*1000) / 1000, nil
+	}
+
+	c, ok, err := pr.read(lon, lat, z)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return []int{int(c.R), int(c.G), int(c.B), int(c.A)}, nil
+}
+
+// parseLine parses a line from a comma-delimited list of longitude and
+// latitude pairs: <lon>,<lat>,<lon>,<lat>,...
+func parseLine(value string) ([][2]float64, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) < 4 || len(parts)%2 != 0 {
+		return nil, fmt.Errorf("line must be at least two comma-delimited <lon>,<lat> pairs")
+	}
+	line := make([][2]float64, 0, len(parts)/2)
+	for i := 0; i < len(parts); i += 2 {
+		lon, lat, err := parseLonLat(parts[i], parts[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid line: %v", err)
+		}
+		line = append(line, [2]float64{lon, lat})
+	}
+	return line, nil
+}
+
+// haversineDistance returns the great circle distance in meters between two
+// longitude and latitude locations
+func haversineDistance(a, b [2]float64) float64 {
+	lat1, lat2 := a[1]*math.Pi/180, b[1]*math.Pi/180
+	dlat := lat2 - lat1
+	dlon := (b[0] - a[0]) * math.Pi / 180
+	h := math.Pow(math.Sin(dlat/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(dlon/2), 2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// sampleLine returns n locations evenly spaced by distance along line,
+// including its first and last locations, and the distance of each along
+// the line in meters.  Locations are interpolated linearly in longitude and
+// latitude within each segment of the line.
+func sampleLine(line [][2]float64, n int) ([][2]float64, []float64) {
+	cumulative := make([]float64, len(line))
+	for i := 1; i < len(line); i++ {
+		cumulative[i] = cumulative[i-1] + haversineDistance(line[i-1], line[i])
+	}
+	total := cumulative[len(line)-1]
+
+	points := make([][2]float64, n)
+	distances := make([]float64, n)
+	segment := 1
+	for i := 0; i < n; i++ {
+		d := total * float64(i) / float64(n-1)
+		for segment < len(line)-1 && cumulative[segment] < d {
+			segment++
+		}
+		a, b := line[segment-1], line[segment]
+		t := 0.0
+		if length := cumulative[segment] - cumulative[segment-1]; length > 0 {
+			t = math.Min(math.Max((d-cumulative[segment-1])/length, 0), 1)
+		}
+		points[i] = [2]float64{a[0] + (b[0]-a[0])*t, a[1] + (b[1]-a[1])*t}
+		distances[i] = d
+	}
+	return points, distances
+}
+
+// pixelQuery describes a query for the pixel values of an image tileset at
+// a single location or at locations sampled along a line
+type pixelQuery struct {
+	points [][2]float64
+	// distance of each location along the line, or nil for a single location
+	distances []float64
+	zoom      int
+}
+
+// parsePixelQuery parses a pixel query from the lon and lat query parameters
+// for a single location, or from the line and samples query parameters for
+// locations along a line, and the zoom query parameter, which defaults to
+// defaultZoom.
+func parsePixelQuery(query url.Values, defaultZoom int) (*pixelQuery, error) {
+	q := &pixelQuery{}
+
+	var err error
+	if q.zoom, err = parseQueryZoom(query.Get("zoom"), defaultZoom); err != nil {
+		return nil, err
+	}
+
+	if value := query.Get("line"); value != "" {
+		line, err := parseLine(value)
+		if err != nil {
+			return nil, err
+		}
+		n := defaultPixelSamples
+		if value := query.Get("samples"); value != "" {
+			if n, err = strconv.Atoi(value); err != nil || n < 2 || n > pixelMaxSamples {
+				return nil, fmt.Errorf("samples must be an integer between 2 and %d", pixelMaxSamples)
+			}
+		}
+		q.points, q.distances = sampleLine(line, n)
+		return q, nil
+	}
+
+	lon, lat, err := parseLonLat(query.Get("lon"), query.Get("lat"))
+	if err != nil {
+		return nil, err
+	}
+	q.points = [][2]float64{{lon, lat}}
+	return q, nil
+}
+
+// pixelValues returns the response to pixel query q: the location, zoom
+// level, and value of a single location, or the zoom level and samples of
+// each location along a line, including the distance along the line in
+// meters.  Values are returned as elevation if the tileset has an elevation
+// encoding, otherwise as rgba.
+func (ts *Tileset) pixelValues(q *pixelQuery) (map[string]interface{}, error) {
+	z := ts.queryZoom(q.zoom)
+
+	key := "rgba"
+	if ts.demEncoding != "" {
+		key = "elevation"
+	}
+
+	pr := newPixelReader(ts)
+	samples := make([]map[string]interface{}, len(q.points))
+	for i, p := range q.points {
+		value, err := pr.value(p[0], p[1], z)
+		if err != nil {
+			return nil, err
+		}
+		samples[i] = map[string]interface{}{
+			"lon": p[0],
+			"lat": p[1],
+			key:   value,
+		}
+		if q.distances != nil {
+			samples[i]["distance"] = math.Round(q.distances[i]*100) / 100
+		}
+	}
+
+	if q.distances == nil {
+		samples[0]["zoom"] = z
+		return samples[0], nil
+	}
+	return map[string]interface{}{
+		"zoom":    z,
+		"samples": samples,
+	}, nil
+}
+
+// pixelHandler is an http.HandlerFunc that returns the value of a pixel of an
+// image tileset at a location, or at locations along a line, of the form
+// /services/<id>/pixel?lon=<lon>&lat=<lat>[&zoom=<zoom>] or
+// /services/<id>/pixel?line=<lon>,<lat>,<lon>,<lat>,...[&samples=<n>][&zoom=<zoom>]
+// The value is the elevation for tilesets with an elevation encoding,
+// otherwise the RGBA values of the pixel.
+func (ts *Tileset) pixelHandler(w http.ResponseWriter, r *http.Request) {
+	if !ts.published {
+		http.NotFound(w, r)
+		return
+	}
+
+	// wait up to 30 seconds to see if tileset is ready and return it if possible
+	if ts.isLockedWithTimeout(30 * time.Second) {
+		tilesetLockedHandler(w, r)
+		return
+	}
+
+	if !isImageFormat(ts.tileformat) {
+		http.Error(w, "pixel queries are only available for image tilesets", http.StatusBadRequest)
+		return
+	}
+
+	q, err := parsePixelQuery(r.URL.Query(), ts.availableMaxZoom())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	out, err := ts.pixelValues(q)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		ts.svc.logError("Could not read pixel values for %v: %v", r.URL.Path, err)
+		return
+	}
+
+	bytes, err := json.Marshal(out)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		ts.svc.logError("Could not encode pixel values for %v: %v", r.URL.Path, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	setCacheControl(w, ts.cacheControl.Tiles)
+	if checkNotModified(w, r, contentETag(bytes), ts.db.GetTimestamp()) {
+		return
+	}
+	w.Write(bytes)
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// encodeTestElevation encodes elevation e in meters as a color using encoding
+func encodeTestElevation(encoding string, e float64) color.NRGBA {
+	if encoding == demEncodingTerrarium {
+		v := e + 32768
+		i := int(v)
+		return color.NRGBA{uint8(i >> 8), uint8(i), uint8((v - float64(i)) * 256), 255}
+	}
+	v := int(math.Round((e + 10000) * 10))
+	return color.NRGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}
+}
+
+// newTestMBTiles creates an mbtiles file with metadata in a temporary
+// directory.  Tiles are written by calling tiles with a function that inserts
+// the tile data at XYZ tile z, x, y.  Returns the filename.
+func newTestMBTiles(t *testing.T, metadata map[string]string, tiles func(insert func(z, x, y int, data []byte))) string {
+	filename := filepath.Join(t.TempDir(), metadata["name"]+".mbtiles")
+	con, err := sqlite.OpenConn(filename, sqlite.SQLITE_OPEN_READWRITE|sqlite.SQLITE_OPEN_CREATE)
+	if err != nil {
+		t.Fatal("Could not create mbtiles file:", err)
+	}
+	defer con.Close()
+
+	err = sqlitex.ExecScript(con, `
+		CREATE TABLE metadata (name text, value text);
+		CREATE TABLE tiles (zoom_level integer, tile_column integer, tile_row integer, tile_data blob);
+	`)
+	if err != nil {
+		t.Fatal("Could not create mbtiles file:", err)
+	}
+	for k, v := range metadata {
+		if err = sqlitex.Exec(con, "INSERT INTO metadata VALUES (?, ?)", nil, k, v); err != nil {
+			t.Fatal("Could not write metadata:", err)
+		}
+	}
+
+	tiles(func(z, x, y int, data []byte) {
+		// flip y to the TMS row order of mbtiles
+		if err := sqlitex.Exec(con, "INSERT INTO tiles VALUES (?, ?, ?, ?)", nil, z, x, (1<<z)-1-y, data); err != nil {
+			t.Fatal("Could not write tile:", err)
+		}
+	})
+	return filename
+}
+
+// newTestDEMFile creates an mbtiles file of a raster DEM tileset using
+// encoding, with 256 pixel PNG tiles at zoom levels 0 and 1.  The elevation of
+// each pixel is elevation(x, y), where x and y are its pixel column and row
+// in the web mercator tile grid at its zoom level.  Returns the filename.
+func newTestDEMFile(t *testing.T, encoding string, elevation func(x, y int) float64) string {
+	metadata := map[string]string{
+		"name":     "DEM",
+		"format":   "png",
+		"encoding": encoding,
+		"minzoom":  "0",
+		"maxzoom":  "1",
+		"bounds":   "-180,-85.05113,180,85.05113",
+	}
+	return newTestMBTiles(t, metadata, func(insert func(z, x, y int, data []byte)) {
+		for z := 0; z <= 1; z++ {
+			n := 1 << z
+			for x := 0; x < n; x++ {
+				for y := 0; y < n; y++ {
+					img := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+					for py := 0; py < 256; py++ {
+						for px := 0; px < 256; px++ {
+							img.SetNRGBA(px, py, encodeTestElevation(encoding, elevation(x*256+px, y*256+py)))
+						}
+					}
+					var buf bytes.Buffer
+					if err := png.Encode(&buf, img); err != nil {
+						t.Fatal("Could not encode tile:", err)
+					}
+					insert(z, x, y, buf.Bytes())
+				}
+			}
+		}
+	})
+}
+
+func Test_DecodeElevation(t *testing.T) {
+	tests := []struct {
+		encoding  string
+		c         color.NRGBA
+		elevation float64
+		ok        bool
+	}{
+		{encoding: demEncodingMapbox, c: color.NRGBA{1, 134, 160, 255}, elevation: 0, ok: true},
+		{encoding: demEncodingMapbox, c: color.NRGBA{1, 138, 136, 255}, elevation: 100, ok: true},
+		{encoding: demEncodingMapbox, c: color.NRGBA{0, 0, 0, 255}, elevation: -10000, ok: true},
+		{encoding: demEncodingTerrarium, c: color.NRGBA{128, 0, 0, 255}, elevation: 0, ok: true},
+		{encoding: demEncodingTerrarium, c: color.NRGBA{128, 100, 128, 255}, elevation: 100.5, ok: true},
+		{encoding: demEncodingTerrarium, c: color.NRGBA{127, 156, 0, 255}, elevation: -100, ok: true},
+		{encoding: demEncodingMapbox, c: color.NRGBA{1, 134, 160, 0}, ok: false},
+	}
+
+	for _, tc := range tests {
+		elevation, ok := decodeElevation(tc.encoding, tc.c)
+		if ok != tc.ok || math.Abs(elevation-tc.elevation) > 1e-6 {
+			t.Error("decodeElevation returned unexpected value for:", tc.encoding, tc.c, elevation, ok, "expected:", tc.elevation, tc.ok)
+		}
+	}
+}
+
+func Test_DEMEncodingFromMetadata(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		encoding string
+	}{
+		{value: "mapbox", encoding: demEncodingMapbox},
+		{value: " Terrarium ", encoding: demEncodingTerrarium},
+		{value: "other", encoding: ""},
+		{value: 1, encoding: ""},
+		{value: nil, encoding: ""},
+	}
+
+	for _, tc := range tests {
+		if encoding := demEncodingFromMetadata(map[string]interface{}{"encoding": tc.value}); encoding != tc.encoding {
+			t.Error("demEncodingFromMetadata returned unexpected encoding for:", tc.value, encoding, "expected:", tc.encoding)
+		}
+	}
+}
+
+func Test_SampleLine(t *testing.T) {
+	line := [][2]float64{{0, 0}, {1, 0}, {1, 1}}
+	points, distances := sampleLine(line, 5)
+
+	expected := [][2]float64{{0, 0}, {0.5, 0}, {1, 0}, {1, 0.5}, {1, 1}}
+	for i, p := range points {
+		if !floatsEqual(p[:], expected[i][:]) {
+			t.Error("sampleLine returned unexpected location:", i, p, "expected:", expected[i])
+		}
+	}
+	segment := haversineDistance(line[0], line[1])
+	if !floatsEqual(distances, []float64{0, segment / 2, segment, segment * 1.5, segment * 2}) {
+		t.Error("sampleLine returned unexpected distances:", distances)
+	}
+	// one degree of latitude
+	if math.Abs(segment-111319.49) > 0.01 {
+		t.Error("haversineDistance returned unexpected distance:", segment)
+	}
+}
+
+func Test_ParsePixelQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		zoom    int
+		samples int
+		err     bool
+	}{
+		{query: "lon=-100&lat=40", zoom: 1, samples: 1},
+		{query: "lon=-100&lat=40&zoom=0", zoom: 0, samples: 1},
+		{query: "line=-100,40,0,0", zoom: 1, samples: defaultPixelSamples},
+		{query: "line=-100,40,0,0,10,10&samples=2", zoom: 1, samples: 2},
+		{query: "lon=-100", err: true},
+		{query: "lon=-100&lat=40&zoom=-1", err: true},
+		{query: "line=-100,40", err: true},
+		{query: "line=-100,40,0", err: true},
+		{query: "line=-100,40,0,90", err: true},
+		{query: "line=-100,40,0,0&samples=1", err: true},
+		{query: "line=-100,40,0,0&samples=1001", err: true},
+	}
+
+	for _, tc := range tests {
+		values, _ := url.ParseQuery(tc.query)
+		q, err := parsePixelQuery(values, 1)
+		if tc.err {
+			if err == nil {
+				t.Error("parsePixelQuery did not raise expected error for:", tc.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Error("parsePixelQuery raised unexpected error for:", tc.query, err)
+			continue
+		}
+		if q.zoom != tc.zoom || len(q.points) != tc.samples {
+			t.Error("parsePixelQuery returned unexpected query for:", tc.query, q.zoom, len(q.points))
+		}
+	}
+}
+
+func Test_PixelHandler(t *testing.T) {
+	svc := newTestServiceSet(t, &ServiceSetConfig{EnableQuery: true, EnableTileJSON: true}, "geography-class-png", "world_cities")
+	elevation := func(x, y int) float64 { return float64(x) * 10.5 }
+	for _, encoding := range []string{demEncodingMapbox, demEncodingTerrarium} {
+		if err := svc.AddTileset(newTestDEMFile(t, encoding, elevation), encoding); err != nil {
+			t.Fatal("Could not add tileset:", err)
+		}
+	}
+
+	tests := []struct {
+		path     string
+		status   int
+		expected string
+	}{
+		{path: "/services/geography-class-png/pixel?lon=-100&lat=40&zoom=0", status: 200, expected: `{"lat":40,"lon":-100,"rgba":[244,221,99,255],"zoom":0}`},
+		// zoom is limited to the zoom levels of the tileset; pixel column 128
+		{path: "/services/mapbox/pixel?lon=0.1&lat=0&zoom=0", status: 200, expected: `{"elevation":1344,"lat":0,"lon":0.1,"zoom":0}`},
+		// pixel column 256
+		{path: "/services/mapbox/pixel?lon=0.1&lat=0&zoom=5", status: 200, expected: `{"elevation":2688,"lat":0,"lon":0.1,"zoom":1}`},
+		{path: "/services/terrarium/pixel?lon=0.1&lat=0", status: 200, expected: `{"elevation":2688,"lat":0,"lon":0.1,"zoom":1}`},
+		{path: "/services/terrarium/pixel?line=-90,0,0,0&samples=2&zoom=0", status: 200, expected: `{"samples":[{"distance":0,"elevation":672,"lat":0,"lon":-90},{"distance":10018754.17,"elevation":1344,"lat":0,"lon":0}],"zoom":0}`},
+		{path: "/services/mapbox/pixel?lon=0", status: 400},
+		{path: "/services/world_cities/pixel?lon=0&lat=0", status: 400},
+	}
+
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		svc.Handler().ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+		if rec.Code != tc.status {
+			t.Error("Unexpected status code for:", tc.path, rec.Code, "expected:", tc.status)
+			continue
+		}
+		if tc.status != 200 {
+			continue
+		}
+		if contentType := rec.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Error("Unexpected content type for:", tc.path, contentType)
+		}
+		if body := rec.Body.String(); body != tc.expected {
+			t.Error("Unexpected response for:", tc.path, body, "expected:", tc.expected)
+		}
+	}
+
+	// the encoding is included in the TileJSON
+	rec := httptest.NewRecorder()
+	svc.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/services/terrarium", nil))
+	var tileJSON map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &tileJSON); err != nil || tileJSON["encoding"] != demEncodingTerrarium {
+		t.Error("TileJSON does not include encoding:", tileJSON["encoding"], err)
+	}
+
+	if id := svc.IDFromURLPath("/services/mapbox/pixel"); id != "mapbox" {
+		t.Error("IDFromURLPath returned unexpected ID for pixel query:", id)
+	}
+}
@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	mbtiles "github.com/brendan-ward/mbtiles-go"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/planar"
+	"github.com/paulmach/orb/project"
+)
+
+// queryMaxRadius is the maximum radius in pixels of feature queries
+const queryMaxRadius = 64
+
+// featureQuery describes a query for the features of a vector tileset at a
+// location
+type featureQuery struct {
+	lon, lat float64
+	// zoom level the radius is measured at
+	zoom int
+	// radius in pixels (256 pixel tiles) at zoom level
+	radius float64
+}
+
+// parseFeatureQuery parses a feature query from the lon, lat, zoom, and
+// radius query parameters.  zoom defaults to defaultZoom and radius to 0,
+// which only returns polygons that contain the location.
+func parseFeatureQuery(query url.Values, defaultZoom int) (*featureQuery, error) {
+	q := &featureQuery{}
+
+	var err error
+	if q.lon, q.lat, err = parseLonLat(query.Get("lon"), query.Get("lat")); err != nil {
+		return nil, err
+	}
+	if q.zoom, err = parseQueryZoom(query.Get("zoom"), defaultZoom); err != nil {
+		return nil, err
+	}
+	if value := query.Get("radius"); value != "" {
+		if q.radius, err = strconv.ParseFloat(value, 64); err != nil || !(q.radius >= 0 && q.radius <= queryMaxRadius) {
+			return nil, fmt.Errorf("radius must be a number between 0 and %d", queryMaxRadius)
+		}
+	}
+	return q, nil
+}
+
+// parseLonLat parses a longitude and latitude within the extent of the web
+// mercator tile grid
+func parseLonLat(lonValue, latValue string) (float64, float64, error) {
+	lon, err := strconv.ParseFloat(lonValue, 64)
+	if err != nil || !(lon >= -180 && lon <= 180) {
+		return 0, 0, fmt.Errorf("lon must be a number between -180 and 180")
+	}
+	lat, err := strconv.ParseFloat(latValue, 64)
+	if err != nil || !(math.Abs(lat) <= maxMercatorLatitude) {
+		return 0, 0, fmt.Errorf("lat must be a number between %v and %v", -maxMercatorLatitude, maxMercatorLatitude)
+	}
+	return lon, lat, nil
+}
+
+// parseQueryZoom parses the zoom level of a query, which defaults to
+// defaultZoom if value is empty
+func parseQueryZoom(value string, defaultZoom int) (int, error) {
+	if value == "" {
+		return defaultZoom, nil
+	}
+	zoom, err := strconv.Atoi(value)
+	if err != nil || zoom < 0 || zoom > maxZoomLevel {
+		return 0, fmt.Errorf("zoom must be an integer between 0 and %d", maxZoomLevel)
+	}
+	return zoom, nil
+}
+
+// queryZoom returns the zoom level of the tiles used to query the tileset at
+// zoom level z, within the available zoom levels of the tileset
+func (ts *Tileset) queryZoom(z int) int {
+	if z < ts.minzoom {
+		z = ts.minzoom
+	}
+	if maxzoom := ts.availableMaxZoom(); z > maxzoom {
+		z = maxzoom
+	}
+	return z
+}
+
+// tileAt returns the XYZ tile at zoom level z that contains the longitude and
+// latitude, and the fractional position of the location within it (0-1).
+func tileAt(lon, lat float64, z int) (tileCoord, float64, float64) {
+	fx, fy := lonLatToTile(lon, lat, z)
+	n := int64(1) << uint(z)
+	tc := tileCoord{
+		z: int64(z),
+		x: min(max(int64(math.Floor(fx)), 0), n-1),
+		y: min(max(int64(math.Floor(fy)), 0), n-1),
+	}
+	return tc, fx - float64(tc.x), fy - float64(tc.y)
+}
+
+// geometryDistance returns the distance from point p to geometry g, which is
+// 0 if p is within a polygon of g.
+func geometryDistance(g orb.Geometry, p orb.Point) float64 {
+	switch g := g.(type) {
+	case orb.Polygon:
+		if planar.PolygonContains(g, p) {
+			return 0
+		}
+	case orb.MultiPolygon:
+		if planar.MultiPolygonContains(g, p) {
+			return 0
+		}
+	}
+	return planar.DistanceFrom(g, p)
+}
+
+// geometryTypeName returns the name of the basic type of geometry g: point,
+// linestring, or polygon.
+func geometryTypeName(g orb.Geometry) string {
+	switch g.Dimensions() {
+	case 0:
+		return "point"
+	case 1:
+		return "linestring"
+	}
+	return "polygon"
+}
+
+// queryLayers returns the features of the layers of tile tc that contain point p
+// or are within radius of it, where p and radius are in the coordinates of
+// the layers, which are scaled to a common extent.  Feature geometries are
+// projected to WGS84, and the name of the source layer, the basic geometry
+// type, and the distance from the point in meters (using metersPerUnit) are
+// added to the tilequery property of each feature.  Features are sorted by
+// distance.
+func queryLayers(layers mvt.Layers, tc tileCoord, p orb.Point, radius float64, metersPerUnit float64) []*geojson.Feature {
+	type match struct {
+		feature  *geojson.Feature
+		distance float64
+	}
+
+	var matches []match
+	for _, layer := range layers {
+		var layerMatches []*geojson.Feature
+		for _, f := range layer.Features {
+			if f.Geometry == nil {
+				continue
+			}
+			d := geometryDistance(f.Geometry, p)
+			if d > radius {
+				continue
+			}
+			if f.Properties == nil {
+				f.Properties = make(geojson.Properties)
+			}
+			f.Properties["tilequery"] = map[string]interface{}{
+				"distance": d * metersPerUnit,
+				"geometry": geometryTypeName(f.Geometry),
+				"layer":    layer.Name,
+			}
+			layerMatches = append(layerMatches, f)
+			matches = append(matches, match{feature: f, distance: d})
+		}
+
+		// only project the features that are returned
+		layer.Features = layerMatches
+		projectLayerToWGS84(layer, tc)
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].distance < matches[j].distance
+	})
+
+	features := make([]*geojson.Feature, len(matches))
+	for i, m := range matches {
+		features[i] = m.feature
+	}
+	return features
+}
+
+// queryFeatures returns the features of the vector tileset at the location of
+// q, read from the tile covering it at the zoom level of q, within the zoom
+// levels of the tileset.  Only features in that tile (including its buffer)
+// are returned.
+func (ts *Tileset) queryFeatures(q *featureQuery) ([]*geojson.Feature, error) {
+	z := ts.queryZoom(q.zoom)
+	tc, fx, fy := tileAt(q.lon, q.lat, z)
+
+	data, _, err := ts.getTile(tc)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	layers, err := decodeVectorTile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// use a common extent for all layers so that distances are comparable
+	for _, layer := range layers {
+		if layer.Extent == 0 {
+			layer.Extent = mvt.DefaultExtent
+		}
+		if layer.Extent != mvt.DefaultExtent {
+			k := float64(mvt.DefaultExtent) / float64(layer.Extent)
+			for _, f := range layer.Features {
+				f.Geometry = project.Geometry(f.Geometry, func(p orb.Point) orb.Point {
+					return orb.Point{p[0] * k, p[1] * k}
+				})
+			}
+			layer.Extent = mvt.DefaultExtent
+		}
+	}
+
+	// point and radius in tile coordinates; the radius is scaled from the
+	// requested zoom level to that of the tile
+	p := orb.Point{fx * mvt.DefaultExtent, fy * mvt.DefaultExtent}
+	radius := q.radius * mvt.DefaultExtent / float64(tileSize256) * math.Exp2(float64(z-q.zoom))
+
+	// meters on the ground at the latitude of the point
+	metersPerUnit := 2 * earthCircumference / math.Exp2(float64(z)) / mvt.DefaultExtent * math.Cos(q.lat*math.Pi/180)
+
+	return queryLayers(layers, tc, p, radius, metersPerUnit), nil
+}
+
+// queryHandler is an http.HandlerFunc that returns the features of a vector
+// tileset at a location as a GeoJSON FeatureCollection, of the form
+// /services/<id>/query?lon=<lon>&lat=<lat>[&zoom=<zoom>][&radius=<pixels>]
+func (ts *Tileset) queryHandler(w http.ResponseWriter, r *http.Request) {
+	if !ts.published {
+		http.NotFound(w, r)
+		return
+	}
+
+	// wait up to 30 seconds to see if tileset is ready and return it if possible
+	if ts.isLockedWithTimeout(30 * time.Second) {
+		tilesetLockedHandler(w, r)
+		return
+	}
+
+	if ts.tileformat != mbtiles.PBF {
+		http.Error(w, "feature queries are only available for vector tilesets", http.StatusBadRequest)
+		return
+	}
+
+	q, err := parseFeatureQuery(r.URL.Query(), ts.availableMaxZoom())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	features, err := ts.queryFeatures(q)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		ts.svc.logError("Could not query features for %v: %v", r.URL.Path, err)
+		return
+	}
+
+	fc := geojson.NewFeatureCollection()
+	fc.Features = append(fc.Features, features...)
+	bytes, err := json.Marshal(fc)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		ts.svc.logError("Could not encode features for %v: %v", r.URL.Path, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	setCacheControl(w, ts.cacheControl.Tiles)
+	if checkNotModified(w, r, contentETag(bytes), ts.db.GetTimestamp()) {
+		return
+	}
+	w.Write(bytes)
+}
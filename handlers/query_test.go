@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"math"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+)
+
+func Test_ParseFeatureQuery(t *testing.T) {
+	tests := []struct {
+		query  string
+		zoom   int
+		radius float64
+		err    bool
+	}{
+		{query: "lon=-122.42&lat=37.77", zoom: 6},
+		{query: "lon=-122.42&lat=37.77&zoom=4&radius=10", zoom: 4, radius: 10},
+		{query: "lon=180&lat=-85&zoom=0&radius=0", zoom: 0},
+		{query: "lat=37.77", err: true},
+		{query: "lon=-122.42", err: true},
+		{query: "lon=-181&lat=0", err: true},
+		{query: "lon=0&lat=89", err: true},
+		{query: "lon=0&lat=NaN", err: true},
+		{query: "lon=0&lat=0&zoom=1.5", err: true},
+		{query: "lon=0&lat=0&zoom=31", err: true},
+		{query: "lon=0&lat=0&radius=-1", err: true},
+		{query: "lon=0&lat=0&radius=65", err: true},
+		{query: "lon=0&lat=0&radius=NaN", err: true},
+	}
+
+	for _, tc := range tests {
+		values, _ := url.ParseQuery(tc.query)
+		q, err := parseFeatureQuery(values, 6)
+		if tc.err {
+			if err == nil {
+				t.Error("parseFeatureQuery did not raise expected error for:", tc.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Error("parseFeatureQuery raised unexpected error for:", tc.query, err)
+			continue
+		}
+		if q.zoom != tc.zoom || q.radius != tc.radius {
+			t.Error("parseFeatureQuery returned unexpected zoom or radius for:", tc.query, q.zoom, q.radius)
+		}
+	}
+}
+
+func Test_QueryLayers(t *testing.T) {
+	// geometries are projected in place, so create new layers for each test
+	newLayers := func() mvt.Layers {
+		polygons := geojson.NewFeatureCollection()
+		polygons.Append(geojson.NewFeature(orb.Polygon{{{1000, 1000}, {2000, 1000}, {2000, 2000}, {1000, 2000}, {1000, 1000}}}))
+		polygons.Features[0].Properties["name"] = "square"
+		points := geojson.NewFeatureCollection()
+		points.Append(geojson.NewFeature(orb.Point{1500, 1510}))
+		points.Append(geojson.NewFeature(orb.Point{3000, 3000}))
+		lines := geojson.NewFeatureCollection()
+		lines.Append(geojson.NewFeature(orb.LineString{{0, 1600}, {4096, 1600}}))
+		return mvt.Layers{mvt.NewLayer("polygons", polygons), mvt.NewLayer("points", points), mvt.NewLayer("lines", lines)}
+	}
+
+	tests := []struct {
+		point  orb.Point
+		radius float64
+		layers []string
+	}{
+		// only polygons contain a point
+		{point: orb.Point{1500, 1500}, radius: 0, layers: []string{"polygons"}},
+		// sorted by distance
+		{point: orb.Point{1500, 1500}, radius: 50, layers: []string{"polygons", "points"}},
+		{point: orb.Point{1500, 1500}, radius: 100, layers: []string{"polygons", "points", "lines"}},
+		{point: orb.Point{500, 500}, radius: 100, layers: nil},
+		{point: orb.Point{2990, 3000}, radius: 10, layers: []string{"points"}},
+	}
+
+	for _, tc := range tests {
+		features := queryLayers(newLayers(), tileCoord{z: 0, x: 0, y: 0}, tc.point, tc.radius, 1)
+		if len(features) != len(tc.layers) {
+			t.Error("queryLayers returned unexpected number of features for:", tc.point, tc.radius, len(features), "expected:", len(tc.layers))
+			continue
+		}
+		for i, f := range features {
+			props := f.Properties["tilequery"].(map[string]interface{})
+			if props["layer"] != tc.layers[i] {
+				t.Error("queryLayers returned unexpected layer for:", tc.point, tc.radius, props["layer"], "expected:", tc.layers[i])
+			}
+		}
+	}
+
+	// geometries are projected to WGS84 and properties are retained
+	features := queryLayers(newLayers(), tileCoord{z: 0, x: 0, y: 0}, orb.Point{1500, 1500}, 0, 1)
+	if features[0].Properties["name"] != "square" {
+		t.Error("queryLayers did not retain feature properties:", features[0].Properties)
+	}
+	lon, lat := tileToLonLat(1000.0/4096, 1000.0/4096, 0)
+	if p := features[0].Geometry.(orb.Polygon)[0][0]; !floatsEqual(p[:], []float64{lon, lat}) {
+		t.Error("queryLayers returned unexpected coordinates:", p, "expected:", lon, lat)
+	}
+	if props := features[0].Properties["tilequery"].(map[string]interface{}); props["distance"] != 0.0 || props["geometry"] != "polygon" {
+		t.Error("queryLayers returned unexpected tilequery properties:", props)
+	}
+}
+
+func Test_QueryHandler(t *testing.T) {
+	svc := newTestServiceSet(t, &ServiceSetConfig{EnableQuery: true}, "world_cities", "geography-class-png")
+
+	tests := []struct {
+		path   string
+		status int
+		names  []string
+	}{
+		{path: "/services/world_cities/query?lon=-122.42&lat=37.77&zoom=4&radius=20", status: 200, names: []string{"San Francisco"}},
+		{path: "/services/world_cities/query?lon=-122.42&lat=37.77&zoom=4", status: 200, names: nil},
+		// zoom is limited to the zoom levels of the tileset
+		{path: "/services/world_cities/query?lon=-122.42&lat=37.77&zoom=20&radius=5", status: 200, names: nil},
+		{path: "/services/world_cities/query?lon=-122.42&lat=37.77&zoom=10&radius=64", status: 200, names: []string{"San Francisco"}},
+		{path: "/services/world_cities/query?lon=-122.42", status: 400},
+		{path: "/services/geography-class-png/query?lon=0&lat=0", status: 400},
+	}
+
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		svc.Handler().ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+		if rec.Code != tc.status {
+			t.Error("Unexpected status code for:", tc.path, rec.Code, "expected:", tc.status)
+			continue
+		}
+		if tc.status != 200 {
+			continue
+		}
+		if contentType := rec.Header().Get("Content-Type"); contentType != "application/geo+json" {
+			t.Error("Unexpected content type for:", tc.path, contentType)
+		}
+
+		fc, err := geojson.UnmarshalFeatureCollection(rec.Body.Bytes())
+		if err != nil {
+			t.Error("Could not parse response for:", tc.path, err)
+			continue
+		}
+		var names []string
+		for _, f := range fc.Features {
+			names = append(names, f.Properties.MustString("name", ""))
+			if d := f.Properties["tilequery"].(map[string]interface{})["distance"].(float64); math.IsNaN(d) || d < 0 {
+				t.Error("Unexpected distance for:", tc.path, d)
+			}
+		}
+		if len(names) != len(tc.names) || (len(names) > 0 && names[0] != tc.names[0]) {
+			t.Error("Unexpected features for:", tc.path, names, "expected:", tc.names)
+		}
+	}
+
+	if id := svc.IDFromURLPath("/services/world_cities/query"); id != "world_cities" {
+		t.Error("IDFromURLPath returned unexpected ID for query:", id)
+	}
+
+	// disabled by default
+	svc = newTestServiceSet(t, &ServiceSetConfig{}, "world_cities")
+	rec := httptest.NewRecorder()
+	svc.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/services/world_cities/query?lon=0&lat=0", nil))
+	if rec.Code != 404 {
+		t.Error("Expected 404 when queries are disabled, got:", rec.Code)
+	}
+}
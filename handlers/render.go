@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"errors"
+	"image"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// maxRenderSize is the maximum width or height in pixels of images rendered
+// from tiles
+const maxRenderSize = 4096
+
+// errTooManyTiles is returned when rendering an image would require too many
+// tiles, such as for a view far beyond the minimum zoom level of a tileset
+var errTooManyTiles = errors.New("the requested view requires too many tiles at the zoom levels of the tileset")
+
+// renderTileSize returns the size in pixels of the tiles of the tileset
+func (ts *Tileset) renderTileSize() int {
+	if ts.tilesize > 0 {
+		return int(ts.tilesize)
+	}
+	return int(tileSize256)
+}
+
+// viewBounds returns the web mercator bounds of an image of width x height
+// pixels centered on web mercator coordinates x, y at a resolution of res
+// meters per pixel.
+func viewBounds(x, y, res float64, width, height int) [4]float64 {
+	dx, dy := float64(width)/2*res, float64(height)/2*res
+	return [4]float64{x - dx, y - dy, x + dx, y + dy}
+}
+
+// renderZoom returns the zoom level of the tileset used to render an image at
+// a resolution of res meters per pixel: the lowest zoom level at least as
+// detailed as res, within the available zoom levels of the tileset.
+func (ts *Tileset) renderZoom(res float64) int {
+	tileRes := 2 * earthCircumference / float64(ts.renderTileSize())
+	// allow for floating point error when res exactly matches a zoom level
+	z := int(math.Ceil(math.Log2(tileRes/res) - 1e-6))
+	if z < ts.minzoom {
+		z = ts.minzoom
+	}
+	if maxzoom := ts.availableMaxZoom(); z > maxzoom {
+		z = maxzoom
+	}
+	return z
+}
+
+// renderMercator renders the image tiles of the tileset into a new image of
+// width x height pixels covering the web mercator bounds
+// [xmin, ymin, xmax, ymax].  Tiles are read at the zoom level that best
+// matches the requested resolution, and each is resampled into its place in
+// the image.  Areas without tiles are left transparent.  Tiles wrap around
+// the antimeridian.  Returns errTooManyTiles if the view requires too many
+// tiles.
+func (ts *Tileset) renderMercator(bounds [4]float64, width, height int) (*image.RGBA, error) {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	if width <= 0 || height <= 0 || bounds[2] <= bounds[0] || bounds[3] <= bounds[1] {
+		return dst, nil
+	}
+
+	resX := (bounds[2] - bounds[0]) / float64(width)
+	resY := (bounds[3] - bounds[1]) / float64(height)
+	z := ts.renderZoom(math.Min(resX, resY))
+
+	n := int64(1) << uint(z)
+	tileSize := ts.renderTileSize()
+	span := 2 * earthCircumference / float64(n) // width of a tile in meters
+
+	minCol := int64(math.Floor((bounds[0] + earthCircumference) / span))
+	maxCol := int64(math.Ceil((bounds[2]+earthCircumference)/span)) - 1
+	minRow := int64(math.Floor((earthCircumference - bounds[3]) / span))
+	maxRow := int64(math.Ceil((earthCircumference-bounds[1])/span)) - 1
+
+	// at most twice the resolution is used, unless limited by the minimum
+	// zoom level of the tileset; refuse to render if that requires too many
+	// tiles
+	limit := (2*int64(width)/int64(tileSize) + 2) * (2*int64(height)/int64(tileSize) + 2)
+	if (maxCol-minCol+1)*(maxRow-minRow+1) > limit {
+		return nil, errTooManyTiles
+	}
+
+	for row := minRow; row <= maxRow; row++ {
+		if row < 0 || row >= n {
+			continue
+		}
+		for col := minCol; col <= maxCol; col++ {
+			// wrap columns around the antimeridian
+			tc := tileCoord{z: int64(z), x: ((col % n) + n) % n, y: row}
+			img, err := ts.readImage(tc)
+			if err != nil {
+				return nil, err
+			}
+			if img == nil {
+				continue
+			}
+
+			// transform from tile pixels to output pixels
+			b := img.Bounds()
+			kx, ky := span/float64(b.Dx()), span/float64(b.Dy())
+			tileX := float64(col)*span - earthCircumference
+			tileY := earthCircumference - float64(row)*span
+			s2d := f64.Aff3{
+				kx / resX, 0, (tileX-bounds[0])/resX - kx/resX*float64(b.Min.X),
+				0, ky / resY, (bounds[3]-tileY)/resY - ky/resY*float64(b.Min.Y),
+			}
+			xdraw.BiLinear.Transform(dst, s2d, img, b, xdraw.Src, nil)
+		}
+	}
+	return dst, nil
+}
+
+// renderGeographic renders the image tiles of the tileset into a new image of
+// width x height pixels covering the longitude and latitude bounds
+// [xmin, ymin, xmax, ymax] (EPSG:4326 / CRS:84).  The tiles are rendered in
+// web mercator then each row is resampled to its latitude.  Areas without
+// tiles or outside the latitudes of the web mercator tile grid are left
+// transparent.
+func (ts *Tileset) renderGeographic(bounds [4]float64, width, height int) (*image.RGBA, error) {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	if width <= 0 || height <= 0 || bounds[2] <= bounds[0] || bounds[3] <= bounds[1] {
+		return dst, nil
+	}
+
+	xmin, ymin := lonLatToMercator(bounds[0], bounds[1])
+	xmax, ymax := lonLatToMercator(bounds[2], bounds[3])
+	if ymax <= ymin {
+		// entirely outside the web mercator tile grid
+		return dst, nil
+	}
+
+	src, err := ts.renderMercator([4]float64{xmin, ymin, xmax, ymax}, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	resLat := (bounds[3] - bounds[1]) / float64(height)
+	rowSize := width * 4
+	for j := 0; j < height; j++ {
+		lat := bounds[3] - (float64(j)+0.5)*resLat
+		if math.Abs(lat) > maxMercatorLatitude {
+			continue
+		}
+		_, y := lonLatToMercator(0, lat)
+		row := int((ymax - y) / (ymax - ymin) * float64(height))
+		if row < 0 || row >= height {
+			continue
+		}
+		copy(dst.Pix[j*dst.Stride:j*dst.Stride+rowSize], src.Pix[row*src.Stride:row*src.Stride+rowSize])
+	}
+	return dst, nil
+}
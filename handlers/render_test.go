@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"testing"
+)
+
+func Test_RenderMercator(t *testing.T) {
+	svc := newTestServiceSet(t, &ServiceSetConfig{}, "geography-class-png")
+	ts := svc.tilesets["geography-class-png"]
+
+	tile, err := ts.readImage(tileCoord{z: 0, x: 0, y: 0})
+	if err != nil || tile == nil {
+		t.Fatal("Could not read tile:", err)
+	}
+
+	world := [4]float64{-earthCircumference, -earthCircumference, earthCircumference, earthCircumference}
+	img, err := ts.renderMercator(world, 256, 256)
+	if err != nil {
+		t.Fatal("renderMercator raised unexpected error:", err)
+	}
+
+	// rendering the extent of the tile at its resolution returns the tile
+	for _, p := range [][2]int{{10, 10}, {128, 128}, {200, 60}, {245, 250}} {
+		r0, g0, b0, _ := tile.At(p[0], p[1]).RGBA()
+		r1, g1, b1, _ := img.At(p[0], p[1]).RGBA()
+		if absDiff(r0, r1) > 0x800 || absDiff(g0, g1) > 0x800 || absDiff(b0, b1) > 0x800 {
+			t.Error("renderMercator returned unexpected color at:", p, img.At(p[0], p[1]), "expected:", tile.At(p[0], p[1]))
+		}
+	}
+
+	// areas outside the tile grid are transparent
+	img, err = ts.renderMercator([4]float64{-earthCircumference, earthCircumference, earthCircumference, 3 * earthCircumference}, 256, 256)
+	if err != nil {
+		t.Fatal("renderMercator raised unexpected error:", err)
+	}
+	if _, _, _, a := img.At(128, 128).RGBA(); a != 0 {
+		t.Error("renderMercator returned non-transparent pixel outside tile grid")
+	}
+
+	// latitudes outside the tile grid are transparent in geographic images
+	img, err = ts.renderGeographic([4]float64{-180, -90, 180, 90}, 360, 180)
+	if err != nil {
+		t.Fatal("renderGeographic raised unexpected error:", err)
+	}
+	if _, _, _, a := img.At(180, 1).RGBA(); a != 0 {
+		t.Error("renderGeographic returned non-transparent pixel above tile grid")
+	}
+	if _, _, _, a := img.At(180, 90).RGBA(); a == 0 {
+		t.Error("renderGeographic returned transparent pixel within tile grid")
+	}
+
+	// views that require too many tiles at the minimum zoom level are not
+	// rendered as blank images
+	ts.minzoom, ts.maxzoom = 3, 3
+	if _, err = ts.renderMercator(world, 256, 256); err != errTooManyTiles {
+		t.Error("renderMercator did not raise expected error for too many tiles:", err)
+	}
+	if _, err = ts.renderGeographic([4]float64{-180, -85, 180, 85}, 256, 256); err != errTooManyTiles {
+		t.Error("renderGeographic did not raise expected error for too many tiles:", err)
+	}
+}
+
+func absDiff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// sanitizeAllowedTags lists the HTML elements allowed in sanitized text, such
+// as attribution, and their allowed attributes.
+var sanitizeAllowedTags = map[atom.Atom][]string{
+	atom.A:      {"href", "title", "target", "rel"},
+	atom.B:      nil,
+	atom.Br:     nil,
+	atom.Em:     nil,
+	atom.I:      nil,
+	atom.P:      nil,
+	atom.Small:  nil,
+	atom.Span:   nil,
+	atom.Strong: nil,
+	atom.Sub:    nil,
+	atom.Sup:    nil,
+}
+
+// sanitizeHTML returns value with only a small set of formatting elements and
+// links allowed; other elements, comments, and the content of script and style
+// elements are removed.  Links must use http, https, or mailto URLs.  Text is
+// escaped, and any elements left open are closed.
+func sanitizeHTML(value string) string {
+	var b strings.Builder
+	var open []atom.Atom
+	skip := 0 // depth within script or style elements
+
+	z := html.NewTokenizer(strings.NewReader(value))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			// end of input; close any elements left open
+			for i := len(open) - 1; i >= 0; i-- {
+				b.WriteString("</" + open[i].String() + ">")
+			}
+			return b.String()
+
+		case html.TextToken:
+			if skip == 0 {
+				b.WriteString(html.EscapeString(string(z.Text())))
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			if tok.DataAtom == atom.Script || tok.DataAtom == atom.Style {
+				if tt == html.StartTagToken {
+					skip++
+				}
+				continue
+			}
+			allowed, ok := sanitizeAllowedTags[tok.DataAtom]
+			if !ok || skip > 0 {
+				continue
+			}
+
+			b.WriteString("<" + tok.Data)
+			for _, attr := range tok.Attr {
+				if attr.Namespace != "" || !slices.Contains(allowed, attr.Key) {
+					continue
+				}
+				if attr.Key == "href" && !isSafeURL(attr.Val) {
+					continue
+				}
+				b.WriteString(" " + attr.Key + `="` + html.EscapeString(attr.Val) + `"`)
+			}
+			b.WriteString(">")
+
+			if tt == html.StartTagToken && tok.DataAtom != atom.Br {
+				open = append(open, tok.DataAtom)
+			}
+
+		case html.EndTagToken:
+			tok := z.Token()
+			if tok.DataAtom == atom.Script || tok.DataAtom == atom.Style {
+				if skip > 0 {
+					skip--
+				}
+				continue
+			}
+			// only close elements that are open, along with any elements
+			// opened within them
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == tok.DataAtom {
+					for j := len(open) - 1; j >= i; j-- {
+						b.WriteString("</" + open[j].String() + ">")
+					}
+					open = open[:i]
+					break
+				}
+			}
+		}
+	}
+}
+
+// isSafeURL returns true if value is an http, https, or mailto URL
+func isSafeURL(value string) bool {
+	u, err := url.Parse(strings.TrimSpace(value))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import "testing"
+
+func Test_SanitizeHTML(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{value: "", expected: ""},
+		{value: "© OpenStreetMap contributors", expected: "© OpenStreetMap contributors"},
+		{value: "A & B", expected: "A &amp; B"},
+		{
+			value:    `<a href="https://www.openstreetmap.org/copyright" target="_blank">© OpenStreetMap</a>`,
+			expected: `<a href="https://www.openstreetmap.org/copyright" target="_blank">© OpenStreetMap</a>`,
+		},
+		{value: `<a href="javascript:alert(1)">link</a>`, expected: `<a>link</a>`},
+		{value: `<a href="https://example.com" onclick="alert(1)">link</a>`, expected: `<a href="https://example.com">link</a>`},
+		{value: `<b>bold</b><br/><i>italic</i>`, expected: `<b>bold</b><br><i>italic</i>`},
+		{value: `<script>alert("x")</script>text`, expected: `text`},
+		{value: `<style>body {}</style>text`, expected: `text`},
+		{value: `<img src="x" onerror="alert(1)">text`, expected: `text`},
+		{value: `<div><span>text</div>`, expected: `<span>text</span>`},
+		{value: `<b>unclosed`, expected: `<b>unclosed</b>`},
+		{value: `text</b>`, expected: `text`},
+		{value: `<!-- comment -->text`, expected: `text`},
+	}
+
+	for _, tc := range tests {
+		if out := sanitizeHTML(tc.value); out != tc.expected {
+			t.Errorf("sanitizeHTML(%q) = %q, expected %q", tc.value, out, tc.expected)
+		}
+	}
+}
@@ -7,8 +7,10 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"os"
 	"sort"
 	"strings"
+	"time"
 )
 
 // ServiceSetConfig provides configuration options for a ServiceSet
@@ -16,12 +18,92 @@ type ServiceSetConfig struct {
 	EnableServiceList         bool
 	EnableTileJSON            bool
 	EnablePreview             bool
+	EnableThumbnails          bool
 	EnableArcGIS              bool
+	EnableTMS                 bool
+	EnableWMTS                bool
+	EnableOGCAPI              bool
+	EnableWMS                 bool
 	BasemapStyleURL           string
 	BasemapTilesURL           string
 	ReturnMissingImageTile404 bool
 	RootURL                   *url.URL
 	ErrorWriter               io.Writer
+
+	// EnableOverzoom enables synthesizing image and vector tiles that are
+	// above the maximum zoom level of a tileset or are missing within it from
+	// their nearest ancestor tile, up to OverzoomMaxZoom (default: 22).
+	// These can be overridden for individual tilesets using Tilesets.
+	EnableOverzoom  bool
+	OverzoomMaxZoom int
+
+	// JPEGQuality is the quality (1-100) used when encoding JPEG tiles,
+	// for example when transcoding PNG tiles to JPEG (default: 90).
+	JPEGQuality int
+
+	// EnableStaticMaps enables rendering static map images of image
+	// tilesets, up to StaticMapMaxSize pixels wide or high (default: 2048).
+	EnableStaticMaps bool
+	StaticMapMaxSize int
+
+	// EnableQuery enables querying the features of vector tilesets and the
+	// pixel values (or elevation) of image tilesets at locations.
+	EnableQuery bool
+
+	// EnableTerrain enables hillshade and slope tiles rendered from raster
+	// DEM tilesets with an elevation encoding.
+	EnableTerrain bool
+
+	// EnableGeoJSON enables exporting the features of vector tilesets as
+	// GeoJSON, for individual tiles and within bounding boxes.
+	EnableGeoJSON bool
+
+	// EnableTileStats enables computing statistics of the layers and
+	// attributes of vector tilesets from their tiles in the background, in
+	// the mapbox tilestats format.
+	EnableTileStats bool
+
+	// TileJSONVersion is the version of TileJSON returned by the TileJSON
+	// endpoint unless a version is requested using the tilejson query
+	// parameter: "2.1.0" (default) or "3.0.0".
+	TileJSONVersion string
+
+	// StylesDir and FontsDir are directories of map styles (each in its own
+	// subdirectory with a style.json file and optional sprite files) and
+	// glyph fonts (each in its own subdirectory with <range>.pbf files) that
+	// are served at /styles and /fonts.  These are disabled if empty.
+	StylesDir string
+	FontsDir  string
+
+	// EnableTileServerGL enables the handler returned by
+	// ServiceSet.TileServerGLHandler, which serves tilesets and styles
+	// using the URL layout of TileServer GL.
+	EnableTileServerGL bool
+
+	// TileCacheSize is the maximum total size in bytes of tiles cached in
+	// memory, shared by all tilesets.  The tile cache is disabled if 0.
+	TileCacheSize int64
+
+	// CacheControl provides the Cache-Control header values for each type
+	// of endpoint; these can be overridden for individual tilesets using
+	// Tilesets.
+	CacheControl CacheControl
+
+	// Tilesets provides configuration options for individual tilesets,
+	// keyed by tileset ID
+	Tilesets map[string]TilesetConfig
+}
+
+// TilesetConfig provides configuration options for an individual tileset
+// that override those in ServiceSetConfig.
+type TilesetConfig struct {
+	// Non-empty values override the values of ServiceSetConfig.CacheControl
+	CacheControl CacheControl
+
+	// Overzoom overrides ServiceSetConfig.EnableOverzoom if not nil
+	Overzoom *bool
+	// OverzoomMaxZoom overrides ServiceSetConfig.OverzoomMaxZoom if > 0
+	OverzoomMaxZoom int
 }
 
 // ServiceSet is a group of tilesets plus configuration options.
@@ -32,10 +114,35 @@ type ServiceSet struct {
 	enableServiceList         bool
 	enableTileJSON            bool
 	enablePreview             bool
+	enableThumbnails          bool
 	enableArcGIS              bool
+	enableTMS                 bool
+	enableWMTS                bool
+	enableOGCAPI              bool
+	enableWMS                 bool
+	enableStaticMaps          bool
+	staticMapMaxSize          int
+	enableQuery               bool
+	enableTerrain             bool
+	enableGeoJSON             bool
+	enableTileStats           bool
+	tileJSONVersion           string
+	stylesDir                 string
+	fontsDir                  string
+	enableTileServerGL        bool
 	basemapStyleURL           string
 	basemapTilesURL           string
 	returnMissingImageTile404 bool
+	cacheControl              CacheControl
+	tilesetConfigs            map[string]TilesetConfig
+	enableOverzoom            bool
+	overzoomMaxZoom           int
+	jpegQuality               int
+	tileCache                 *tileCache
+
+	// tileStatsSem limits the number of tilesets scanned for tilestats at
+	// the same time
+	tileStatsSem chan struct{}
 
 	rootURL     *url.URL
 	errorWriter io.Writer
@@ -49,15 +156,94 @@ func New(cfg *ServiceSetConfig) (*ServiceSet, error) {
 		cfg = &ServiceSetConfig{}
 	}
 
+	cacheControl, err := cfg.CacheControl.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	tilesetConfigs := make(map[string]TilesetConfig, len(cfg.Tilesets))
+	for id, tsCfg := range cfg.Tilesets {
+		if tsCfg.CacheControl, err = tsCfg.CacheControl.validate(); err != nil {
+			return nil, fmt.Errorf("invalid configuration for tileset %q: %v", id, err)
+		}
+		if tsCfg.OverzoomMaxZoom > maxZoomLevel {
+			return nil, fmt.Errorf("invalid configuration for tileset %q: overzoom max zoom must be between 1 and %d: %d", id, maxZoomLevel, tsCfg.OverzoomMaxZoom)
+		}
+		tilesetConfigs[id] = tsCfg
+	}
+
+	overzoomMaxZoom := cfg.OverzoomMaxZoom
+	if overzoomMaxZoom <= 0 {
+		overzoomMaxZoom = defaultOverzoomMaxZoom
+	}
+	if overzoomMaxZoom > maxZoomLevel {
+		return nil, fmt.Errorf("overzoom max zoom must be between 1 and %d: %d", maxZoomLevel, overzoomMaxZoom)
+	}
+
+	jpegQuality := cfg.JPEGQuality
+	if jpegQuality == 0 {
+		jpegQuality = defaultJPEGQuality
+	}
+	if jpegQuality < 1 || jpegQuality > 100 {
+		return nil, fmt.Errorf("JPEG quality must be between 1 and 100: %d", jpegQuality)
+	}
+
+	staticMapMaxSize := cfg.StaticMapMaxSize
+	if staticMapMaxSize == 0 {
+		staticMapMaxSize = defaultStaticMapMaxSize
+	}
+	if staticMapMaxSize < 1 || staticMapMaxSize > maxRenderSize {
+		return nil, fmt.Errorf("static map max size must be between 1 and %d: %d", maxRenderSize, staticMapMaxSize)
+	}
+
+	tileJSONVersion := cfg.TileJSONVersion
+	if tileJSONVersion == "" {
+		tileJSONVersion = tileJSONVersion2
+	}
+	if !validTileJSONVersion(tileJSONVersion) {
+		return nil, fmt.Errorf("TileJSON version must be %s or %s: %s", tileJSONVersion2, tileJSONVersion3, tileJSONVersion)
+	}
+
+	for _, dir := range []string{cfg.StylesDir, cfg.FontsDir} {
+		if dir == "" {
+			continue
+		}
+		if stat, err := os.Stat(dir); err != nil || !stat.IsDir() {
+			return nil, fmt.Errorf("directory does not exist: %q", dir)
+		}
+	}
+
 	s := &ServiceSet{
 		tilesets:                  make(map[string]*Tileset),
 		enableServiceList:         cfg.EnableServiceList,
 		enableTileJSON:            cfg.EnableTileJSON,
 		enablePreview:             cfg.EnablePreview,
+		enableThumbnails:          cfg.EnableThumbnails,
 		enableArcGIS:              cfg.EnableArcGIS,
+		enableTMS:                 cfg.EnableTMS,
+		enableWMTS:                cfg.EnableWMTS,
+		enableOGCAPI:              cfg.EnableOGCAPI,
+		enableWMS:                 cfg.EnableWMS,
+		enableStaticMaps:          cfg.EnableStaticMaps,
+		staticMapMaxSize:          staticMapMaxSize,
+		enableQuery:               cfg.EnableQuery,
+		enableTerrain:             cfg.EnableTerrain,
+		enableGeoJSON:             cfg.EnableGeoJSON,
+		enableTileStats:           cfg.EnableTileStats,
+		tileJSONVersion:           tileJSONVersion,
+		stylesDir:                 cfg.StylesDir,
+		fontsDir:                  cfg.FontsDir,
+		enableTileServerGL:        cfg.EnableTileServerGL,
 		basemapStyleURL:           cfg.BasemapStyleURL,
 		basemapTilesURL:           cfg.BasemapTilesURL,
 		returnMissingImageTile404: cfg.ReturnMissingImageTile404,
+		cacheControl:              cacheControl,
+		tilesetConfigs:            tilesetConfigs,
+		enableOverzoom:            cfg.EnableOverzoom,
+		overzoomMaxZoom:           overzoomMaxZoom,
+		jpegQuality:               jpegQuality,
+		tileCache:                 newTileCache(cfg.TileCacheSize),
+		tileStatsSem:              make(chan struct{}, 1),
 		rootURL:                   cfg.RootURL,
 		errorWriter:               cfg.ErrorWriter,
 	}
@@ -93,6 +279,9 @@ func (s *ServiceSet) UpdateTileset(id string) error {
 	}
 
 	err := ts.reload()
+	if s.tileCache != nil {
+		s.tileCache.invalidate(id)
+	}
 	if err != nil {
 		return err
 	}
@@ -111,6 +300,10 @@ func (s *ServiceSet) RemoveTileset(id string) error {
 	}
 
 	err := ts.delete()
+	if s.tileCache != nil {
+		s.tileCache.invalidate(id)
+		s.tileCache.removeStats(id)
+	}
 	if err != nil {
 		return err
 	}
@@ -158,11 +351,25 @@ func (s *ServiceSet) Size() int {
 	return len(s.tilesets)
 }
 
+// TileCacheStats returns the tile cache statistics for each tileset, keyed by
+// tileset ID.  Returns nil if the tile cache is disabled.
+func (s *ServiceSet) TileCacheStats() map[string]TileCacheStats {
+	if s.tileCache == nil {
+		return nil
+	}
+	out := make(map[string]TileCacheStats, len(s.tilesets))
+	for id := range s.tilesets {
+		out[id] = s.tileCache.getStats(id)
+	}
+	return out
+}
+
 // ServiceInfo provides basic information about the service.
 type ServiceInfo struct {
 	ImageType string `json:"imageType"`
 	URL       string `json:"url"`
 	Name      string `json:"name"`
+	Thumbnail string `json:"thumbnail,omitempty"`
 }
 
 // logError writes to the configured ServiceSet.errorWriter if available
@@ -180,6 +387,7 @@ func (s *ServiceSet) logError(format string, args ...interface{}) {
 func (s *ServiceSet) serviceListHandler(w http.ResponseWriter, r *http.Request) {
 	rootURL := fmt.Sprintf("%s://%s%s", scheme(r), getRequestHost(r), r.URL)
 	services := []ServiceInfo{}
+	var modTime time.Time
 
 	// sort ids alpabetically
 	var ids []string
@@ -190,11 +398,18 @@ func (s *ServiceSet) serviceListHandler(w http.ResponseWriter, r *http.Request)
 
 	for _, id := range ids {
 		ts := s.tilesets[id]
-		services = append(services, ServiceInfo{
+		if ts.db != nil && ts.db.GetTimestamp().After(modTime) {
+			modTime = ts.db.GetTimestamp()
+		}
+		info := ServiceInfo{
 			ImageType: ts.tileFormatString(),
 			URL:       fmt.Sprintf("%s/%s", rootURL, id),
 			Name:      ts.name,
-		})
+		}
+		if s.enableThumbnails && ts.hasThumbnail() {
+			info.Thumbnail = info.URL + "/thumbnail.png"
+		}
+		services = append(services, info)
 	}
 	bytes, err := json.Marshal(services)
 	if err != nil {
@@ -203,6 +418,10 @@ func (s *ServiceSet) serviceListHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
+	setCacheControl(w, s.cacheControl.ServiceList)
+	if checkNotModified(w, r, contentETag(bytes), modTime) {
+		return
+	}
 	_, err = w.Write(bytes)
 
 	if err != nil {
@@ -236,6 +455,44 @@ func (s *ServiceSet) IDFromURLPath(id string) string {
 			return id
 		}
 
+		// trim endpoints of the tileset
+		suffixes := []struct {
+			enabled bool
+			suffix  string
+		}{
+			{s.enableTileJSON, "/style.json"},
+			{s.enableThumbnails, "/thumbnail.png"},
+			{s.enableQuery, "/query"},
+			{s.enableQuery, "/pixel"},
+			{s.enableTileStats, "/tilestats"},
+			{s.enableGeoJSON, "/features"},
+		}
+		for _, e := range suffixes {
+			if e.enabled && strings.HasSuffix(id, e.suffix) {
+				if _, ok := s.tilesets[strings.TrimSuffix(id, e.suffix)]; ok {
+					return strings.TrimSuffix(id, e.suffix)
+				}
+			}
+		}
+
+		// trim hillshade and slope tile coordinates
+		if s.enableTerrain {
+			for _, product := range []string{"/hillshade/", "/slope/"} {
+				if i := strings.LastIndex(id, product); i != -1 {
+					if _, ok := s.tilesets[id[:i]]; ok {
+						return id[:i]
+					}
+				}
+			}
+		}
+
+		// trim static map view and size
+		if i := strings.LastIndex(id, "/static/"); s.enableStaticMaps && i != -1 {
+			if _, ok := s.tilesets[id[:i]]; ok {
+				return id[:i]
+			}
+		}
+
 		// Split on /tiles/ and /map/ and trim /map
 		i := strings.LastIndex(id, "/tiles/")
 		if i != -1 {
@@ -252,6 +509,53 @@ func (s *ServiceSet) IDFromURLPath(id string) string {
 		id = strings.TrimPrefix(id, ArcGISServicesRoot)
 		// MapServer should be a reserved word, so should be OK to split on it
 		id = strings.Split(id, "/MapServer")[0]
+	} else if s.enableTMS && strings.HasPrefix(id, TMSServiceRoot+"/") {
+		id = strings.TrimPrefix(id, TMSServiceRoot+"/")
+
+		// test exact match first
+		if _, ok := s.tilesets[id]; ok {
+			return id
+		}
+
+		// trim off <z>/<x>/<y>
+		pcs := strings.Split(id, "/")
+		if len(pcs) < 4 {
+			return ""
+		}
+		id = strings.Join(pcs[:len(pcs)-3], "/")
+	} else if s.enableWMTS && strings.HasPrefix(id, WMTSServiceRoot+"/") {
+		id = strings.TrimPrefix(id, WMTSServiceRoot+"/")
+
+		// trim off <style>/<tile matrix set>/<z>/<row>/<col>
+		pcs := strings.Split(id, "/")
+		if len(pcs) < 6 {
+			return ""
+		}
+		id = strings.Join(pcs[:len(pcs)-5], "/")
+	} else if s.enableTileServerGL && strings.HasPrefix(id, TileServerGLDataRoot+"/") {
+		id = strings.TrimPrefix(id, TileServerGLDataRoot+"/")
+
+		// TileJSON
+		if _, ok := s.tilesets[strings.TrimSuffix(id, ".json")]; ok && strings.HasSuffix(id, ".json") {
+			return strings.TrimSuffix(id, ".json")
+		}
+
+		// trim off <z>/<x>/<y>
+		pcs := strings.Split(id, "/")
+		if len(pcs) < 4 {
+			return ""
+		}
+		id = strings.Join(pcs[:len(pcs)-3], "/")
+	} else if s.enableTileServerGL && strings.HasPrefix(id, StylesRoot+"/") {
+		// generated style of a tileset, unless the styles directory contains a
+		// style with the same ID
+		styleID, ok := strings.CutSuffix(strings.TrimPrefix(id, StylesRoot+"/"), "/"+styleFilename)
+		if !ok || (s.stylesDir != "" && validFileName(styleID) && s.hasStyleFile(styleID, styleFilename)) {
+			return ""
+		}
+		id = styleID
+	} else if s.enableOGCAPI && strings.HasPrefix(id, OGCAPIRoot+"/collections/") {
+		id, _, _ = s.splitTilesetPath(strings.TrimPrefix(id, OGCAPIRoot+"/collections/"))
 	} else {
 		// not on a subpath of service roots, so no id
 		return ""
@@ -265,6 +569,59 @@ func (s *ServiceSet) IDFromURLPath(id string) string {
 	return ""
 }
 
+// splitTilesetPath splits p into the ID of a tileset and the remaining path
+// after it, using the longest tileset ID that p starts with, since IDs can
+// contain slashes.  If p does not start with a tileset ID, ok is false.
+func (s *ServiceSet) splitTilesetPath(p string) (id string, rest string, ok bool) {
+	pcs := strings.Split(strings.Trim(p, "/"), "/")
+	for i := len(pcs); i > 0; i-- {
+		id = strings.Join(pcs[:i], "/")
+		if _, ok := s.tilesets[id]; ok {
+			return id, strings.Join(pcs[i:], "/"), true
+		}
+	}
+	return "", "", false
+}
+
+// idFromRequest extracts a tileset ID from the URL path of a request or, for
+// WMTS and WMS KVP requests, the layer parameter.  WMS requests for multiple
+// layers use their comma-delimited list of IDs, as do static maps with
+// overlays (e.g., "<tileset>,<overlay>").
+// If no valid ID is found, a blank string is returned.
+func (s *ServiceSet) idFromRequest(r *http.Request) string {
+	if s.enableWMS {
+		if layers := wmsLayersFromRequest(r); layers != "" {
+			for _, id := range strings.Split(layers, ",") {
+				if _, ok := s.tilesets[id]; !ok {
+					return ""
+				}
+			}
+			return layers
+		}
+	}
+	if s.enableWMTS {
+		if id := wmtsLayerFromRequest(r); id != "" {
+			if _, ok := s.tilesets[id]; ok {
+				return id
+			}
+			return ""
+		}
+	}
+
+	id := s.IDFromURLPath(r.URL.Path)
+	if id != "" && s.enableStaticMaps && strings.HasPrefix(r.URL.Path, s.rootURL.Path+"/"+id+"/static/") {
+		if overlay := r.URL.Query().Get("overlay"); overlay != "" {
+			for _, o := range strings.Split(overlay, ",") {
+				if _, ok := s.tilesets[o]; !ok {
+					return ""
+				}
+			}
+			return id + "," + overlay
+		}
+	}
+	return id
+}
+
 // Handler returns a http.Handler that serves the endpoints of the ServiceSet.
 // The function ef is called with any occurring error if it is non-nil, so it
 // can be used for e.g. logging with logging facilities of the caller.
@@ -276,6 +633,14 @@ func (s *ServiceSet) Handler() http.Handler {
 	// Route requests at the tileset or subpath to the corresponding tileset
 	m.HandleFunc(root, s.tilesetHandler)
 
+	// disabled endpoints are not found, unless the root URL is at the same
+	// path
+	notFound := func(pattern string) {
+		if pattern != root {
+			m.Handle(pattern, http.NotFoundHandler())
+		}
+	}
+
 	if s.enableServiceList {
 		m.HandleFunc(s.rootURL.Path, s.serviceListHandler)
 	} else {
@@ -286,7 +651,53 @@ func (s *ServiceSet) Handler() http.Handler {
 		m.HandleFunc(ArcGISInfoRoot, s.arcgisInfoHandler)
 		m.HandleFunc(ArcGISServicesRoot, s.tilesetHandler)
 	} else {
-		m.Handle(ArcGISRoot, http.NotFoundHandler())
+		notFound(ArcGISRoot)
+	}
+
+	if s.enableTMS {
+		m.HandleFunc(TMSRoot, s.tmsRootHandler)
+		m.HandleFunc(TMSRoot+"/", s.tmsRootHandler)
+		m.HandleFunc(TMSServiceRoot, s.tmsServiceHandler)
+		m.HandleFunc(TMSServiceRoot+"/", s.tmsServiceHandler)
+	} else {
+		notFound(TMSRoot + "/")
+	}
+
+	if s.enableWMTS {
+		m.HandleFunc(WMTSRoot, s.wmtsHandler)
+		m.HandleFunc(WMTSRoot+"/", s.wmtsHandler)
+		m.HandleFunc(WMTSServiceRoot, s.wmtsServiceHandler)
+		m.HandleFunc(WMTSServiceRoot+"/", s.wmtsServiceHandler)
+	} else {
+		notFound(WMTSRoot + "/")
+	}
+
+	if s.enableOGCAPI {
+		m.HandleFunc(OGCAPIRoot, s.ogcapiHandler)
+		m.HandleFunc(OGCAPIRoot+"/", s.ogcapiHandler)
+	} else {
+		notFound(OGCAPIRoot + "/")
+	}
+
+	if s.enableWMS {
+		m.HandleFunc(WMSRoot, s.wmsHandler)
+		m.HandleFunc(WMSRoot+"/", s.wmsHandler)
+	} else {
+		notFound(WMSRoot + "/")
+	}
+
+	if s.stylesDir != "" {
+		m.HandleFunc(StylesRoot, s.stylesHandler)
+		m.HandleFunc(StylesRoot+"/", s.stylesHandler)
+	} else {
+		notFound(StylesRoot + "/")
+	}
+
+	if s.fontsDir != "" {
+		m.HandleFunc(FontsRoot, s.fontsHandler)
+		m.HandleFunc(FontsRoot+"/", s.fontsHandler)
+	} else {
+		notFound(FontsRoot + "/")
 	}
 
 	return m
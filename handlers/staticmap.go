@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	mbtiles "github.com/brendan-ward/mbtiles-go"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+const (
+	// defaultStaticMapMaxSize is the default maximum width or height in
+	// pixels of static map images
+	defaultStaticMapMaxSize = 2048
+
+	// maximum zoom level of static map views
+	staticMapMaxZoom = 24
+
+	defaultMarkerColor = "#e55e5e"
+)
+
+// marker radius in pixels by marker-size property
+var markerSizes = map[string]float64{
+	"small":  5,
+	"medium": 7,
+	"large":  10,
+}
+
+// staticMap describes a static map image requested from the tileset
+type staticMap struct {
+	// web mercator bounds of the map
+	bounds [4]float64
+	// size of the map in pixels, including scale
+	width, height int
+	scale         int
+	format        mbtiles.TileFormat
+}
+
+// marker is a point drawn on a static map
+type marker struct {
+	lon, lat float64
+	color    color.RGBA
+	radius   float64
+}
+
+// parseStaticMap parses the view and size of a static map from a path of the
+// form {lon},{lat},{zoom}/{width}x{height}[@2x].{format} or
+// {minlon},{minlat},{maxlon},{maxlat}/{width}x{height}[@2x].{format}.
+// The bounds of a view defined by a bounding box are expanded to match the
+// aspect ratio of the image.
+func parseStaticMap(p string, maxSize int) (*staticMap, error) {
+	view, size, ok := strings.Cut(p, "/")
+	if !ok || strings.Contains(size, "/") {
+		return nil, fmt.Errorf("static map path must be <view>/<width>x<height>.<format>")
+	}
+
+	m := &staticMap{scale: 1}
+
+	ext := ""
+	if i := strings.LastIndex(size, "."); i >= 0 {
+		size, ext = size[:i], size[i:]
+	}
+	format, ok := tileFormatFromExtension(ext)
+	if !ok || (format != mbtiles.PNG && format != mbtiles.JPG) {
+		return nil, fmt.Errorf("static map format must be png or jpg")
+	}
+	m.format = format
+
+	if strings.HasSuffix(size, "@2x") {
+		size, m.scale = strings.TrimSuffix(size, "@2x"), 2
+	}
+	w, h, ok := strings.Cut(size, "x")
+	if !ok {
+		return nil, fmt.Errorf("static map size must be <width>x<height>")
+	}
+	width, err := strconv.Atoi(w)
+	if err != nil || width <= 0 || width*m.scale > maxSize {
+		return nil, fmt.Errorf("static map width must be between 1 and %d pixels", maxSize/m.scale)
+	}
+	height, err := strconv.Atoi(h)
+	if err != nil || height <= 0 || height*m.scale > maxSize {
+		return nil, fmt.Errorf("static map height must be between 1 and %d pixels", maxSize/m.scale)
+	}
+	m.width, m.height = width*m.scale, height*m.scale
+
+	var values []float64
+	for _, v := range strings.Split(view, ",") {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("static map view must be numeric")
+		}
+		values = append(values, f)
+	}
+
+	switch len(values) {
+	case 3:
+		lon, lat, zoom := values[0], values[1], values[2]
+		if lon < -180 || lon > 180 || math.Abs(lat) > maxMercatorLatitude {
+			return nil, fmt.Errorf("static map center must be within -180 to 180 longitude and %v to %v latitude", -maxMercatorLatitude, maxMercatorLatitude)
+		}
+		if zoom < 0 || zoom > staticMapMaxZoom {
+			return nil, fmt.Errorf("static map zoom must be between 0 and %d", staticMapMaxZoom)
+		}
+		// zoom levels are based on 256 pixel tiles
+		res := initialResolution / math.Exp2(zoom) / float64(m.scale)
+		x, y := lonLatToMercator(lon, lat)
+		m.bounds = viewBounds(x, y, res, m.width, m.height)
+
+	case 4:
+		if values[0] >= values[2] || values[1] >= values[3] {
+			return nil, fmt.Errorf("static map bounds minimum values must be less than maximum values")
+		}
+		xmin, ymin := lonLatToMercator(values[0], values[1])
+		xmax, ymax := lonLatToMercator(values[2], values[3])
+		if ymin >= ymax {
+			return nil, fmt.Errorf("static map bounds must be within %v to %v latitude", -maxMercatorLatitude, maxMercatorLatitude)
+		}
+		// expand the shorter side to match the aspect ratio of the image
+		res := math.Max((xmax-xmin)/float64(m.width), (ymax-ymin)/float64(m.height))
+		m.bounds = viewBounds((xmin+xmax)/2, (ymin+ymax)/2, res, m.width, m.height)
+
+	default:
+		return nil, fmt.Errorf("static map view must be <lon>,<lat>,<zoom> or <minlon>,<minlat>,<maxlon>,<maxlat>")
+	}
+
+	return m, nil
+}
+
+// parseMarkers parses markers from a GeoJSON Point or MultiPoint geometry,
+// Feature, or FeatureCollection.  The color and size of markers are set from
+// the marker-color and marker-size properties of features (simplestyle).
+func parseMarkers(value string) ([]marker, error) {
+	var header struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal([]byte(value), &header); err != nil {
+		return nil, fmt.Errorf("markers must be GeoJSON: %v", err)
+	}
+
+	var features []*geojson.Feature
+	switch header.Type {
+	case "FeatureCollection":
+		fc, err := geojson.UnmarshalFeatureCollection([]byte(value))
+		if err != nil {
+			return nil, fmt.Errorf("markers must be GeoJSON: %v", err)
+		}
+		features = fc.Features
+	case "Feature":
+		f, err := geojson.UnmarshalFeature([]byte(value))
+		if err != nil {
+			return nil, fmt.Errorf("markers must be GeoJSON: %v", err)
+		}
+		features = []*geojson.Feature{f}
+	default:
+		g, err := geojson.UnmarshalGeometry([]byte(value))
+		if err != nil {
+			return nil, fmt.Errorf("markers must be GeoJSON: %v", err)
+		}
+		features = []*geojson.Feature{geojson.NewFeature(g.Geometry())}
+	}
+
+	var markers []marker
+	for _, f := range features {
+		var points []orb.Point
+		switch g := f.Geometry.(type) {
+		case orb.Point:
+			points = []orb.Point{g}
+		case orb.MultiPoint:
+			points = g
+		default:
+			return nil, fmt.Errorf("markers must be GeoJSON Point or MultiPoint geometries")
+		}
+
+		c, err := parseHexColor(f.Properties.MustString("marker-color", defaultMarkerColor))
+		if err != nil {
+			return nil, fmt.Errorf("invalid marker-color: %v", err)
+		}
+		radius, ok := markerSizes[f.Properties.MustString("marker-size", "medium")]
+		if !ok {
+			return nil, fmt.Errorf("marker-size must be small, medium, or large")
+		}
+
+		for _, p := range points {
+			markers = append(markers, marker{lon: p.Lon(), lat: p.Lat(), color: c, radius: radius})
+		}
+	}
+	return markers, nil
+}
+
+// parseHexColor parses a CSS hex color of the form #rgb or #rrggbb
+func parseHexColor(value string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	c, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("%q is not a hex color", value)
+	}
+	return color.RGBA{uint8(c >> 16), uint8(c >> 8), uint8(c), 255}, nil
+}
+
+// drawMarkers draws markers onto img, which covers the web mercator bounds,
+// as filled circles with a white outline.  scale is applied to the size of the
+// markers.
+func drawMarkers(img *image.RGBA, bounds [4]float64, markers []marker, scale int) {
+	b := img.Bounds()
+	resX := (bounds[2] - bounds[0]) / float64(b.Dx())
+	resY := (bounds[3] - bounds[1]) / float64(b.Dy())
+	white := color.RGBA{255, 255, 255, 255}
+
+	for _, m := range markers {
+		x, y := lonLatToMercator(m.lon, m.lat)
+		cx, cy := (x-bounds[0])/resX, (bounds[3]-y)/resY
+		radius := m.radius * float64(scale)
+		outline := radius + 1.5*float64(scale)
+
+		r := image.Rect(int(cx-outline)-1, int(cy-outline)-1, int(cx+outline)+2, int(cy+outline)+2).Intersect(b)
+		for py := r.Min.Y; py < r.Max.Y; py++ {
+			for px := r.Min.X; px < r.Max.X; px++ {
+				d := math.Hypot(float64(px)+0.5-cx, float64(py)+0.5-cy)
+				switch {
+				case d <= radius:
+					img.SetRGBA(px, py, m.color)
+				case d <= outline:
+					img.SetRGBA(px, py, white)
+				}
+			}
+		}
+	}
+}
+
+// staticMapHandler is an http.HandlerFunc that renders a static map image of
+// the tileset, of the form
+// /services/<id>/static/<view>/<width>x<height>[@2x].<format>
+// Other image tilesets can be drawn over the tileset using the overlay query
+// parameter, and GeoJSON points can be drawn using the markers query
+// parameter.
+func (ts *Tileset) staticMapHandler(w http.ResponseWriter, r *http.Request) {
+	if !ts.published {
+		http.NotFound(w, r)
+		return
+	}
+
+	// wait up to 30 seconds to see if tileset is ready and return it if possible
+	if ts.isLockedWithTimeout(30 * time.Second) {
+		tilesetLockedHandler(w, r)
+		return
+	}
+
+	if !isImageFormat(ts.tileformat) {
+		http.Error(w, "static maps are only available for image tilesets", http.StatusBadRequest)
+		return
+	}
+
+	p := strings.TrimPrefix(r.URL.Path, ts.svc.rootURL.Path+"/"+ts.id+"/static/")
+	m, err := parseStaticMap(p, ts.svc.staticMapMaxSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	layers := []*Tileset{ts}
+	query := r.URL.Query()
+	if overlay := query.Get("overlay"); overlay != "" {
+		for _, id := range strings.Split(overlay, ",") {
+			o, ok := ts.svc.tilesets[id]
+			if !ok || o == nil || !o.published || !isImageFormat(o.tileformat) {
+				http.Error(w, fmt.Sprintf("overlay %q is not an image tileset", id), http.StatusBadRequest)
+				return
+			}
+			layers = append(layers, o)
+		}
+	}
+
+	var markers []marker
+	if value := query.Get("markers"); value != "" {
+		if markers, err = parseMarkers(value); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, m.width, m.height))
+	var modTime time.Time
+	for _, layer := range layers {
+		if layer != ts && layer.isLockedWithTimeout(30*time.Second) {
+			tilesetLockedHandler(w, r)
+			return
+		}
+
+		rendered, err := layer.renderMercator(m.bounds, m.width, m.height)
+		if errors.Is(err, errTooManyTiles) {
+			http.Error(w, fmt.Sprintf("tileset %q cannot be rendered at the requested zoom level", layer.id), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			ts.svc.logError("Could not render static map for %v: %v", r.URL.Path, err)
+			return
+		}
+		draw.Draw(img, img.Bounds(), rendered, image.Point{}, draw.Over)
+
+		if layer.db.GetTimestamp().After(modTime) {
+			modTime = layer.db.GetTimestamp()
+		}
+	}
+	drawMarkers(img, m.bounds, markers, m.scale)
+
+	data, format, err := encodeImage(img, m.format, ts.svc.jpegQuality)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		ts.svc.logError("Could not encode static map for %v: %v", r.URL.Path, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.MimeType())
+	setCacheControl(w, ts.cacheControl.Tiles)
+	if checkNotModified(w, r, contentETag(data), modTime) {
+		return
+	}
+	w.Write(data)
+}
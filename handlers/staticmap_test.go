@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_ParseStaticMap(t *testing.T) {
+	tests := []struct {
+		path   string
+		width  int
+		height int
+		scale  int
+		bounds [4]float64
+		err    bool
+	}{
+		{path: "0,0,0/256x256.png", width: 256, height: 256, scale: 1, bounds: [4]float64{-earthCircumference, -earthCircumference, earthCircumference, earthCircumference}},
+		{path: "0,0,0/256x256@2x.jpg", width: 512, height: 512, scale: 2, bounds: [4]float64{-earthCircumference, -earthCircumference, earthCircumference, earthCircumference}},
+		{path: "0,0,1/256x128.png", width: 256, height: 128, scale: 1, bounds: [4]float64{-earthCircumference / 2, -earthCircumference / 4, earthCircumference / 2, earthCircumference / 4}},
+		// bounds are expanded to match aspect ratio
+		{path: "-90,0,90,0.001/256x128.png", width: 256, height: 128, scale: 1, bounds: [4]float64{-earthCircumference / 2, -earthCircumference/4 + 55.66, earthCircumference / 2, earthCircumference/4 + 55.66}},
+		{path: "0,0,0/256x256.gif", err: true},
+		{path: "0,0,0/256x256", err: true},
+		{path: "0,0,0/256.png", err: true},
+		{path: "0,0,0/4096x256.png", err: true},
+		{path: "0,0,0/2048x256@2x.png", err: true},
+		{path: "0,0,0/0x256.png", err: true},
+		{path: "0,0/256x256.png", err: true},
+		{path: "0,0,25/256x256.png", err: true},
+		{path: "0,89,1/256x256.png", err: true},
+		{path: "10,0,0,10/256x256.png", err: true},
+		{path: "foo,0,0/256x256.png", err: true},
+		{path: "0,0,0/256x256.png/foo", err: true},
+	}
+
+	for _, tc := range tests {
+		m, err := parseStaticMap(tc.path, defaultStaticMapMaxSize)
+		if tc.err {
+			if err == nil {
+				t.Error("parseStaticMap did not raise expected error for:", tc.path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Error("parseStaticMap raised unexpected error for:", tc.path, err)
+			continue
+		}
+		if m.width != tc.width || m.height != tc.height || m.scale != tc.scale {
+			t.Error("parseStaticMap returned unexpected size for:", tc.path, m.width, m.height, m.scale)
+		}
+		for i := range m.bounds {
+			if math.Abs(m.bounds[i]-tc.bounds[i]) > 1 {
+				t.Error("parseStaticMap returned unexpected bounds for:", tc.path, m.bounds, "expected:", tc.bounds)
+				break
+			}
+		}
+	}
+}
+
+func Test_ParseMarkers(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	defaultColor, _ := parseHexColor(defaultMarkerColor)
+
+	tests := []struct {
+		value    string
+		expected []marker
+		err      bool
+	}{
+		{value: `{"type":"Point","coordinates":[10,20]}`, expected: []marker{{lon: 10, lat: 20, color: defaultColor, radius: 7}}},
+		{value: `{"type":"Feature","geometry":{"type":"MultiPoint","coordinates":[[1,2],[3,4]]},"properties":{"marker-color":"#f00","marker-size":"small"}}`, expected: []marker{
+			{lon: 1, lat: 2, color: red, radius: 5},
+			{lon: 3, lat: 4, color: red, radius: 5},
+		}},
+		{value: `{"type":"FeatureCollection","features":[{"type":"Feature","geometry":{"type":"Point","coordinates":[1,2]},"properties":{"marker-color":"#ff0000","marker-size":"large"}}]}`, expected: []marker{{lon: 1, lat: 2, color: red, radius: 10}}},
+		{value: `{"type":"LineString","coordinates":[[1,2],[3,4]]}`, err: true},
+		{value: `{"type":"Point","coordinates":[1,2]`, err: true},
+		{value: `{"type":"Feature","geometry":{"type":"Point","coordinates":[1,2]},"properties":{"marker-color":"red"}}`, err: true},
+		{value: `{"type":"Feature","geometry":{"type":"Point","coordinates":[1,2]},"properties":{"marker-size":"huge"}}`, err: true},
+	}
+
+	for _, tc := range tests {
+		markers, err := parseMarkers(tc.value)
+		if tc.err {
+			if err == nil {
+				t.Error("parseMarkers did not raise expected error for:", tc.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Error("parseMarkers raised unexpected error for:", tc.value, err)
+			continue
+		}
+		if len(markers) != len(tc.expected) {
+			t.Error("parseMarkers returned unexpected number of markers for:", tc.value, len(markers))
+			continue
+		}
+		for i, m := range markers {
+			if m != tc.expected[i] {
+				t.Error("parseMarkers returned unexpected marker for:", tc.value, m, "expected:", tc.expected[i])
+			}
+		}
+	}
+}
+
+func Test_StaticMapHandler(t *testing.T) {
+	svc := newTestServiceSet(t, &ServiceSetConfig{EnableStaticMaps: true}, "geography-class-png", "geography-class-webp", "world_cities")
+	handler := svc.Handler()
+
+	markers := url.QueryEscape(`{"type":"Point","coordinates":[0,0]}`)
+
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+		width       int
+		height      int
+	}{
+		{path: "/services/geography-class-png/static/0,0,1/300x200.png", status: 200, contentType: "image/png", width: 300, height: 200},
+		{path: "/services/geography-class-png/static/0,0,1/300x200@2x.jpg", status: 200, contentType: "image/jpeg", width: 600, height: 400},
+		{path: "/services/geography-class-png/static/-20,-10,20,10/300x200.png?overlay=geography-class-webp&markers=" + markers, status: 200, contentType: "image/png", width: 300, height: 200},
+		{path: "/services/geography-class-png/static/0,0,1/300x200.png?overlay=world_cities", status: 400},
+		{path: "/services/geography-class-png/static/0,0,1/300x200.png?markers=foo", status: 400},
+		{path: "/services/geography-class-png/static/0,0,1/3000x200.png", status: 400},
+		{path: "/services/world_cities/static/0,0,1/300x200.png", status: 400},
+	}
+
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != tc.status {
+			t.Error("Unexpected status code for:", tc.path, w.Code, "expected:", tc.status)
+			continue
+		}
+		if tc.status != 200 {
+			continue
+		}
+		if w.Header().Get("Content-Type") != tc.contentType {
+			t.Error("Unexpected content type for:", tc.path, w.Header().Get("Content-Type"), "expected:", tc.contentType)
+			continue
+		}
+		cfg, _, err := image.DecodeConfig(w.Body)
+		if err != nil || cfg.Width != tc.width || cfg.Height != tc.height {
+			t.Error("Unexpected image for:", tc.path, cfg.Width, cfg.Height, err)
+		}
+	}
+
+	if id := svc.IDFromURLPath("/services/geography-class-png/static/0,0,1/300x200.png"); id != "geography-class-png" {
+		t.Error("IDFromURLPath returned unexpected ID for static map:", id)
+	}
+
+	// overlays are included in the ID used for request authorization
+	ids := []struct {
+		path string
+		id   string
+	}{
+		{path: "/services/geography-class-png/static/0,0,1/300x200.png", id: "geography-class-png"},
+		{path: "/services/geography-class-png/static/0,0,1/300x200.png?overlay=geography-class-webp", id: "geography-class-png,geography-class-webp"},
+		{path: "/services/geography-class-png/static/0,0,1/300x200.png?overlay=geography-class-webp,foo", id: ""},
+		{path: "/services/geography-class-png/tiles/0/0/0.png?overlay=geography-class-webp", id: "geography-class-png"},
+	}
+	for _, tc := range ids {
+		if id := svc.idFromRequest(httptest.NewRequest("GET", tc.path, nil)); id != tc.id {
+			t.Error("idFromRequest returned unexpected ID for:", tc.path, id, "expected:", tc.id)
+		}
+	}
+
+	// maps that require too many tiles at the minimum zoom level of a layer
+	// are not returned as blank images
+	ts := svc.tilesets["geography-class-webp"]
+	ts.minzoom, ts.maxzoom = 3, 3
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/services/geography-class-png/static/0,0,0/256x256.png?overlay=geography-class-webp", nil))
+	if w.Code != 400 {
+		t.Error("Unexpected status code for static map requiring too many tiles:", w.Code, "expected:", 400)
+	}
+	if !strings.Contains(w.Body.String(), "cannot be rendered") {
+		t.Error("Unexpected response for static map requiring too many tiles:", w.Body.String())
+	}
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	mbtiles "github.com/brendan-ward/mbtiles-go"
+)
+
+// styleGeometryTypes are the geometry types of features in vector tiles, in
+// the order their layers are drawn in styles
+var styleGeometryTypes = []string{"Polygon", "LineString", "Point"}
+
+// styleLayerColor returns a color for a source layer, which is derived from
+// a hash of the ID of the layer so that it is the same every time the style
+// is generated.
+func styleLayerColor(id string) string {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	hue := float64(h.Sum32() % 360)
+
+	// convert from HSL with 65% saturation and 50% lightness to RGB
+	const s, l = 0.65, 0.5
+	c := (1 - math.Abs(2*l-1)) * s
+	x := c * (1 - math.Abs(math.Mod(hue/60, 2)-1))
+	m := l - c/2
+	var r, g, b float64
+	switch {
+	case hue < 60:
+		r, g, b = c, x, 0
+	case hue < 120:
+		r, g, b = x, c, 0
+	case hue < 180:
+		r, g, b = 0, c, x
+	case hue < 240:
+		r, g, b = 0, x, c
+	case hue < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return fmt.Sprintf("#%02x%02x%02x", int(math.Round((r+m)*255)), int(math.Round((g+m)*255)), int(math.Round((b+m)*255)))
+}
+
+// styleLayerGeometryTypes returns the geometry types of each source layer
+// from the tilestats metadata created by tippecanoe, if available.
+func styleLayerGeometryTypes(metadata map[string]interface{}) map[string]string {
+	types := make(map[string]string)
+	tilestats, _ := metadata["tilestats"].(map[string]interface{})
+	layers, _ := tilestats["layers"].([]interface{})
+	for _, item := range layers {
+		layer, _ := item.(map[string]interface{})
+		id, _ := layer["layer"].(string)
+		geometry, _ := layer["geometry"].(string)
+		if id != "" && geometry != "" {
+			types[id] = geometry
+		}
+	}
+	return types
+}
+
+// styleLayers returns a fill, line, or circle layer for each source layer in
+// vectorLayers.  If the geometry type of a source layer is not available
+// from tilestats, a layer is created for each geometry type.  Polygon layers
+// are drawn below line layers, which are drawn below point layers.
+func styleLayers(source string, vectorLayers []map[string]interface{}, geometryTypes map[string]string) []map[string]interface{} {
+	layers := []map[string]interface{}{}
+	for _, geometryType := range styleGeometryTypes {
+		for _, vectorLayer := range vectorLayers {
+			id := vectorLayer["id"].(string)
+			if t, ok := geometryTypes[id]; ok && t != geometryType {
+				continue
+			}
+
+			color := styleLayerColor(id)
+			layer := map[string]interface{}{
+				"source":       source,
+				"source-layer": id,
+				"filter":       []string{"==", "$type", geometryType},
+			}
+			switch geometryType {
+			case "Polygon":
+				layer["id"] = id + "-fill"
+				layer["type"] = "fill"
+				layer["paint"] = map[string]interface{}{
+					"fill-color":         color,
+					"fill-opacity":       0.5,
+					"fill-outline-color": color,
+				}
+			case "LineString":
+				layer["id"] = id + "-line"
+				layer["type"] = "line"
+				layer["paint"] = map[string]interface{}{
+					"line-color":   color,
+					"line-opacity": 0.8,
+					"line-width":   1.5,
+				}
+			case "Point":
+				layer["id"] = id + "-circle"
+				layer["type"] = "circle"
+				layer["paint"] = map[string]interface{}{
+					"circle-color":        color,
+					"circle-radius":       4,
+					"circle-stroke-color": "#ffffff",
+					"circle-stroke-width": 1,
+				}
+			}
+			// layers are shown at zoom levels above the maximum zoom
+			// level of the source layer using overzoomed tiles, so only
+			// the minimum zoom level is set
+			if minzoom, ok := vectorLayer["minzoom"]; ok {
+				layer["minzoom"] = minzoom
+			}
+			layers = append(layers, layer)
+		}
+	}
+	return layers
+}
+
+// Style returns a MapLibre / Mapbox GL style (as a map of strings to
+// interface{} values) that displays the tileset.  The style uses a source
+// that references the TileJSON of the tileset at svcURL, including query.
+// Vector tilesets have a layer for each source layer in vector_layers, using
+// a color derived from its ID; image tilesets have a single raster layer.
+func (ts *Tileset) Style(svcURL string, query string) (map[string]interface{}, error) {
+	if ts == nil || !ts.published {
+		return nil, fmt.Errorf("Tileset does not exist")
+	}
+
+	metadata, err := ts.db.ReadMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	values, _ := url.ParseQuery(strings.TrimPrefix(query, "?"))
+	filter, err := parseTileFilter(values)
+	if err != nil {
+		return nil, err
+	}
+
+	source := map[string]interface{}{
+		"url": svcURL + query,
+	}
+	var layers []map[string]interface{}
+	if ts.tileformat == mbtiles.PBF {
+		source["type"] = "vector"
+		vectorLayers := filter.filterVectorLayers(tileJSONVectorLayers(metadata["vector_layers"])).([]map[string]interface{})
+		layers = styleLayers(ts.id, vectorLayers, styleLayerGeometryTypes(metadata))
+	} else {
+		source["type"] = "raster"
+		source["tileSize"] = ts.renderTileSize()
+		layers = []map[string]interface{}{{
+			"id":     ts.id,
+			"type":   "raster",
+			"source": ts.id,
+		}}
+	}
+
+	return map[string]interface{}{
+		"version": 8,
+		"name":    ts.name,
+		"center":  ts.center[:2],
+		"zoom":    ts.center[2],
+		"sources": map[string]interface{}{ts.id: source},
+		"layers":  layers,
+	}, nil
+}
+
+// styleHandler is an http.HandlerFunc for the style endpoint of the tileset
+func (ts *Tileset) styleHandler(w http.ResponseWriter, r *http.Request) {
+	if ts == nil || !ts.published {
+		http.NotFound(w, r)
+		return
+	}
+
+	// wait up to 30 seconds to see if tileset is ready and return it if possible
+	if ts.isLockedWithTimeout(30 * time.Second) {
+		tilesetLockedHandler(w, r)
+		return
+	}
+
+	query := ""
+	if r.URL.RawQuery != "" {
+		query = "?" + r.URL.RawQuery
+	}
+
+	if err := validateTileJSONQuery(r.URL.Query()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tilesetURL := fmt.Sprintf("%s://%s%s", scheme(r), getRequestHost(r), strings.TrimSuffix(r.URL.Path, "/style.json"))
+
+	style, err := ts.Style(tilesetURL, query)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		ts.svc.logError("could not create style for %v: %v", r.URL.Path, err)
+		return
+	}
+
+	bytes, err := json.Marshal(style)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		ts.svc.logError("could not render style for %v: %v", r.URL.Path, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	setCacheControl(w, ts.cacheControl.TileJSON)
+	if checkNotModified(w, r, contentETag(bytes), ts.db.GetTimestamp()) {
+		return
+	}
+	_, err = w.Write(bytes)
+
+	if err != nil {
+		ts.svc.logError("could not write style for %v: %v", r.URL.Path, err)
+	}
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func Test_StyleLayerColor(t *testing.T) {
+	hexColor := regexp.MustCompile(`^#[0-9a-f]{6}$`)
+	for _, id := range []string{"cities", "roads", "water", ""} {
+		color := styleLayerColor(id)
+		if !hexColor.MatchString(color) {
+			t.Error("Unexpected color for:", id, color)
+		}
+		if styleLayerColor(id) != color {
+			t.Error("Expected the same color for:", id)
+		}
+	}
+	if styleLayerColor("roads") == styleLayerColor("water") {
+		t.Error("Expected different colors for different layers")
+	}
+}
+
+func Test_StyleLayers(t *testing.T) {
+	vectorLayers := []map[string]interface{}{
+		{"id": "water", "fields": map[string]string{}},
+		{"id": "places", "fields": map[string]string{}, "minzoom": 4},
+	}
+
+	tests := []struct {
+		geometryTypes map[string]string
+		expected      []string
+	}{
+		{
+			geometryTypes: map[string]string{"water": "Polygon", "places": "Point"},
+			expected:      []string{"water-fill", "places-circle"},
+		},
+		{
+			geometryTypes: map[string]string{"places": "Point"},
+			expected:      []string{"water-fill", "water-line", "water-circle", "places-circle"},
+		},
+	}
+
+	for _, tc := range tests {
+		layers := styleLayers("test", vectorLayers, tc.geometryTypes)
+		var ids []string
+		for _, layer := range layers {
+			ids = append(ids, layer["id"].(string))
+			if layer["source"] != "test" {
+				t.Error("Unexpected source for layer:", layer["id"], layer["source"])
+			}
+		}
+		if !reflect.DeepEqual(ids, tc.expected) {
+			t.Error("Unexpected layers:", ids, "expected:", tc.expected)
+		}
+	}
+}
+
+func Test_StyleHandler(t *testing.T) {
+	svc := newTestServiceSet(t, &ServiceSetConfig{EnableTileJSON: true}, "geography-class-png", "world_cities")
+	handler := svc.Handler()
+
+	tests := []struct {
+		id         string
+		query      string
+		sourceType string
+		url        string
+		layers     []string
+	}{
+		{
+			id:         "world_cities",
+			sourceType: "vector",
+			url:        "http://example.com/services/world_cities",
+			layers:     []string{"cities-circle"},
+		},
+		{
+			id:         "world_cities",
+			query:      "?tilejson=3.0.0",
+			sourceType: "vector",
+			url:        "http://example.com/services/world_cities?tilejson=3.0.0",
+			layers:     []string{"cities-circle"},
+		},
+		{
+			id:         "geography-class-png",
+			sourceType: "raster",
+			url:        "http://example.com/services/geography-class-png",
+			layers:     []string{"geography-class-png"},
+		},
+	}
+
+	for _, tc := range tests {
+		path := "/services/" + tc.id + "/style.json" + tc.query
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != 200 {
+			t.Error("Unexpected status code for:", path, rec.Code)
+			continue
+		}
+		if contentType := rec.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Error("Unexpected content type for:", path, contentType)
+		}
+
+		var style struct {
+			Version int `json:"version"`
+			Sources map[string]struct {
+				Type string `json:"type"`
+				URL  string `json:"url"`
+			} `json:"sources"`
+			Layers []struct {
+				ID     string `json:"id"`
+				Source string `json:"source"`
+			} `json:"layers"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &style); err != nil {
+			t.Error("Could not parse style for:", path, err)
+			continue
+		}
+		if style.Version != 8 {
+			t.Error("Unexpected style version for:", path, style.Version)
+		}
+		source, ok := style.Sources[tc.id]
+		if !ok {
+			t.Error("Missing source for:", path)
+			continue
+		}
+		if source.Type != tc.sourceType || source.URL != tc.url {
+			t.Error("Unexpected source for:", path, source, "expected:", tc.sourceType, tc.url)
+		}
+		var layers []string
+		for _, layer := range style.Layers {
+			layers = append(layers, layer.ID)
+			if layer.Source != tc.id {
+				t.Error("Unexpected source for layer:", layer.ID, layer.Source)
+			}
+		}
+		if !reflect.DeepEqual(layers, tc.layers) {
+			t.Error("Unexpected layers for:", path, layers, "expected:", tc.layers)
+		}
+	}
+
+	if id := svc.IDFromURLPath("/services/world_cities/style.json"); id != "world_cities" {
+		t.Error("IDFromURLPath returned unexpected ID for style:", id)
+	}
+
+	// style is not available if TileJSON is disabled
+	svc = newTestServiceSet(t, &ServiceSetConfig{}, "world_cities")
+	rec := httptest.NewRecorder()
+	svc.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/services/world_cities/style.json", nil))
+	if rec.Code != 404 {
+		t.Error("Expected 404 for style when TileJSON is disabled, got:", rec.Code)
+	}
+}
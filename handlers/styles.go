@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// StylesRoot is the root URL of map styles served from the styles directory
+const StylesRoot = "/styles"
+
+// styleFilename is the name of the style JSON file in the directory of each
+// style within the styles directory
+const styleFilename = "style.json"
+
+// styleSpriteFiles lists the sprite files that may be in the directory of
+// each style, and the file used if each is missing
+var styleSpriteFiles = map[string]string{
+	"sprite.json":    "",
+	"sprite.png":     "",
+	"sprite@2x.json": "sprite.json",
+	"sprite@2x.png":  "sprite.png",
+}
+
+// StyleInfo provides basic information about a map style in the styles
+// directory
+type StyleInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// isAbsoluteURL returns true if value is an http or https URL
+func isAbsoluteURL(value string) bool {
+	return strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://")
+}
+
+// mbtilesSourceID returns the tileset ID from an mbtiles://<id> or
+// mbtiles://{<id>} source URL
+func mbtilesSourceID(value string) (string, bool) {
+	if !strings.HasPrefix(value, "mbtiles://") {
+		return "", false
+	}
+	id := strings.TrimPrefix(value, "mbtiles://")
+	if strings.HasPrefix(id, "{") && strings.HasSuffix(id, "}") {
+		id = id[1 : len(id)-1]
+	}
+	return id, id != ""
+}
+
+// readStyle returns the style JSON of the style with id from the styles
+// directory, and the time it was last modified.  Returns nil if the style
+// does not exist.
+func (s *ServiceSet) readStyle(id string) (map[string]interface{}, time.Time, error) {
+	if !validFileName(id) {
+		return nil, time.Time{}, nil
+	}
+	filename := filepath.Join(s.stylesDir, id, styleFilename)
+	stat, err := os.Stat(filename)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, time.Time{}, nil
+		}
+		return nil, time.Time{}, err
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var style map[string]interface{}
+	if err = json.Unmarshal(data, &style); err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid style %q: %v", filename, err)
+	}
+	return style, stat.ModTime(), nil
+}
+
+// hasStyleFile returns true if the file exists in the directory of the style
+// with id
+func (s *ServiceSet) hasStyleFile(id string, name string) bool {
+	stat, err := os.Stat(filepath.Join(s.stylesDir, id, name))
+	return err == nil && stat.Mode().IsRegular()
+}
+
+// rewriteStyle rewrites URLs in style so that they refer to this server at
+// baseURL (scheme and host):
+//   - sources with mbtiles://<id> URLs use the TileJSON URL of the tileset
+//     returned by tileJSONURL
+//   - sprite uses the sprite in the directory of the style, if available and
+//     sprite is not an http or https URL
+//   - glyphs uses the fonts in the fonts directory, if available and glyphs
+//     is not an http or https URL
+func (s *ServiceSet) rewriteStyle(style map[string]interface{}, id string, baseURL string, tileJSONURL func(tilesetID string) string) {
+	if sources, ok := style["sources"].(map[string]interface{}); ok {
+		for _, v := range sources {
+			source, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			url, _ := source["url"].(string)
+			if tilesetID, ok := mbtilesSourceID(url); ok {
+				source["url"] = tileJSONURL(tilesetID)
+			}
+		}
+	}
+
+	if sprite, _ := style["sprite"].(string); !isAbsoluteURL(sprite) && s.hasStyleFile(id, "sprite.json") {
+		style["sprite"] = fmt.Sprintf("%s%s/%s/sprite", baseURL, StylesRoot, id)
+	}
+
+	if glyphs, _ := style["glyphs"].(string); !isAbsoluteURL(glyphs) && s.fontsDir != "" {
+		style["glyphs"] = fmt.Sprintf("%s%s/{fontstack}/{range}.pbf", baseURL, FontsRoot)
+	}
+}
+
+// listStyles returns information about the styles in the styles directory,
+// which are its subdirectories that contain a style.json file.
+func (s *ServiceSet) listStyles(baseURL string) ([]StyleInfo, error) {
+	entries, err := os.ReadDir(s.stylesDir)
+	if err != nil {
+		return nil, err
+	}
+	styles := []StyleInfo{}
+	for _, entry := range entries {
+		if !entry.IsDir() || !validFileName(entry.Name()) {
+			continue
+		}
+		style, _, err := s.readStyle(entry.Name())
+		if err != nil {
+			s.logError("Could not read style: %v", err)
+			continue
+		}
+		if style == nil {
+			continue
+		}
+		name, _ := style["name"].(string)
+		if name == "" {
+			name = entry.Name()
+		}
+		styles = append(styles, StyleInfo{
+			ID:   entry.Name(),
+			Name: name,
+			URL:  fmt.Sprintf("%s%s/%s/%s", baseURL, StylesRoot, entry.Name(), styleFilename),
+		})
+	}
+	return styles, nil
+}
+
+// stylesHandler is an http.HandlerFunc that returns the list of styles at
+// /styles, the style JSON of a style at /styles/<id>/style.json, or its
+// sprite at /styles/<id>/sprite[@2x].{json,png}.  If the @2x sprite is not
+// available, the standard sprite is returned instead.
+func (s *ServiceSet) stylesHandler(w http.ResponseWriter, r *http.Request) {
+	baseURL := fmt.Sprintf("%s://%s", scheme(r), getRequestHost(r))
+	p := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, StylesRoot), "/")
+
+	if p == "" {
+		styles, err := s.listStyles(baseURL)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			s.logError("Could not list styles: %v", err)
+			return
+		}
+		bytes, err := json.Marshal(styles)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			s.logError("Could not render style list: %v", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		setCacheControl(w, s.cacheControl.ServiceList)
+		if checkNotModified(w, r, contentETag(bytes), time.Time{}) {
+			return
+		}
+		w.Write(bytes)
+		return
+	}
+
+	id, name, ok := strings.Cut(p, "/")
+	if !ok || !validFileName(id) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if name == styleFilename {
+		style, modTime, err := s.readStyle(id)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			s.logError("Could not read style for %v: %v", r.URL.Path, err)
+			return
+		}
+		if style == nil {
+			http.NotFound(w, r)
+			return
+		}
+		s.rewriteStyle(style, id, baseURL, func(tilesetID string) string {
+			return fmt.Sprintf("%s%s/%s", baseURL, s.rootURL.Path, tilesetID)
+		})
+
+		bytes, err := json.Marshal(style)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			s.logError("Could not render style for %v: %v", r.URL.Path, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		setCacheControl(w, s.cacheControl.TileJSON)
+		if checkNotModified(w, r, contentETag(bytes), modTime) {
+			return
+		}
+		w.Write(bytes)
+		return
+	}
+
+	fallback, ok := styleSpriteFiles[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.hasStyleFile(id, name) {
+		if fallback == "" || !s.hasStyleFile(id, fallback) {
+			http.NotFound(w, r)
+			return
+		}
+		name = fallback
+	}
+
+	filename := filepath.Join(s.stylesDir, id, name)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.logError("Could not read sprite for %v: %v", r.URL.Path, err)
+		return
+	}
+	stat, err := os.Stat(filename)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.logError("Could not read sprite for %v: %v", r.URL.Path, err)
+		return
+	}
+
+	contentType := "application/json"
+	if strings.HasSuffix(name, ".png") {
+		contentType = "image/png"
+	}
+	w.Header().Set("Content-Type", contentType)
+	setCacheControl(w, s.cacheControl.Tiles)
+	if checkNotModified(w, r, contentETag(data), stat.ModTime()) {
+		return
+	}
+	w.Write(data)
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestStylesDir returns a styles directory with a "basic" style with
+// sprites and a "plain" style without sprites
+func newTestStylesDir(t *testing.T) string {
+	dir := t.TempDir()
+	files := map[string]string{
+		"basic/style.json": `{
+			"version": 8,
+			"name": "Basic",
+			"sprite": "sprite",
+			"glyphs": "{fontstack}/{range}.pbf",
+			"sources": {
+				"cities": {"type": "vector", "url": "mbtiles://world_cities"},
+				"geography": {"type": "raster", "url": "mbtiles://{geography-class-png}"},
+				"remote": {"type": "vector", "url": "https://example.org/tiles.json"}
+			},
+			"layers": []
+		}`,
+		"basic/sprite.json":    `{"icon": {"x": 0, "y": 0, "width": 16, "height": 16, "pixelRatio": 1}}`,
+		"basic/sprite.png":     "png",
+		"basic/sprite@2x.json": `{"icon": {"x": 0, "y": 0, "width": 32, "height": 32, "pixelRatio": 2}}`,
+		"plain/style.json": `{
+			"version": 8,
+			"sprite": "https://example.org/sprite",
+			"glyphs": "https://example.org/fonts/{fontstack}/{range}.pbf",
+			"sources": {},
+			"layers": []
+		}`,
+		"invalid/style.json": `{`,
+		"empty/readme.txt":   "not a style",
+	}
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func Test_MBTilesSourceID(t *testing.T) {
+	tests := []struct {
+		value string
+		id    string
+		ok    bool
+	}{
+		{value: "mbtiles://world_cities", id: "world_cities", ok: true},
+		{value: "mbtiles://{world_cities}", id: "world_cities", ok: true},
+		{value: "mbtiles://nested/world_cities", id: "nested/world_cities", ok: true},
+		{value: "mbtiles://", id: "", ok: false},
+		{value: "https://example.org/tiles.json", id: "", ok: false},
+	}
+
+	for _, tc := range tests {
+		id, ok := mbtilesSourceID(tc.value)
+		if id != tc.id || ok != tc.ok {
+			t.Error("mbtilesSourceID returned unexpected value for:", tc.value, id, ok, "expected:", tc.id, tc.ok)
+		}
+	}
+}
+
+func Test_StylesHandler(t *testing.T) {
+	svc := newTestServiceSet(t, &ServiceSetConfig{StylesDir: newTestStylesDir(t), FontsDir: t.TempDir()}, "world_cities")
+	handler := svc.Handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/styles", nil))
+	if rec.Code != 200 {
+		t.Fatal("Unexpected status code for style list:", rec.Code)
+	}
+	var styles []StyleInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &styles); err != nil {
+		t.Fatal("Could not parse style list:", err)
+	}
+	expectedStyles := []StyleInfo{
+		{ID: "basic", Name: "Basic", URL: "http://example.com/styles/basic/style.json"},
+		{ID: "plain", Name: "plain", URL: "http://example.com/styles/plain/style.json"},
+	}
+	if len(styles) != len(expectedStyles) {
+		t.Fatal("Unexpected style list:", styles, "expected:", expectedStyles)
+	}
+	for i := range styles {
+		if styles[i] != expectedStyles[i] {
+			t.Error("Unexpected style in style list:", styles[i], "expected:", expectedStyles[i])
+		}
+	}
+
+	tests := []struct {
+		path    string
+		sources map[string]string
+		sprite  string
+		glyphs  string
+	}{
+		{
+			path: "/styles/basic/style.json",
+			sources: map[string]string{
+				"cities":    "http://example.com/services/world_cities",
+				"geography": "http://example.com/services/geography-class-png",
+				"remote":    "https://example.org/tiles.json",
+			},
+			sprite: "http://example.com/styles/basic/sprite",
+			glyphs: "http://example.com/fonts/{fontstack}/{range}.pbf",
+		},
+		{
+			path:    "/styles/plain/style.json",
+			sources: map[string]string{},
+			sprite:  "https://example.org/sprite",
+			glyphs:  "https://example.org/fonts/{fontstack}/{range}.pbf",
+		},
+	}
+
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+		if rec.Code != 200 {
+			t.Error("Unexpected status code for:", tc.path, rec.Code)
+			continue
+		}
+
+		var style struct {
+			Sprite  string `json:"sprite"`
+			Glyphs  string `json:"glyphs"`
+			Sources map[string]struct {
+				URL string `json:"url"`
+			} `json:"sources"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &style); err != nil {
+			t.Error("Could not parse style for:", tc.path, err)
+			continue
+		}
+		if style.Sprite != tc.sprite {
+			t.Error("Unexpected sprite for:", tc.path, style.Sprite, "expected:", tc.sprite)
+		}
+		if style.Glyphs != tc.glyphs {
+			t.Error("Unexpected glyphs for:", tc.path, style.Glyphs, "expected:", tc.glyphs)
+		}
+		for id, url := range tc.sources {
+			if style.Sources[id].URL != url {
+				t.Error("Unexpected URL for source:", id, style.Sources[id].URL, "expected:", url)
+			}
+		}
+	}
+
+	fileTests := []struct {
+		path        string
+		status      int
+		contentType string
+		contains    string
+	}{
+		{path: "/styles/basic/sprite.json", status: 200, contentType: "application/json", contains: `"pixelRatio": 1`},
+		{path: "/styles/basic/sprite@2x.json", status: 200, contentType: "application/json", contains: `"pixelRatio": 2`},
+		{path: "/styles/basic/sprite.png", status: 200, contentType: "image/png", contains: "png"},
+		// falls back to standard sprite if @2x is not available
+		{path: "/styles/basic/sprite@2x.png", status: 200, contentType: "image/png", contains: "png"},
+		{path: "/styles/plain/sprite.json", status: 404},
+		{path: "/styles/basic/other.json", status: 404},
+		{path: "/styles/missing/style.json", status: 404},
+		{path: "/styles/empty/style.json", status: 404},
+		{path: "/styles/invalid/style.json", status: 500},
+		{path: "/styles/basic", status: 404},
+	}
+
+	for _, tc := range fileTests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+		if rec.Code != tc.status {
+			t.Error("Unexpected status code for:", tc.path, rec.Code, "expected:", tc.status)
+			continue
+		}
+		if tc.status != 200 {
+			continue
+		}
+		if contentType := rec.Header().Get("Content-Type"); contentType != tc.contentType {
+			t.Error("Unexpected content type for:", tc.path, contentType, "expected:", tc.contentType)
+		}
+		if body := rec.Body.String(); !strings.Contains(body, tc.contains) {
+			t.Error("Unexpected response for:", tc.path, body)
+		}
+	}
+
+	// styles are not available if styles directory is not set
+	svc = newTestServiceSet(t, &ServiceSetConfig{})
+	rec = httptest.NewRecorder()
+	svc.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/styles/basic/style.json", nil))
+	if rec.Code != 404 {
+		t.Error("Expected 404 for styles when styles directory is not set, got:", rec.Code)
+	}
+}
@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	mbtiles "github.com/brendan-ward/mbtiles-go"
+)
+
+const (
+	defaultHillshadeAzimuth  = 315.0
+	defaultHillshadeAltitude = 45.0
+	// maximum vertical exaggeration of hillshade and slope tiles
+	maxExaggeration = 100.0
+)
+
+// elevationTile is the decoded elevation in meters of each pixel of a tile of
+// a raster DEM tileset, by row.  Missing values are NaN.
+type elevationTile struct {
+	width, height int
+	values        []float64
+}
+
+// at returns the elevation of pixel x, y of the tile, clamped to its edges
+func (e *elevationTile) at(x, y int) float64 {
+	x = min(max(x, 0), e.width-1)
+	y = min(max(y, 0), e.height-1)
+	return e.values[y*e.width+x]
+}
+
+// decodeElevationTile decodes the elevation of each pixel of img using
+// encoding.  Transparent pixels are NaN.
+func decodeElevationTile(img image.Image, encoding string) *elevationTile {
+	b := img.Bounds()
+	e := &elevationTile{width: b.Dx(), height: b.Dy(), values: make([]float64, b.Dx()*b.Dy())}
+	for y := 0; y < e.height; y++ {
+		for x := 0; x < e.width; x++ {
+			c := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			value, ok := decodeElevation(encoding, c)
+			if !ok {
+				value = math.NaN()
+			}
+			e.values[y*e.width+x] = value
+		}
+	}
+	return e
+}
+
+// resampleElevation returns a new elevation tile of size x size pixels,
+// interpolated bilinearly from the region of src starting at pixel offset
+// (x0, y0) with width and height of span pixels.  Interpolation uses the
+// nearest value where any of the surrounding values are missing.
+func resampleElevation(src *elevationTile, x0, y0, span float64, size int) *elevationTile {
+	dst := &elevationTile{width: size, height: size, values: make([]float64, size*size)}
+	k := span / float64(size)
+	for j := 0; j < size; j++ {
+		// pixel centers in src
+		sy := y0 + (float64(j)+0.5)*k - 0.5
+		y := int(math.Floor(sy))
+		ty := sy - float64(y)
+		for i := 0; i < size; i++ {
+			sx := x0 + (float64(i)+0.5)*k - 0.5
+			x := int(math.Floor(sx))
+			tx := sx - float64(x)
+
+			v00, v10 := src.at(x, y), src.at(x+1, y)
+			v01, v11 := src.at(x, y+1), src.at(x+1, y+1)
+			value := (v00*(1-tx)+v10*tx)*(1-ty) + (v01*(1-tx)+v11*tx)*ty
+			if math.IsNaN(value) {
+				value = src.at(int(math.Round(sx)), int(math.Round(sy)))
+			}
+			dst.values[j*size+i] = value
+		}
+	}
+	return dst
+}
+
+// readElevation reads the elevation tile for XYZ tile coordinate tc of a
+// raster DEM tileset.  If the tile is not found and overzoom is enabled, it
+// is interpolated from the elevation of its nearest ancestor tile, instead of
+// from its colors.  Returns nil if the tile does not exist.
+func (ts *Tileset) readElevation(tc tileCoord) (*elevationTile, error) {
+	data, err := ts.readTile(tc)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		img, err := decodeImage(data)
+		if err != nil {
+			return nil, err
+		}
+		return decodeElevationTile(img, ts.demEncoding), nil
+	}
+
+	if !ts.overzoom || tc.z <= int64(ts.minzoom) || tc.z > int64(ts.overzoomMaxZoom) {
+		return nil, nil
+	}
+	parent, data, err := ts.findAncestor(tc)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	src := decodeElevationTile(img, ts.demEncoding)
+
+	// the region of the ancestor covered by tc, in pixels
+	dz := uint64(tc.z - parent.z)
+	span := float64(src.width) / float64(uint64(1)<<dz)
+	x0 := float64(tc.x-parent.x<<dz) * span
+	y0 := float64(tc.y-parent.y<<dz) * span
+	return resampleElevation(src, x0, y0, span, src.width), nil
+}
+
+// readElevationWithBuffer reads the elevation tile for XYZ tile coordinate tc
+// with a one pixel buffer of elevation values from adjacent tiles.  Adjacent
+// tiles wrap around the antimeridian; where they are missing, or are a
+// different size, the edge values of the tile are repeated.  Returns nil if
+// the tile does not exist.
+func (ts *Tileset) readElevationWithBuffer(tc tileCoord) (*elevationTile, error) {
+	center, err := ts.readElevation(tc)
+	if err != nil || center == nil {
+		return nil, err
+	}
+
+	w, h := center.width, center.height
+	out := &elevationTile{width: w + 2, height: h + 2, values: make([]float64, (w+2)*(h+2))}
+	for y := -1; y <= h; y++ {
+		for x := -1; x <= w; x++ {
+			out.values[(y+1)*out.width+x+1] = center.at(x, y)
+		}
+	}
+
+	n := int64(1) << uint(tc.z)
+	for dy := int64(-1); dy <= 1; dy++ {
+		for dx := int64(-1); dx <= 1; dx++ {
+			row := tc.y + dy
+			if (dx == 0 && dy == 0) || row < 0 || row >= n {
+				continue
+			}
+			neighbor, err := ts.readElevation(tileCoord{z: tc.z, x: ((tc.x+dx)%n + n) % n, y: row})
+			if err != nil {
+				return nil, err
+			}
+			if neighbor == nil || neighbor.width != w || neighbor.height != h {
+				continue
+			}
+
+			// copy the values of the neighbor adjacent to the tile into the
+			// buffer
+			for y := -1; y <= h; y++ {
+				for x := -1; x <= w; x++ {
+					ny, nx := y-int(dy)*h, x-int(dx)*w
+					if nx < 0 || nx >= w || ny < 0 || ny >= h {
+						continue
+					}
+					out.values[(y+1)*out.width+x+1] = neighbor.values[ny*w+nx]
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+// terrainParams are the parameters of hillshade and slope tiles
+type terrainParams struct {
+	// azimuth of the light source in degrees clockwise from north
+	azimuth float64
+	// altitude of the light source in degrees above the horizon
+	altitude float64
+	// vertical exaggeration of elevation
+	exaggeration float64
+}
+
+// parseTerrainParams parses terrain parameters from the azimuth, altitude,
+// and exaggeration query parameters
+func parseTerrainParams(query url.Values) (terrainParams, error) {
+	p := terrainParams{
+		azimuth:      defaultHillshadeAzimuth,
+		altitude:     defaultHillshadeAltitude,
+		exaggeration: 1,
+	}
+
+	var err error
+	if value := query.Get("azimuth"); value != "" {
+		if p.azimuth, err = strconv.ParseFloat(value, 64); err != nil || !(p.azimuth >= 0 && p.azimuth <= 360) {
+			return p, fmt.Errorf("azimuth must be a number between 0 and 360")
+		}
+	}
+	if value := query.Get("altitude"); value != "" {
+		if p.altitude, err = strconv.ParseFloat(value, 64); err != nil || !(p.altitude >= 0 && p.altitude <= 90) {
+			return p, fmt.Errorf("altitude must be a number between 0 and 90")
+		}
+	}
+	if value := query.Get("exaggeration"); value != "" {
+		if p.exaggeration, err = strconv.ParseFloat(value, 64); err != nil || !(p.exaggeration > 0 && p.exaggeration <= maxExaggeration) {
+			return p, fmt.Errorf("exaggeration must be a number greater than 0 and up to %v", maxExaggeration)
+		}
+	}
+	return p, nil
+}
+
+// renderTerrain renders a hillshade or slope image of the pixels of the XYZ
+// tile tc from elevation tile e, which has a one pixel buffer around tc.
+// Slope and aspect are calculated using Horn's method, with the size of
+// pixels on the ground at the latitude of each row.
+//
+// Hillshade is the brightness of the surface lit from the azimuth and
+// altitude of params.  Slope is white for flat surfaces through black for
+// vertical surfaces.  Pixels with missing elevation values are transparent.
+func renderTerrain(e *elevationTile, tc tileCoord, product string, params terrainParams) *image.NRGBA {
+	w, h := e.width-2, e.height-2
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+
+	zenith := (90 - params.altitude) * math.Pi / 180
+	azimuth := (360 - params.azimuth + 90) * math.Pi / 180
+	// size of pixels at the equator in meters
+	res := 2 * earthCircumference / math.Exp2(float64(tc.z)) / float64(w)
+
+	for y := 0; y < h; y++ {
+		_, lat := tileToLonLat(float64(tc.x), float64(tc.y)+(float64(y)+0.5)/float64(h), int(tc.z))
+		k := params.exaggeration / (8 * res * math.Cos(lat*math.Pi/180))
+
+		for x := 0; x < w; x++ {
+			// 3x3 window centered on the pixel, which is offset by the buffer
+			a, b, c := e.values[y*e.width+x], e.values[y*e.width+x+1], e.values[y*e.width+x+2]
+			d, f := e.values[(y+1)*e.width+x], e.values[(y+1)*e.width+x+2]
+			g, hh, i := e.values[(y+2)*e.width+x], e.values[(y+2)*e.width+x+1], e.values[(y+2)*e.width+x+2]
+			if math.IsNaN(e.values[(y+1)*e.width+x+1]) {
+				continue
+			}
+
+			dzdx := ((c + 2*f + i) - (a + 2*d + g)) * k
+			dzdy := ((g + 2*hh + i) - (a + 2*b + c)) * k
+			if math.IsNaN(dzdx) || math.IsNaN(dzdy) {
+				// flat where adjacent values are missing
+				dzdx, dzdy = 0, 0
+			}
+			slope := math.Atan(math.Hypot(dzdx, dzdy))
+
+			var value float64
+			if product == "slope" {
+				value = 1 - slope/(math.Pi/2)
+			} else {
+				aspect := math.Atan2(dzdy, -dzdx)
+				value = math.Cos(zenith)*math.Cos(slope) + math.Sin(zenith)*math.Sin(slope)*math.Cos(azimuth-aspect)
+			}
+			v := uint8(math.Round(255 * math.Min(math.Max(value, 0), 1)))
+			img.SetNRGBA(x, y, color.NRGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+// terrainHandler returns an http.HandlerFunc that renders hillshade or slope
+// (product) PNG tiles of a raster DEM tileset, of the form
+// /services/<id>/<product>/<z>/<x>/<y>.png
+func (ts *Tileset) terrainHandler(product string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ts.published {
+			http.NotFound(w, r)
+			return
+		}
+
+		// wait up to 30 seconds to see if tileset is ready and return it if possible
+		if ts.isLockedWithTimeout(30 * time.Second) {
+			tilesetLockedHandler(w, r)
+			return
+		}
+
+		if ts.demEncoding == "" {
+			http.Error(w, fmt.Sprintf("%s tiles are only available for elevation tilesets", product), http.StatusBadRequest)
+			return
+		}
+
+		pcs := strings.Split(strings.TrimPrefix(r.URL.Path, ts.svc.rootURL.Path+"/"+ts.id+"/"+product+"/"), "/")
+		if len(pcs) != 3 {
+			http.Error(w, fmt.Sprintf("%s tile path must be <z>/<x>/<y>.png", product), http.StatusBadRequest)
+			return
+		}
+		tc, ext, err := tileCoordFromString(pcs[0], pcs[1], pcs[2])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if ext != "" && ext != ".png" {
+			http.Error(w, fmt.Sprintf("%s tiles are only available as png", product), http.StatusBadRequest)
+			return
+		}
+
+		params, err := parseTerrainParams(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		e, err := ts.readElevationWithBuffer(tc)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			ts.svc.logError("Could not read elevation for %v: %v", r.URL.Path, err)
+			return
+		}
+		if e == nil {
+			tileNotFoundHandler(w, r, mbtiles.PNG, ts.tilesize, ts.svc.returnMissingImageTile404)
+			return
+		}
+
+		data, _, err := encodeImage(renderTerrain(e, tc, product, params), mbtiles.PNG, 0)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			ts.svc.logError("Could not encode %s tile for %v: %v", product, r.URL.Path, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", mbtiles.PNG.MimeType())
+		setCacheControl(w, ts.cacheControl.Tiles)
+		if checkNotModified(w, r, contentETag(data), ts.db.GetTimestamp()) {
+			return
+		}
+		w.Write(data)
+	}
+}
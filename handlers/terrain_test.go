@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"bytes"
+	"image/png"
+	"math"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func Test_ParseTerrainParams(t *testing.T) {
+	tests := []struct {
+		query  string
+		params terrainParams
+		err    bool
+	}{
+		{query: "", params: terrainParams{azimuth: 315, altitude: 45, exaggeration: 1}},
+		{query: "azimuth=90&altitude=30&exaggeration=2.5", params: terrainParams{azimuth: 90, altitude: 30, exaggeration: 2.5}},
+		{query: "azimuth=-1", err: true},
+		{query: "azimuth=361", err: true},
+		{query: "altitude=91", err: true},
+		{query: "altitude=NaN", err: true},
+		{query: "exaggeration=0", err: true},
+		{query: "exaggeration=101", err: true},
+		{query: "exaggeration=foo", err: true},
+	}
+
+	for _, tc := range tests {
+		values, _ := url.ParseQuery(tc.query)
+		params, err := parseTerrainParams(values)
+		if tc.err {
+			if err == nil {
+				t.Error("parseTerrainParams did not raise expected error for:", tc.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Error("parseTerrainParams raised unexpected error for:", tc.query, err)
+			continue
+		}
+		if params != tc.params {
+			t.Error("parseTerrainParams returned unexpected params for:", tc.query, params, "expected:", tc.params)
+		}
+	}
+}
+
+func Test_RenderTerrain(t *testing.T) {
+	// elevation tile of 4 x 4 pixels plus buffer, rising to the east by
+	// elevation per pixel
+	newTile := func(elevation float64) *elevationTile {
+		e := &elevationTile{width: 6, height: 6, values: make([]float64, 36)}
+		for y := 0; y < 6; y++ {
+			for x := 0; x < 6; x++ {
+				e.values[y*6+x] = float64(x) * elevation
+			}
+		}
+		return e
+	}
+	// tile along the equator, where pixels are about 1252 km wide
+	tc := tileCoord{z: 3, x: 0, y: 3}
+	res := 2 * earthCircumference / 8 / 4
+	params := terrainParams{azimuth: 315, altitude: 45, exaggeration: 1}
+
+	gray := func(e *elevationTile, product string, params terrainParams) uint8 {
+		return renderTerrain(e, tc, product, params).NRGBAAt(1, 3).R
+	}
+
+	// flat surfaces are lit by the altitude of the light source
+	if v := gray(newTile(0), "hillshade", params); v != uint8(math.Round(255*math.Cos(math.Pi/4))) {
+		t.Error("Unexpected hillshade of flat surface:", v)
+	}
+	if v := gray(newTile(0), "slope", params); v != 255 {
+		t.Error("Unexpected slope of flat surface:", v)
+	}
+
+	// slopes facing the light source are brighter
+	west := gray(newTile(res), "hillshade", terrainParams{azimuth: 270, altitude: 45, exaggeration: 1})
+	east := gray(newTile(res), "hillshade", terrainParams{azimuth: 90, altitude: 45, exaggeration: 1})
+	if west <= east {
+		t.Error("Expected slope facing west to be brighter when lit from west:", west, east)
+	}
+
+	// about 45 degrees near the equator
+	if v := gray(newTile(res), "slope", params); math.Abs(float64(v)-127.5) > 2 {
+		t.Error("Unexpected slope of 45 degree surface:", v)
+	}
+	steep := gray(newTile(res), "slope", terrainParams{azimuth: 315, altitude: 45, exaggeration: 4})
+	if steep >= gray(newTile(res), "slope", params) {
+		t.Error("Expected exaggerated slope to be darker:", steep)
+	}
+
+	// missing elevation values are transparent
+	e := newTile(0)
+	e.values[4*6+2] = math.NaN()
+	img := renderTerrain(e, tc, "hillshade", params)
+	if img.NRGBAAt(1, 3).A != 0 || img.NRGBAAt(2, 3).A != 255 {
+		t.Error("Expected only pixels with missing values to be transparent")
+	}
+}
+
+func Test_ReadElevation(t *testing.T) {
+	svc := newTestServiceSet(t, &ServiceSetConfig{EnableOverzoom: true}, "geography-class-png")
+	elevation := func(x, y int) float64 { return float64(x) * 10.5 }
+	if err := svc.AddTileset(newTestDEMFile(t, demEncodingMapbox, elevation), "dem"); err != nil {
+		t.Fatal("Could not add tileset:", err)
+	}
+	ts := svc.tilesets["dem"]
+
+	e, err := ts.readElevationWithBuffer(tileCoord{z: 1, x: 1, y: 0})
+	if err != nil || e == nil {
+		t.Fatal("Could not read elevation:", err)
+	}
+	if e.width != 258 || e.height != 258 {
+		t.Fatal("Unexpected size of elevation tile with buffer:", e.width, e.height)
+	}
+	tests := []struct {
+		x, y      int
+		elevation float64
+	}{
+		// first pixel of tile
+		{x: 1, y: 1, elevation: 2688},
+		// buffer from tile to the west
+		{x: 0, y: 1, elevation: 2677.5},
+		// buffer from tile to the east, across the antimeridian
+		{x: 257, y: 1, elevation: 0},
+		// no tile to the north, so edge values are repeated
+		{x: 1, y: 0, elevation: 2688},
+		// buffer from tile to the south
+		{x: 0, y: 257, elevation: 2677.5},
+	}
+	for _, tc := range tests {
+		if v := e.values[tc.y*e.width+tc.x]; math.Abs(v-tc.elevation) > 1e-6 {
+			t.Error("Unexpected elevation at:", tc.x, tc.y, v, "expected:", tc.elevation)
+		}
+	}
+
+	// overzoomed tiles are interpolated from elevation values of the ancestor
+	e, err = ts.readElevation(tileCoord{z: 2, x: 1, y: 1})
+	if err != nil || e == nil {
+		t.Fatal("Could not read overzoomed elevation:", err)
+	}
+	if v := e.at(100, 10); math.Abs(v-177.75*10.5) > 1e-6 {
+		t.Error("Unexpected overzoomed elevation:", v, "expected:", 177.75*10.5)
+	}
+
+	// pixel queries use the same elevation values
+	value, err := newPixelReader(ts).value(-90+360.0/1024*100.5, 60, 2)
+	if err != nil || value != 177.75*10.5 {
+		t.Error("Unexpected overzoomed pixel query elevation:", value, err)
+	}
+}
+
+func Test_TerrainHandler(t *testing.T) {
+	svc := newTestServiceSet(t, &ServiceSetConfig{EnableTerrain: true}, "geography-class-png")
+	elevation := func(x, y int) float64 { return float64(x*x) / 10 }
+	if err := svc.AddTileset(newTestDEMFile(t, demEncodingTerrarium, elevation), "dem"); err != nil {
+		t.Fatal("Could not add tileset:", err)
+	}
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{path: "/services/dem/hillshade/0/0/0.png", status: 200},
+		{path: "/services/dem/hillshade/1/1/0.png?azimuth=90&altitude=30&exaggeration=2", status: 200},
+		{path: "/services/dem/slope/1/0/1.png", status: 200},
+		{path: "/services/dem/slope/1/0/1", status: 200},
+		{path: "/services/dem/hillshade/1/0/1.jpg", status: 400},
+		{path: "/services/dem/hillshade/1/0/2.png", status: 400},
+		{path: "/services/dem/hillshade/1/0.png", status: 400},
+		{path: "/services/dem/hillshade/1/0/0.png?azimuth=400", status: 400},
+		{path: "/services/geography-class-png/hillshade/0/0/0.png", status: 400},
+	}
+
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		svc.Handler().ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+		if rec.Code != tc.status {
+			t.Error("Unexpected status code for:", tc.path, rec.Code, "expected:", tc.status)
+			continue
+		}
+		if tc.status != 200 {
+			continue
+		}
+		if contentType := rec.Header().Get("Content-Type"); contentType != "image/png" {
+			t.Error("Unexpected content type for:", tc.path, contentType)
+		}
+		img, err := png.Decode(bytes.NewReader(rec.Body.Bytes()))
+		if err != nil {
+			t.Error("Could not decode image for:", tc.path, err)
+			continue
+		}
+		if b := img.Bounds(); b.Dx() != 256 || b.Dy() != 256 {
+			t.Error("Unexpected image size for:", tc.path, b)
+		}
+	}
+
+	for _, path := range []string{"/services/dem/hillshade/1/0/0.png", "/services/dem/slope/1/0/0.png"} {
+		if id := svc.IDFromURLPath(path); id != "dem" {
+			t.Error("IDFromURLPath returned unexpected ID for:", path, id)
+		}
+	}
+
+	// disabled by default
+	svc = newTestServiceSet(t, &ServiceSetConfig{}, "geography-class-png")
+	rec := httptest.NewRecorder()
+	svc.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/services/geography-class-png/hillshade/0/0/0.png", nil))
+	if rec.Code != 404 {
+		t.Error("Expected 404 when terrain tiles are disabled, got:", rec.Code)
+	}
+}
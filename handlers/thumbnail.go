@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	mbtiles "github.com/brendan-ward/mbtiles-go"
+)
+
+// thumbnailSize is the width and height in pixels of thumbnails rendered from
+// image tilesets
+const thumbnailSize = 256
+
+// storedThumbnail returns the PNG encoded thumbnail stored in the "thumbnail"
+// metadata of a tileset as a base64 encoded PNG, JPG, or WEBP image or data
+// URI.  Images other than PNG are converted to PNG.  Returns nil if there is
+// no stored thumbnail.
+func storedThumbnail(metadata map[string]interface{}) ([]byte, error) {
+	value, _ := metadata["thumbnail"].(string)
+	if value == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(value, "data:") {
+		i := strings.Index(value, ",")
+		if i < 0 || !strings.HasSuffix(value[:i], ";base64") {
+			return nil, fmt.Errorf("thumbnail data URI must be base64 encoded")
+		}
+		value = value[i+1:]
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode thumbnail: %v", err)
+	}
+	if http.DetectContentType(data) == mbtiles.PNG.MimeType() {
+		return data, nil
+	}
+
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err = encodeImage(img, mbtiles.PNG, 0)
+	return data, err
+}
+
+// hasThumbnail returns true if a thumbnail is available for the tileset:
+// either it is stored in the metadata or the tileset is an image tileset that
+// it can be rendered from.
+func (ts *Tileset) hasThumbnail() bool {
+	return ts.hasStoredThumbnail || isImageFormat(ts.tileformat)
+}
+
+// getThumbnail returns the PNG encoded thumbnail of the tileset, which is
+// cached until the tileset is reloaded.  Returns nil if no thumbnail is
+// available.
+func (ts *Tileset) getThumbnail() ([]byte, error) {
+	ts.thumbnailMu.Lock()
+	defer ts.thumbnailMu.Unlock()
+
+	if ts.thumbnail != nil || !ts.hasThumbnail() {
+		return ts.thumbnail, nil
+	}
+
+	metadata, err := ts.db.ReadMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := storedThumbnail(metadata)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		if data, err = ts.renderThumbnail(); err != nil {
+			return nil, err
+		}
+	}
+	ts.thumbnail = data
+	return data, nil
+}
+
+// renderThumbnail renders a thumbnail from the tiles of an image tileset.  The
+// thumbnail is centered on the center of the tileset, or of its bounds if the
+// center is not available, at the zoom level that fits its bounds, within the
+// zoom levels of the tileset.
+func (ts *Tileset) renderThumbnail() ([]byte, error) {
+	xmin, ymin := lonLatToMercator(ts.bounds[0], ts.bounds[1])
+	xmax, ymax := lonLatToMercator(ts.bounds[2], ts.bounds[3])
+	x, y := lonLatToMercator(ts.center[0], ts.center[1])
+
+	// fit the bounds around the center, but not beyond the resolutions of
+	// the available zoom levels
+	tileRes := 2 * earthCircumference / float64(ts.renderTileSize())
+	res := 2 * math.Max(math.Max(x-xmin, xmax-x), math.Max(y-ymin, ymax-y)) / thumbnailSize
+	res = math.Min(res, tileRes/math.Exp2(float64(ts.minzoom)))
+	res = math.Max(res, tileRes/math.Exp2(float64(ts.availableMaxZoom())))
+
+	img, err := ts.renderMercator(viewBounds(x, y, res, thumbnailSize, thumbnailSize), thumbnailSize, thumbnailSize)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := encodeImage(img, mbtiles.PNG, 0)
+	return data, err
+}
+
+// thumbnailHandler is an http.HandlerFunc that returns the PNG thumbnail of
+// the tileset
+func (ts *Tileset) thumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	if !ts.published {
+		http.NotFound(w, r)
+		return
+	}
+
+	// wait up to 30 seconds to see if tileset is ready and return it if possible
+	if ts.isLockedWithTimeout(30 * time.Second) {
+		tilesetLockedHandler(w, r)
+		return
+	}
+
+	data, err := ts.getThumbnail()
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		ts.svc.logError("Could not create thumbnail for %v: %v", r.URL.Path, err)
+		return
+	}
+	if data == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", mbtiles.PNG.MimeType())
+	setCacheControl(w, ts.cacheControl.Preview)
+	if checkNotModified(w, r, contentETag(data), ts.db.GetTimestamp()) {
+		return
+	}
+	w.Write(data)
+}
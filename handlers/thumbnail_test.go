@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_StoredThumbnail(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 1, color.RGBA{255, 0, 0, 255})
+	var pngData, jpgData bytes.Buffer
+	png.Encode(&pngData, img)
+	jpeg.Encode(&jpgData, img, nil)
+
+	tests := []struct {
+		value string
+		isNil bool
+		err   bool
+	}{
+		{value: "", isNil: true},
+		{value: base64.StdEncoding.EncodeToString(pngData.Bytes())},
+		{value: "data:image/png;base64," + base64.StdEncoding.EncodeToString(pngData.Bytes())},
+		{value: "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(jpgData.Bytes())},
+		{value: "data:image/png," + base64.StdEncoding.EncodeToString(pngData.Bytes()), err: true},
+		{value: "not base64!", err: true},
+		{value: base64.StdEncoding.EncodeToString([]byte("foo")), err: true},
+	}
+
+	for _, tc := range tests {
+		metadata := map[string]interface{}{}
+		if tc.value != "" {
+			metadata["thumbnail"] = tc.value
+		}
+		data, err := storedThumbnail(metadata)
+		if tc.err {
+			if err == nil {
+				t.Error("storedThumbnail did not raise expected error for:", tc.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Error("storedThumbnail raised unexpected error for:", tc.value, err)
+			continue
+		}
+		if tc.isNil {
+			if data != nil {
+				t.Error("storedThumbnail returned unexpected data for:", tc.value)
+			}
+			continue
+		}
+		if cfg, format, err := image.DecodeConfig(bytes.NewReader(data)); err != nil || format != "png" || cfg.Width != 4 {
+			t.Error("storedThumbnail returned unexpected image for:", tc.value, format, err)
+		}
+	}
+}
+
+func Test_ThumbnailHandler(t *testing.T) {
+	svc := newTestServiceSet(t, &ServiceSetConfig{EnableServiceList: true, EnableThumbnails: true}, "geography-class-png", "world_cities")
+	handler := svc.Handler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/services/geography-class-png/thumbnail.png", nil))
+	if w.Code != 200 || w.Header().Get("Content-Type") != "image/png" {
+		t.Fatal("Unexpected response for thumbnail:", w.Code, w.Header().Get("Content-Type"))
+	}
+	cfg, _, err := image.DecodeConfig(w.Body)
+	if err != nil || cfg.Width != thumbnailSize || cfg.Height != thumbnailSize {
+		t.Error("Unexpected thumbnail image:", cfg.Width, cfg.Height, err)
+	}
+
+	// thumbnail is cached until the tileset is reloaded
+	ts := svc.tilesets["geography-class-png"]
+	if ts.thumbnail == nil {
+		t.Error("Thumbnail was not cached")
+	}
+	if err = svc.UpdateTileset("geography-class-png"); err != nil {
+		t.Fatal("Could not update tileset:", err)
+	}
+	if ts.thumbnail != nil {
+		t.Error("Thumbnail was not cleared on reload")
+	}
+
+	// vector tilesets do not have a thumbnail unless stored in metadata
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/services/world_cities/thumbnail.png", nil))
+	if w.Code != 404 {
+		t.Error("Unexpected status code for vector thumbnail:", w.Code, "expected:", 404)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/services", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, `"thumbnail":"http://example.com/services/geography-class-png/thumbnail.png"`) {
+		t.Error("Service list does not contain thumbnail URL:", body)
+	}
+	if strings.Contains(body, "world_cities/thumbnail.png") {
+		t.Error("Service list contains unexpected thumbnail URL for vector tileset:", body)
+	}
+
+	if id := svc.IDFromURLPath("/services/geography-class-png/thumbnail.png"); id != "geography-class-png" {
+		t.Error("IDFromURLPath returned unexpected ID for thumbnail:", id)
+	}
+}
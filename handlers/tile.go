@@ -12,6 +12,10 @@ const (
 	earthCircumference       = math.Pi * earthRadius
 	initialResolution        = 2 * earthCircumference / 256
 	dpi                uint8 = 96
+
+	// maxMercatorLatitude is the latitude of the top edge of the web mercator
+	// tile grid
+	maxMercatorLatitude = 85.0511287798066
 )
 
 type tileCoord struct {
@@ -20,8 +24,8 @@ type tileCoord struct {
 
 // tileCoordFromString parses and returns tileCoord coordinates and an optional
 // extension from the three parameters. The parameter z is interpreted as the
-// web mercator zoom level, it is supposed to be an unsigned integer that will
-// fit into 8 bit. The parameters x and y are interpreted as longitude and
+// web mercator zoom level, it is supposed to be an integer in the interval
+// [0,maxZoomLevel]. The parameters x and y are interpreted as longitude and
 // latitude tile indices for that zoom level, both are supposed be integers in
 // the integer interval [0,2^z). Additionally, y may also have an optional
 // filename extension (e.g. "42.png") which is removed before parsing the
@@ -32,6 +36,10 @@ func tileCoordFromString(z, x, y string) (tc tileCoord, ext string, err error) {
 		err = fmt.Errorf("cannot parse zoom level: %v", err)
 		return
 	}
+	if tc.z < 0 || tc.z > maxZoomLevel {
+		err = fmt.Errorf("zoom level (%d) is out of bounds", tc.z)
+		return
+	}
 	const (
 		errMsgParse = "cannot parse %s coordinate axis: %v"
 		errMsgOOB   = "%s coordinate (%d) is out of bounds for zoom level %d"
@@ -40,7 +48,7 @@ func tileCoordFromString(z, x, y string) (tc tileCoord, ext string, err error) {
 		err = fmt.Errorf(errMsgParse, "x", err)
 		return
 	}
-	if tc.x >= (1 << tc.z) {
+	if tc.x < 0 || tc.x >= (1<<tc.z) {
 		err = fmt.Errorf(errMsgOOB, "x", tc.x, tc.z)
 		return
 	}
@@ -52,13 +60,88 @@ func tileCoordFromString(z, x, y string) (tc tileCoord, ext string, err error) {
 		err = fmt.Errorf(errMsgParse, "y", err)
 		return
 	}
-	if tc.y >= (1 << tc.z) {
+	if tc.y < 0 || tc.y >= (1<<tc.z) {
 		err = fmt.Errorf(errMsgOOB, "y", tc.y, tc.z)
 		return
 	}
 	return
 }
 
+// tileScaleFromString removes an optional scale factor suffix (e.g., "@2x")
+// from y, which may also include a filename extension (e.g., "42@2x.png").
+// Returns y without the suffix and the scale factor, which is 0 if no suffix
+// was present.  Only scale factors of 1 and 2 are supported.
+func tileScaleFromString(y string) (string, int, error) {
+	i := strings.Index(y, "@")
+	if i < 0 {
+		return y, 0, nil
+	}
+	suffix, ext := y[i+1:], ""
+	if l := strings.LastIndex(suffix, "."); l >= 0 {
+		suffix, ext = suffix[:l], suffix[l:]
+	}
+	switch suffix {
+	case "1x":
+		return y[:i] + ext, 1, nil
+	case "2x":
+		return y[:i] + ext, 2, nil
+	}
+	return y, 0, fmt.Errorf("unsupported tile scale: %q", suffix)
+}
+
+// tileRangeForBounds returns the range of XYZ tile columns and rows at zoom
+// level z that intersect bounds (xmin, ymin, xmax, ymax in longitude and
+// latitude).
+func tileRangeForBounds(bounds []float64, z int) (minX, minY, maxX, maxY int64) {
+	n := int64(1) << uint(z)
+	clamp := func(v float64) int64 {
+		return int64(math.Max(math.Min(v, float64(n-1)), 0))
+	}
+
+	x0, y0 := lonLatToTile(bounds[0], bounds[3], z)
+	x1, y1 := lonLatToTile(bounds[2], bounds[1], z)
+	minX, minY = clamp(math.Floor(x0)), clamp(math.Floor(y0))
+	// tiles that only touch the right or bottom edge of bounds are excluded
+	maxX, maxY = clamp(math.Ceil(x1)-1), clamp(math.Ceil(y1)-1)
+	if maxX < minX {
+		maxX = minX
+	}
+	if maxY < minY {
+		maxY = minY
+	}
+	return minX, minY, maxX, maxY
+}
+
+// lonLatToMercator returns web mercator coordinates for a longitude and
+// latitude, clamped to the extent of the web mercator tile grid.
+func lonLatToMercator(lon, lat float64) (float64, float64) {
+	lat = math.Max(math.Min(lat, maxMercatorLatitude), -maxMercatorLatitude)
+	x := lon * earthCircumference / 180
+	y := math.Log(math.Tan((90+lat)*math.Pi/360)) * earthRadius
+	return x, y
+}
+
+// lonLatToTile returns the fractional XYZ tile column and row at zoom level z
+// for a longitude and latitude.
+func lonLatToTile(lon, lat float64, z int) (float64, float64) {
+	n := math.Exp2(float64(z))
+	lat = math.Max(math.Min(lat, maxMercatorLatitude), -maxMercatorLatitude)
+	sin := math.Sin(lat * math.Pi / 180)
+	x := (lon + 180) / 360 * n
+	y := (0.5 - math.Log((1+sin)/(1-sin))/(4*math.Pi)) * n
+	return x, y
+}
+
+// tileToLonLat returns the longitude and latitude of the upper left corner of
+// the fractional XYZ tile column and row at zoom level z; this is the inverse
+// of lonLatToTile.
+func tileToLonLat(x, y float64, z int) (float64, float64) {
+	n := math.Exp2(float64(z))
+	lon := x/n*360 - 180
+	lat := math.Atan(math.Sinh(math.Pi*(1-2*y/n))) * 180 / math.Pi
+	return lon, lat
+}
+
 func calcScaleResolution(zoomLevel int, dpi uint8) (float64, float64) {
 	var denom = 1 << zoomLevel
 	resolution := initialResolution / float64(denom)
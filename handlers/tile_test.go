@@ -16,6 +16,7 @@ func Test_TileCoordFromString(t *testing.T) {
 	}{
 		{path: "0/0/0", ext: "", z: 0, x: 0, y: 0},
 		{path: "10/1/2", ext: "", z: 10, x: 1, y: 2},
+		{path: "30/1073741823/0", ext: "", z: 30, x: 1073741823, y: 0},
 		{path: "0/0/0.png", ext: ".png", z: 0, x: 0, y: 0},
 		{path: "0/0/0.jpg", ext: ".jpg", z: 0, x: 0, y: 0},
 		{path: "0/0/0.webp", ext: ".webp", z: 0, x: 0, y: 0},
@@ -64,6 +65,10 @@ func Test_TileCoordFromString_Invalid(t *testing.T) {
 		{path: "0/0/0.foo.bar", err: "cannot parse y coordinate"},
 		{path: "a/0/0", err: "cannot parse zoom level"},
 		{path: "0/a/0", err: "cannot parse x coordinate"},
+		{path: "-1/0/0", err: "zoom level (-1) is out of bounds"},
+		{path: "31/0/0", err: "zoom level (31) is out of bounds"},
+		{path: "1/-1/0", err: "x coordinate (-1) is out of bounds"},
+		{path: "1/0/-1.png", err: "y coordinate (-1) is out of bounds"},
 	}
 
 	for _, tc := range tests {
@@ -84,6 +89,68 @@ func Test_TileCoordFromString_Invalid(t *testing.T) {
 	}
 }
 
+func Test_TileScaleFromString(t *testing.T) {
+	tests := []struct {
+		y     string
+		out   string
+		scale int
+		err   bool
+	}{
+		{y: "0", out: "0", scale: 0},
+		{y: "0.png", out: "0.png", scale: 0},
+		{y: "0@2x", out: "0", scale: 2},
+		{y: "0@2x.png", out: "0.png", scale: 2},
+		{y: "12@1x.jpg", out: "12.jpg", scale: 1},
+		{y: "0@3x.png", err: true},
+		{y: "0@foo", err: true},
+	}
+
+	for _, tc := range tests {
+		out, scale, err := tileScaleFromString(tc.y)
+		if tc.err {
+			if err == nil {
+				t.Error("tileScaleFromString did not raise expected error for:", tc.y)
+			}
+			continue
+		}
+		if err != nil {
+			t.Error("tileScaleFromString raised unexpected error for:", tc.y, err)
+			continue
+		}
+		if out != tc.out {
+			t.Error("tileScaleFromString returned unexpected value:", out, "expected:", tc.out)
+			continue
+		}
+		if scale != tc.scale {
+			t.Error("tileScaleFromString returned unexpected scale:", scale, "expected:", tc.scale)
+			continue
+		}
+	}
+}
+
+func Test_TileRangeForBounds(t *testing.T) {
+	tests := []struct {
+		bounds                 []float64
+		z                      int
+		minX, minY, maxX, maxY int64
+	}{
+		{bounds: []float64{-180, -85.0511, 180, 85.0511}, z: 0, minX: 0, minY: 0, maxX: 0, maxY: 0},
+		{bounds: []float64{-180, -85.0511, 180, 85.0511}, z: 2, minX: 0, minY: 0, maxX: 3, maxY: 3},
+		// northeast quadrant only touches the other quadrants at zoom 1
+		{bounds: []float64{0, 0, 180, 85.0511}, z: 1, minX: 1, minY: 0, maxX: 1, maxY: 0},
+		{bounds: []float64{-123.12, 25.5, -66.9, 49.4}, z: 4, minX: 2, minY: 5, maxX: 5, maxY: 6},
+		{bounds: []float64{-73.98, 40.75, -73.98, 40.75}, z: 10, minX: 301, minY: 384, maxX: 301, maxY: 384},
+	}
+
+	for _, tc := range tests {
+		minX, minY, maxX, maxY := tileRangeForBounds(tc.bounds, tc.z)
+		if minX != tc.minX || minY != tc.minY || maxX != tc.maxX || maxY != tc.maxY {
+			t.Error("tileRangeForBounds returned unexpected range for:", tc.bounds, tc.z, ":", minX, minY, maxX, maxY,
+				"expected:", tc.minX, tc.minY, tc.maxX, tc.maxY)
+		}
+	}
+}
+
 func Test_CalcScaleResolution(t *testing.T) {
 	zoom0resolution := (2 * earthCircumference / 256) / (1 << 0)
 	zoom2resolution := (2 * earthCircumference / 256) / (1 << 2)
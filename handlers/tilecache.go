@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"container/list"
+	"sync"
+)
+
+// tileCacheEntryOverhead is the approximate memory used by each cache entry
+// in addition to its tile data.  This also limits the number of missing tiles
+// (which have no data) that can be cached.
+const tileCacheEntryOverhead = 64
+
+// TileCacheStats provides the number of tile reads for a tileset that were
+// served from the tile cache (Hits) or read from the mbtiles file (Misses).
+// Concurrent reads of the same tile that wait for a single read from the
+// mbtiles file are counted as hits.
+type TileCacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+type tileCacheKey struct {
+	id string
+	tc tileCoord
+}
+
+type tileCacheEntry struct {
+	key  tileCacheKey
+	data []byte
+}
+
+// tileCacheCall is an in-progress read of a tile that is not in the cache.
+// Concurrent reads of the same tile wait for it to complete instead of
+// reading the tile again.
+type tileCacheCall struct {
+	wg   sync.WaitGroup
+	data []byte
+	err  error
+}
+
+// tileCache is a least recently used cache of tile data shared by all
+// tilesets in a ServiceSet.  The total size of the cached tiles is limited to
+// maxBytes.  Missing tiles are cached as entries without data.
+type tileCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	ll       *list.List
+	entries  map[tileCacheKey]*list.Element
+	calls    map[tileCacheKey]*tileCacheCall
+
+	// generations are incremented when a tileset is invalidated, so that
+	// reads started before then are not added to the cache
+	generations map[string]uint64
+	stats       map[string]*TileCacheStats
+}
+
+// newTileCache returns a new tileCache limited to maxBytes, or nil if
+// maxBytes is not greater than 0.
+func newTileCache(maxBytes int64) *tileCache {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return &tileCache{
+		maxBytes:    maxBytes,
+		ll:          list.New(),
+		entries:     make(map[tileCacheKey]*list.Element),
+		calls:       make(map[tileCacheKey]*tileCacheCall),
+		generations: make(map[string]uint64),
+		stats:       make(map[string]*TileCacheStats),
+	}
+}
+
+// get returns the cached tile data for tile coordinate tc of tileset id.
+// If the tile is not cached, it is read using read and added to the cache.
+// Only one read is performed for concurrent calls for the same tile.
+// The returned data is shared and must not be modified.
+func (c *tileCache) get(id string, tc tileCoord, read func() ([]byte, error)) ([]byte, error) {
+	key := tileCacheKey{id: id, tc: tc}
+
+	c.mu.Lock()
+	stats := c.statsFor(id)
+	if el, ok := c.entries[key]; ok {
+		c.ll.MoveToFront(el)
+		stats.Hits++
+		c.mu.Unlock()
+		return el.Value.(*tileCacheEntry).data, nil
+	}
+	if call, ok := c.calls[key]; ok {
+		stats.Hits++
+		c.mu.Unlock()
+		call.wg.Wait()
+		return call.data, call.err
+	}
+	stats.Misses++
+	call := &tileCacheCall{}
+	call.wg.Add(1)
+	c.calls[key] = call
+	generation := c.generations[id]
+	c.mu.Unlock()
+
+	call.data, call.err = read()
+	call.wg.Done()
+
+	c.mu.Lock()
+	delete(c.calls, key)
+	if call.err == nil && c.generations[id] == generation {
+		c.add(key, call.data)
+	}
+	c.mu.Unlock()
+
+	return call.data, call.err
+}
+
+// add adds data to the cache and evicts the least recently used entries to
+// stay within maxBytes.  Must be called with c.mu held.
+func (c *tileCache) add(key tileCacheKey, data []byte) {
+	size := int64(len(data)) + tileCacheEntryOverhead
+	if size > c.maxBytes {
+		return
+	}
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.entries[key] = c.ll.PushFront(&tileCacheEntry{key: key, data: data})
+	c.size += size
+	for c.size > c.maxBytes {
+		c.remove(c.ll.Back())
+	}
+}
+
+// remove removes an entry from the cache.  Must be called with c.mu held.
+func (c *tileCache) remove(el *list.Element) {
+	entry := c.ll.Remove(el).(*tileCacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.data)) + tileCacheEntryOverhead
+}
+
+// invalidate removes all cached tiles for tileset id
+func (c *tileCache) invalidate(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generations[id]++
+	for el := c.ll.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*tileCacheEntry).key.id == id {
+			c.remove(el)
+		}
+		el = next
+	}
+}
+
+// statsFor returns the stats for tileset id.  Must be called with c.mu held.
+func (c *tileCache) statsFor(id string) *TileCacheStats {
+	stats, ok := c.stats[id]
+	if !ok {
+		stats = &TileCacheStats{}
+		c.stats[id] = stats
+	}
+	return stats
+}
+
+// getStats returns a copy of the stats for tileset id
+func (c *tileCache) getStats(id string) TileCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if stats, ok := c.stats[id]; ok {
+		return *stats
+	}
+	return TileCacheStats{}
+}
+
+// removeStats removes the stats for tileset id
+func (c *tileCache) removeStats(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.stats, id)
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func Test_TileCache_Evict(t *testing.T) {
+	tileSize := 100
+	// room for 3 tiles
+	c := newTileCache(int64(3 * (tileSize + tileCacheEntryOverhead)))
+
+	reads := 0
+	read := func() ([]byte, error) {
+		reads++
+		return bytes.Repeat([]byte{1}, tileSize), nil
+	}
+
+	for x := int64(0); x < 4; x++ {
+		c.get("foo", tileCoord{z: 2, x: x, y: 0}, read)
+	}
+	if reads != 4 {
+		t.Error("tileCache performed unexpected number of reads:", reads, "expected: 4")
+	}
+
+	// tile 0 was least recently used and should have been evicted
+	tests := []struct {
+		x     int64
+		reads int
+	}{
+		{x: 3, reads: 4},
+		{x: 1, reads: 4},
+		{x: 0, reads: 5},
+	}
+	for _, tc := range tests {
+		c.get("foo", tileCoord{z: 2, x: tc.x, y: 0}, read)
+		if reads != tc.reads {
+			t.Error("tileCache performed unexpected number of reads for tile:", tc.x, reads, "expected:", tc.reads)
+		}
+	}
+
+	if c.size > c.maxBytes {
+		t.Error("tileCache exceeded max size:", c.size, "max:", c.maxBytes)
+	}
+
+	stats := c.getStats("foo")
+	if stats.Hits != 2 || stats.Misses != 5 {
+		t.Error("tileCache returned unexpected stats:", stats)
+	}
+}
+
+func Test_TileCache_Invalidate(t *testing.T) {
+	c := newTileCache(1 << 20)
+
+	reads := 0
+	read := func() ([]byte, error) {
+		reads++
+		return nil, nil
+	}
+
+	tc := tileCoord{z: 0, x: 0, y: 0}
+	c.get("foo", tc, read)
+	c.get("bar", tc, read)
+	c.get("foo", tc, read)
+	if reads != 2 {
+		t.Error("tileCache performed unexpected number of reads:", reads, "expected: 2")
+	}
+
+	c.invalidate("foo")
+	c.get("foo", tc, read)
+	c.get("bar", tc, read)
+	if reads != 3 {
+		t.Error("tileCache performed unexpected number of reads after invalidate:", reads, "expected: 3")
+	}
+}
+
+func Test_TileCache_Coalesce(t *testing.T) {
+	c := newTileCache(1 << 20)
+
+	var reads int32
+	release := make(chan struct{})
+	read := func() ([]byte, error) {
+		atomic.AddInt32(&reads, 1)
+		<-release
+		return []byte("tile"), nil
+	}
+
+	tc := tileCoord{z: 0, x: 0, y: 0}
+	n := 10
+	var done sync.WaitGroup
+	done.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer done.Done()
+			data, err := c.get("foo", tc, read)
+			if err != nil || string(data) != "tile" {
+				t.Error("tileCache returned unexpected data:", data, err)
+			}
+		}()
+	}
+	// wait until all goroutines are counted as a hit or miss before
+	// completing the read
+	for {
+		stats := c.getStats("foo")
+		if stats.Hits+stats.Misses == uint64(n) {
+			break
+		}
+	}
+	close(release)
+	done.Wait()
+
+	if reads != 1 {
+		t.Error("tileCache performed unexpected number of reads:", reads, "expected: 1")
+	}
+}
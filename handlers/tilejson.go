@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Supported TileJSON versions
+const (
+	tileJSONVersion2 = "2.1.0"
+	tileJSONVersion3 = "3.0.0"
+)
+
+// validTileJSONVersion returns true if version is a supported TileJSON
+// version
+func validTileJSONVersion(version string) bool {
+	return version == tileJSONVersion2 || version == tileJSONVersion3
+}
+
+// validateTileJSONQuery returns an error if the query parameters of a request
+// for TileJSON, or for a style or preview based on it, are invalid: the
+// tilejson version, and the layers and filter of vector tiles, which are
+// passed through to the tile URLs.
+func validateTileJSONQuery(query url.Values) error {
+	if v := query.Get("tilejson"); v != "" && !validTileJSONVersion(v) {
+		return fmt.Errorf("tilejson must be %s or %s", tileJSONVersion2, tileJSONVersion3)
+	}
+	_, err := parseTileFilter(query)
+	return err
+}
+
+// tileJSONVectorLayers returns the vector_layers of TileJSON 3.0.0 from the
+// vector_layers of the mbtiles "json" metadata.  Layers without an id are
+// omitted, and fields default to an empty object as required by the spec.
+func tileJSONVectorLayers(value interface{}) []map[string]interface{} {
+	layers := []map[string]interface{}{}
+	items, _ := value.([]interface{})
+	for _, item := range items {
+		layer, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id, ok := layer["id"].(string)
+		if !ok || id == "" {
+			continue
+		}
+
+		fields := map[string]string{}
+		if values, ok := layer["fields"].(map[string]interface{}); ok {
+			for name, v := range values {
+				if s, ok := v.(string); ok {
+					fields[name] = s
+				}
+			}
+		}
+
+		out := map[string]interface{}{
+			"id":     id,
+			"fields": fields,
+		}
+		if description, ok := layer["description"].(string); ok {
+			out["description"] = sanitizeHTML(description)
+		}
+		for _, key := range []string{"minzoom", "maxzoom"} {
+			if z, ok := layer[key].(float64); ok {
+				out[key] = int(z)
+			}
+		}
+		layers = append(layers, out)
+	}
+	return layers
+}
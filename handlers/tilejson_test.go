@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func Test_TileJSON(t *testing.T) {
+	enabled := true
+	svc := newTestServiceSet(t, &ServiceSetConfig{
+		EnableTileJSON: true,
+		Tilesets:       map[string]TilesetConfig{"world_cities": {Overzoom: &enabled, OverzoomMaxZoom: 8}},
+	}, "geography-class-png", "world_cities")
+
+	tests := []struct {
+		id       string
+		query    string
+		version  string
+		expected map[string]interface{}
+		missing  []string
+	}{
+		{
+			id:      "geography-class-png",
+			version: "2.1.0",
+			expected: map[string]interface{}{
+				"bounds": []interface{}{-180.0, -85.0511, 180.0, 85.0511},
+				"center": []interface{}{0.0, 20.0, 0.0},
+			},
+			missing: []string{"fillzoom", "vector_layers"},
+		},
+		{
+			id:      "geography-class-png",
+			query:   "?tilejson=3.0.0",
+			version: "3.0.0",
+			expected: map[string]interface{}{
+				"tiles":   []interface{}{"http://example.com/services/geography-class-png/tiles/{z}/{x}/{y}.png?tilejson=3.0.0"},
+				"minzoom": 0.0,
+				"maxzoom": 1.0,
+			},
+			missing: []string{"fillzoom", "vector_layers"},
+		},
+		{
+			id:      "world_cities",
+			version: "2.1.0",
+			expected: map[string]interface{}{
+				"maxzoom": 8.0,
+				"center":  []interface{}{-75.9375, 38.788894, 6.0},
+			},
+			missing: []string{"fillzoom"},
+		},
+		{
+			id:      "world_cities",
+			query:   "?tilejson=3.0.0",
+			version: "3.0.0",
+			expected: map[string]interface{}{
+				"maxzoom":  8.0,
+				"fillzoom": 6.0,
+				"vector_layers": []interface{}{map[string]interface{}{
+					"id":          "cities",
+					"description": "",
+					"minzoom":     0.0,
+					"maxzoom":     6.0,
+					"fields":      map[string]interface{}{"name": "String"},
+				}},
+			},
+		},
+	}
+
+	handler := svc.Handler()
+	for _, tc := range tests {
+		path := "/services/" + tc.id + tc.query
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != 200 {
+			t.Error("Unexpected status code for:", path, rec.Code)
+			continue
+		}
+
+		var out map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Error("Could not parse TileJSON for:", path, err)
+			continue
+		}
+		if out["tilejson"] != tc.version {
+			t.Error("Unexpected TileJSON version for:", path, out["tilejson"], "expected:", tc.version)
+		}
+		for k, v := range tc.expected {
+			if !reflect.DeepEqual(out[k], v) {
+				t.Errorf("Unexpected %s for %s: %v, expected: %v", k, path, out[k], v)
+			}
+		}
+		for _, k := range tc.missing {
+			if _, ok := out[k]; ok {
+				t.Errorf("Unexpected %s for %s: %v", k, path, out[k])
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/services/world_cities?tilejson=1.0.0", nil))
+	if rec.Code != 400 {
+		t.Error("Expected 400 for unsupported TileJSON version, got:", rec.Code)
+	}
+}
+
+func Test_OverzoomMaxZoomConfig(t *testing.T) {
+	// tiles above the maximum zoom level cannot be requested, so they are
+	// not advertised in the TileJSON
+	if _, err := New(&ServiceSetConfig{EnableOverzoom: true, OverzoomMaxZoom: 40}); err == nil {
+		t.Error("Expected error for overzoom max zoom above the maximum zoom level")
+	}
+	if _, err := New(&ServiceSetConfig{Tilesets: map[string]TilesetConfig{"world_cities": {OverzoomMaxZoom: 31}}}); err == nil {
+		t.Error("Expected error for tileset overzoom max zoom above the maximum zoom level")
+	}
+}
+
+func Test_TileJSONVersionConfig(t *testing.T) {
+	if _, err := New(&ServiceSetConfig{TileJSONVersion: "1.0.0"}); err == nil {
+		t.Error("Expected error for unsupported TileJSON version")
+	}
+
+	svc := newTestServiceSet(t, &ServiceSetConfig{EnableTileJSON: true, TileJSONVersion: "3.0.0"}, "world_cities")
+	tileJSON, err := svc.tilesets["world_cities"].TileJSON("http://example.com/services/world_cities", "")
+	if err != nil {
+		t.Fatal("Could not create TileJSON:", err)
+	}
+	if tileJSON["tilejson"] != "3.0.0" {
+		t.Error("Unexpected TileJSON version:", tileJSON["tilejson"], "expected: 3.0.0")
+	}
+	if _, ok := tileJSON["vector_layers"]; !ok {
+		t.Error("Expected vector_layers in TileJSON 3.0.0")
+	}
+
+	tileJSON, err = svc.tilesets["world_cities"].TileJSON("http://example.com/services/world_cities", "?tilejson=2.1.0")
+	if err != nil {
+		t.Fatal("Could not create TileJSON:", err)
+	}
+	if tileJSON["tilejson"] != "2.1.0" {
+		t.Error("Unexpected TileJSON version:", tileJSON["tilejson"], "expected: 2.1.0")
+	}
+}
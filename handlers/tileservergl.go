@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TileServerGLDataRoot is the root URL of tilesets using the URL layout of
+// TileServer GL
+const TileServerGLDataRoot = "/data"
+
+// TileServerGLPaths lists the URL paths served by TileServerGLHandler; paths
+// ending in "/" include all paths below them.
+var TileServerGLPaths = []string{
+	"/index.json",
+	"/data.json",
+	"/styles.json",
+	TileServerGLDataRoot + "/",
+	StylesRoot + "/",
+}
+
+// tileServerGLStyleInfo describes a style in the style list of TileServer GL
+type tileServerGLStyleInfo struct {
+	Version int    `json:"version"`
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+}
+
+// writeJSON writes value as JSON with the Cache-Control value and
+// Last-Modified time, or returns HTTP 304 if the client has the current
+// version.
+func (s *ServiceSet) writeJSON(w http.ResponseWriter, r *http.Request, value interface{}, cacheControl string, modTime time.Time) {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.logError("Could not render JSON for %v: %v", r.URL.Path, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	setCacheControl(w, cacheControl)
+	if checkNotModified(w, r, contentETag(bytes), modTime) {
+		return
+	}
+	w.Write(bytes)
+}
+
+// tileServerGLTileJSON returns the TileJSON of the tileset with tile URLs
+// that use the URL layout of TileServer GL
+func (ts *Tileset) tileServerGLTileJSON(baseURL string, query string) (map[string]interface{}, error) {
+	tileJSON, err := ts.TileJSON(baseURL+TileServerGLDataRoot+"/"+ts.id, query)
+	if err != nil {
+		return nil, err
+	}
+	tileJSON["id"] = ts.id
+	tileJSON["tiles"] = []string{fmt.Sprintf("%s%s/%s/{z}/{x}/{y}.%s%s", baseURL, TileServerGLDataRoot, ts.id, ts.tileFormatString(), query)}
+	return tileJSON, nil
+}
+
+// publishedTilesets returns the published tilesets sorted by ID
+func (s *ServiceSet) publishedTilesets() []*Tileset {
+	var ids []string
+	for id, ts := range s.tilesets {
+		if ts.published {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	tilesets := make([]*Tileset, 0, len(ids))
+	for _, id := range ids {
+		tilesets = append(tilesets, s.tilesets[id])
+	}
+	return tilesets
+}
+
+// TileServerGLHandler returns an http.Handler that serves the tilesets and
+// styles of the ServiceSet using the URL layout of TileServer GL, so that
+// clients written for TileServer GL can use this server without changes:
+//
+//	/index.json, /data.json: list of the TileJSON of each tileset
+//	/data/<id>.json: TileJSON of a tileset
+//	/data/<id>/<z>/<x>/<y>.<ext>: tiles of a tileset
+//	/styles.json: list of styles
+//	/styles/<id>/style.json: style from the styles directory, or generated
+//	style of a tileset
+//
+// Sprites are served from the styles directory as for ServiceSet.Handler.
+// It must be served alongside ServiceSet.Handler, which serves the fonts
+// used by styles, at the paths in TileServerGLPaths.  It returns HTTP 404 for
+// all requests unless ServiceSetConfig.EnableTileServerGL is true.
+func (s *ServiceSet) TileServerGLHandler() http.Handler {
+	m := http.NewServeMux()
+	if !s.enableTileServerGL {
+		m.Handle("/", http.NotFoundHandler())
+		return m
+	}
+
+	m.HandleFunc("/index.json", s.tileServerGLDataListHandler)
+	m.HandleFunc("/data.json", s.tileServerGLDataListHandler)
+	m.HandleFunc("/styles.json", s.tileServerGLStyleListHandler)
+	m.HandleFunc(TileServerGLDataRoot+"/", s.tileServerGLDataHandler)
+	m.HandleFunc(StylesRoot+"/", s.tileServerGLStyleHandler)
+	return m
+}
+
+// tileServerGLDataListHandler is an http.HandlerFunc that returns the list of
+// the TileJSON of each tileset
+func (s *ServiceSet) tileServerGLDataListHandler(w http.ResponseWriter, r *http.Request) {
+	baseURL := fmt.Sprintf("%s://%s", scheme(r), getRequestHost(r))
+	list := []map[string]interface{}{}
+	var modTime time.Time
+	for _, ts := range s.publishedTilesets() {
+		if ts.isLockedWithTimeout(30 * time.Second) {
+			continue
+		}
+		tileJSON, err := ts.tileServerGLTileJSON(baseURL, "")
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			s.logError("Could not create TileJSON for %v: %v", ts.id, err)
+			return
+		}
+		list = append(list, tileJSON)
+		if ts.db.GetTimestamp().After(modTime) {
+			modTime = ts.db.GetTimestamp()
+		}
+	}
+	s.writeJSON(w, r, list, s.cacheControl.ServiceList, modTime)
+}
+
+// tileServerGLStyleListHandler is an http.HandlerFunc that returns the list
+// of styles from the styles directory and the generated style of each
+// tileset that does not have the same ID as a style in the styles directory
+func (s *ServiceSet) tileServerGLStyleListHandler(w http.ResponseWriter, r *http.Request) {
+	baseURL := fmt.Sprintf("%s://%s", scheme(r), getRequestHost(r))
+	list := []tileServerGLStyleInfo{}
+	styleIDs := make(map[string]bool)
+
+	if s.stylesDir != "" {
+		styles, err := s.listStyles(baseURL)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			s.logError("Could not list styles: %v", err)
+			return
+		}
+		for _, style := range styles {
+			list = append(list, tileServerGLStyleInfo{Version: 8, ID: style.ID, Name: style.Name, URL: style.URL})
+			styleIDs[style.ID] = true
+		}
+	}
+
+	for _, ts := range s.publishedTilesets() {
+		if styleIDs[ts.id] {
+			continue
+		}
+		list = append(list, tileServerGLStyleInfo{
+			Version: 8,
+			ID:      ts.id,
+			Name:    ts.name,
+			URL:     fmt.Sprintf("%s%s/%s/%s", baseURL, StylesRoot, ts.id, styleFilename),
+		})
+	}
+	s.writeJSON(w, r, list, s.cacheControl.ServiceList, time.Time{})
+}
+
+// tileServerGLDataHandler is an http.HandlerFunc that returns the TileJSON of
+// a tileset at /data/<id>.json or its tiles at /data/<id>/<z>/<x>/<y>.<ext>
+func (s *ServiceSet) tileServerGLDataHandler(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, TileServerGLDataRoot+"/")
+
+	if ts, ok := s.tilesets[strings.TrimSuffix(p, ".json")]; ok && strings.HasSuffix(p, ".json") {
+		if !ts.published {
+			http.NotFound(w, r)
+			return
+		}
+		// wait up to 30 seconds to see if tileset is ready and return it if possible
+		if ts.isLockedWithTimeout(30 * time.Second) {
+			tilesetLockedHandler(w, r)
+			return
+		}
+		if err := validateTileJSONQuery(r.URL.Query()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		query := ""
+		if r.URL.RawQuery != "" {
+			query = "?" + r.URL.RawQuery
+		}
+		tileJSON, err := ts.tileServerGLTileJSON(fmt.Sprintf("%s://%s", scheme(r), getRequestHost(r)), query)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			s.logError("Could not create TileJSON for %v: %v", r.URL.Path, err)
+			return
+		}
+		s.writeJSON(w, r, tileJSON, ts.cacheControl.TileJSON, ts.db.GetTimestamp())
+		return
+	}
+
+	// split <id>/<z>/<x>/<y>.<ext>; the ID may contain slashes
+	pcs := strings.Split(p, "/")
+	l := len(pcs)
+	if l < 4 {
+		http.NotFound(w, r)
+		return
+	}
+	ts, ok := s.tilesets[strings.Join(pcs[:l-3], "/")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !ts.published {
+		tileNotFoundHandler(w, r, ts.tileformat, ts.tilesize, s.returnMissingImageTile404)
+		return
+	}
+	// wait up to 30 seconds to see if tileset is ready and return it if possible
+	if ts.isLockedWithTimeout(30 * time.Second) {
+		tilesetLockedHandler(w, r)
+		return
+	}
+
+	tc, ext, size, err := parseTileRequest(r, pcs[l-3], pcs[l-2], pcs[l-1])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ts.writeTile(w, r, tc, ext, size)
+}
+
+// tileServerGLStyleHandler is an http.HandlerFunc that returns a style at
+// /styles/<id>/style.json, from the styles directory if available or
+// otherwise generated for the tileset with ID.  Sources of styles use the
+// TileJSON URLs of TileServer GL.  Other requests, such as for sprites, are
+// handled the same as for ServiceSet.Handler.
+func (s *ServiceSet) tileServerGLStyleHandler(w http.ResponseWriter, r *http.Request) {
+	baseURL := fmt.Sprintf("%s://%s", scheme(r), getRequestHost(r))
+	tileJSONURL := func(tilesetID string) string {
+		return fmt.Sprintf("%s%s/%s.json", baseURL, TileServerGLDataRoot, tilesetID)
+	}
+
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, StylesRoot+"/"), "/"+styleFilename)
+	if !ok {
+		if s.stylesDir != "" {
+			s.stylesHandler(w, r)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+
+	if s.stylesDir != "" {
+		style, modTime, err := s.readStyle(id)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			s.logError("Could not read style for %v: %v", r.URL.Path, err)
+			return
+		}
+		if style != nil {
+			s.rewriteStyle(style, id, baseURL, tileJSONURL)
+			s.writeJSON(w, r, style, s.cacheControl.TileJSON, modTime)
+			return
+		}
+	}
+
+	ts, ok := s.tilesets[id]
+	if !ok || !ts.published {
+		http.NotFound(w, r)
+		return
+	}
+	// wait up to 30 seconds to see if tileset is ready and return it if possible
+	if ts.isLockedWithTimeout(30 * time.Second) {
+		tilesetLockedHandler(w, r)
+		return
+	}
+
+	style, err := ts.Style(tileJSONURL(id), "")
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.logError("Could not create style for %v: %v", r.URL.Path, err)
+		return
+	}
+	s.writeJSON(w, r, style, ts.cacheControl.TileJSON, ts.db.GetTimestamp())
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_TileServerGLHandler(t *testing.T) {
+	svc := newTestServiceSet(t, &ServiceSetConfig{EnableTileServerGL: true, StylesDir: newTestStylesDir(t)}, "geography-class-png", "world_cities", "nested/world_cities")
+	handler := svc.TileServerGLHandler()
+
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+		contains    []string
+	}{
+		{path: "/index.json", status: 200, contentType: "application/json", contains: []string{
+			`"tiles":["http://example.com/data/geography-class-png/{z}/{x}/{y}.png"]`,
+			`"tiles":["http://example.com/data/nested/world_cities/{z}/{x}/{y}.pbf"]`,
+		}},
+		{path: "/data.json", status: 200, contentType: "application/json", contains: []string{`"id":"world_cities"`}},
+		{path: "/data/world_cities.json", status: 200, contentType: "application/json", contains: []string{
+			`"id":"world_cities"`,
+			`"tiles":["http://example.com/data/world_cities/{z}/{x}/{y}.pbf"]`,
+		}},
+		{path: "/data/world_cities.json?tilejson=3.0.0", status: 200, contentType: "application/json", contains: []string{
+			`"tilejson":"3.0.0"`,
+			`"tiles":["http://example.com/data/world_cities/{z}/{x}/{y}.pbf?tilejson=3.0.0"]`,
+		}},
+		{path: "/data/world_cities.json?tilejson=1.0.0", status: 400},
+		{path: "/data/nested/world_cities.json", status: 200, contentType: "application/json", contains: []string{`"id":"nested/world_cities"`}},
+		{path: "/data/missing.json", status: 404},
+		{path: "/data/geography-class-png/1/0/0.png", status: 200, contentType: "image/png"},
+		{path: "/data/geography-class-png/1/0/0@2x.png", status: 200, contentType: "image/png"},
+		{path: "/data/world_cities/1/0/0.pbf", status: 200, contentType: "application/x-protobuf"},
+		{path: "/data/nested/world_cities/1/0/0.pbf", status: 200, contentType: "application/x-protobuf"},
+		{path: "/data/world_cities/1/0", status: 404},
+		{path: "/data/missing/1/0/0.png", status: 404},
+		{path: "/styles.json", status: 200, contentType: "application/json", contains: []string{
+			`{"version":8,"id":"basic","name":"Basic","url":"http://example.com/styles/basic/style.json"}`,
+			`{"version":8,"id":"world_cities","name":"Major cities from Natural Earth data","url":"http://example.com/styles/world_cities/style.json"}`,
+		}},
+		{path: "/styles/basic/style.json", status: 200, contentType: "application/json", contains: []string{
+			`"url":"http://example.com/data/world_cities.json"`,
+			`"sprite":"http://example.com/styles/basic/sprite"`,
+		}},
+		{path: "/styles/world_cities/style.json", status: 200, contentType: "application/json", contains: []string{
+			`"url":"http://example.com/data/world_cities.json"`,
+		}},
+		{path: "/styles/nested/world_cities/style.json", status: 200, contentType: "application/json", contains: []string{
+			`"url":"http://example.com/data/nested/world_cities.json"`,
+		}},
+		{path: "/styles/basic/sprite.json", status: 200, contentType: "application/json"},
+		{path: "/styles/missing/style.json", status: 404},
+	}
+
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+		if rec.Code != tc.status {
+			t.Error("Unexpected status code for:", tc.path, rec.Code, "expected:", tc.status)
+			continue
+		}
+		if tc.status != 200 {
+			continue
+		}
+		if contentType := rec.Header().Get("Content-Type"); contentType != tc.contentType {
+			t.Error("Unexpected content type for:", tc.path, contentType, "expected:", tc.contentType)
+		}
+		body := rec.Body.String()
+		for _, s := range tc.contains {
+			if !strings.Contains(body, s) {
+				t.Error("Response for:", tc.path, "does not contain:", s)
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/data.json", nil))
+	var list []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal("Could not parse data list:", err)
+	}
+	var ids []string
+	for _, tileJSON := range list {
+		ids = append(ids, tileJSON["id"].(string))
+	}
+	if expected := []string{"geography-class-png", "nested/world_cities", "world_cities"}; !reflect.DeepEqual(ids, expected) {
+		t.Error("Unexpected tilesets in data list:", ids, "expected:", expected)
+	}
+}
+
+func Test_TileServerGLIDFromURLPath(t *testing.T) {
+	// the styles directory contains a style with the same ID as a tileset
+	stylesDir := newTestStylesDir(t)
+	if err := os.MkdirAll(filepath.Join(stylesDir, "world_cities"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(stylesDir, "world_cities", "style.json"), []byte(`{"version": 8, "sources": {}, "layers": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	svc := newTestServiceSet(t, &ServiceSetConfig{EnableTileServerGL: true, StylesDir: stylesDir}, "world_cities", "nested/world_cities")
+
+	tests := []struct {
+		path string
+		id   string
+	}{
+		{path: "/data/world_cities.json", id: "world_cities"},
+		{path: "/data/world_cities/1/0/0.pbf", id: "world_cities"},
+		{path: "/data/nested/world_cities.json", id: "nested/world_cities"},
+		{path: "/data/nested/world_cities/1/0/0.pbf", id: "nested/world_cities"},
+		{path: "/data/world_cities/1/0", id: ""},
+		{path: "/data/missing.json", id: ""},
+		{path: "/styles/nested/world_cities/style.json", id: "nested/world_cities"},
+		{path: "/styles/world_cities/style.json", id: ""},
+		{path: "/styles/basic/style.json", id: ""},
+		{path: "/styles/nested/world_cities/sprite.png", id: ""},
+	}
+
+	for _, tc := range tests {
+		if id := svc.IDFromURLPath(tc.path); id != tc.id {
+			t.Error("IDFromURLPath returned unexpected ID for:", tc.path, id, "expected:", tc.id)
+		}
+	}
+
+	// generated styles are used without a styles directory
+	svc = newTestServiceSet(t, &ServiceSetConfig{EnableTileServerGL: true}, "world_cities")
+	if id := svc.IDFromURLPath("/styles/world_cities/style.json"); id != "world_cities" {
+		t.Error("IDFromURLPath returned unexpected ID for generated style:", id)
+	}
+
+	// disabled by default
+	svc = newTestServiceSet(t, &ServiceSetConfig{}, "world_cities")
+	if id := svc.IDFromURLPath("/data/world_cities.json"); id != "" {
+		t.Error("IDFromURLPath returned unexpected ID when TileServer GL endpoints are disabled:", id)
+	}
+	rec := httptest.NewRecorder()
+	svc.TileServerGLHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/data/world_cities.json", nil))
+	if rec.Code != 404 {
+		t.Error("Expected 404 when TileServer GL endpoints are disabled, got:", rec.Code)
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -22,9 +24,27 @@ type Tileset struct {
 	name       string
 	tileformat mbtiles.TileFormat
 	tilesize   uint32
+	minzoom    int
+	maxzoom    int
+	bounds     [4]float64 // [west, south, east, north] in degrees
+	center     [3]float64 // [longitude, latitude, zoom]
 	published  bool
 	locked     bool
 	router     *http.ServeMux
+
+	cacheControl    CacheControl
+	overzoom        bool
+	overzoomMaxZoom int
+
+	// elevation encoding of raster DEM tilesets ("mapbox" or "terrarium")
+	demEncoding string
+
+	hasStoredThumbnail bool
+	thumbnailMu        sync.Mutex
+	thumbnail          []byte // cached PNG thumbnail, cleared on reload
+
+	tileStatsMu sync.Mutex
+	tileStats   *tileStatsScan // restarted on reload
 }
 
 // newTileset constructs a new Tileset from an mbtiles filename.
@@ -46,6 +66,21 @@ func newTileset(svc *ServiceSet, filename, id, path string) (*Tileset, error) {
 		name = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
 	}
 
+	extent, err := readExtent(filename, metadata)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid mbtiles file %q: %v", filename, err)
+	}
+
+	tsCfg := svc.tilesetConfigs[id]
+	overzoom := svc.enableOverzoom
+	if tsCfg.Overzoom != nil {
+		overzoom = *tsCfg.Overzoom
+	}
+	overzoomMaxZoom := svc.overzoomMaxZoom
+	if tsCfg.OverzoomMaxZoom > 0 {
+		overzoomMaxZoom = tsCfg.OverzoomMaxZoom
+	}
+
 	ts := &Tileset{
 		svc:        svc,
 		db:         db,
@@ -53,7 +88,19 @@ func newTileset(svc *ServiceSet, filename, id, path string) (*Tileset, error) {
 		name:       name,
 		tileformat: db.GetTileFormat(),
 		tilesize:   db.GetTileSize(),
+		minzoom:    extent.minzoom,
+		maxzoom:    extent.maxzoom,
+		bounds:     extent.bounds,
+		center:     extent.center,
 		published:  true,
+
+		cacheControl:    svc.cacheControl.merge(tsCfg.CacheControl),
+		overzoom:        overzoom,
+		overzoomMaxZoom: overzoomMaxZoom,
+
+		demEncoding: demEncodingFromMetadata(metadata),
+
+		hasStoredThumbnail: metadata["thumbnail"] != nil,
 	}
 
 	// setup routes for tileset
@@ -62,6 +109,7 @@ func newTileset(svc *ServiceSet, filename, id, path string) (*Tileset, error) {
 
 	if svc.enableTileJSON {
 		m.HandleFunc(path, ts.tileJSONHandler)
+		m.HandleFunc(path+"/style.json", ts.styleHandler)
 	}
 
 	if svc.enablePreview {
@@ -71,6 +119,35 @@ func newTileset(svc *ServiceSet, filename, id, path string) (*Tileset, error) {
 		m.Handle(staticPrefix, staticHandler(staticPrefix))
 	}
 
+	if svc.enableThumbnails {
+		m.HandleFunc(path+"/thumbnail.png", ts.thumbnailHandler)
+	}
+
+	if svc.enableStaticMaps {
+		m.HandleFunc(path+"/static/", ts.staticMapHandler)
+	}
+
+	if svc.enableQuery {
+		m.HandleFunc(path+"/query", ts.queryHandler)
+		m.HandleFunc(path+"/pixel", ts.pixelHandler)
+	}
+
+	if svc.enableTerrain {
+		m.HandleFunc(path+"/hillshade/", ts.terrainHandler("hillshade"))
+		m.HandleFunc(path+"/slope/", ts.terrainHandler("slope"))
+	}
+
+	if svc.enableGeoJSON {
+		m.HandleFunc(path+"/features", ts.featuresHandler)
+	}
+
+	if svc.enableTileStats {
+		m.HandleFunc(path+"/tilestats", ts.tileStatsHandler)
+		if ts.tileformat == mbtiles.PBF {
+			ts.startTileStats()
+		}
+	}
+
 	if svc.enableArcGIS {
 		arcgisRoot := ArcGISServicesRoot + id + "/MapServer"
 		m.HandleFunc(arcgisRoot, ts.arcgisServiceHandler)
@@ -79,6 +156,16 @@ func newTileset(svc *ServiceSet, filename, id, path string) (*Tileset, error) {
 		m.HandleFunc(arcgisRoot+"/tile/", ts.arcgisTileHandler)
 	}
 
+	if svc.enableTMS {
+		tmsRoot := TMSServiceRoot + "/" + id
+		m.HandleFunc(tmsRoot, ts.tmsTileMapHandler)
+		m.HandleFunc(tmsRoot+"/", ts.tmsTileHandler)
+	}
+
+	if svc.enableWMTS {
+		m.HandleFunc(WMTSServiceRoot+"/"+id+"/", ts.wmtsTileHandler)
+	}
+
 	ts.router = m
 
 	return ts, nil
@@ -100,11 +187,33 @@ func (ts *Tileset) reload() error {
 	}
 	ts.db = db
 
+	metadata, err := db.ReadMetadata()
+	if err != nil {
+		return fmt.Errorf("Invalid mbtiles file %q: %v", filename, err)
+	}
+	extent, err := readExtent(filename, metadata)
+	if err != nil {
+		return fmt.Errorf("Invalid mbtiles file %q: %v", filename, err)
+	}
+	ts.minzoom, ts.maxzoom = extent.minzoom, extent.maxzoom
+	ts.bounds, ts.center = extent.bounds, extent.center
+	ts.demEncoding = demEncodingFromMetadata(metadata)
+
+	ts.thumbnailMu.Lock()
+	ts.hasStoredThumbnail = metadata["thumbnail"] != nil
+	ts.thumbnail = nil
+	ts.thumbnailMu.Unlock()
+
+	if ts.svc.enableTileStats && ts.tileformat == mbtiles.PBF {
+		ts.startTileStats()
+	}
+
 	return nil
 }
 
 // Delete closes and deletes the mbtiles file connection for this tileset
 func (ts *Tileset) delete() error {
+	ts.stopTileStats()
 	if ts.db != nil {
 		ts.db.Close()
 	}
@@ -114,6 +223,16 @@ func (ts *Tileset) delete() error {
 	return nil
 }
 
+// availableMaxZoom returns the maximum zoom level of tiles that are available
+// from the tileset, which is the overzoom max zoom level if overzoom is
+// enabled.
+func (ts *Tileset) availableMaxZoom() int {
+	if ts.overzoom && ts.overzoomMaxZoom > ts.maxzoom {
+		return ts.overzoomMaxZoom
+	}
+	return ts.maxzoom
+}
+
 // tileFormatString returns the tile format string of the underlying mbtiles file
 func (ts *Tileset) tileFormatString() string {
 	return ts.tileformat.String()
@@ -121,7 +240,8 @@ func (ts *Tileset) tileFormatString() string {
 
 // TileJSON returns the TileJSON (as a map of strings to interface{} values)
 // for the tileset.  This can be rendered into templates or returned via a
-// handler.
+// handler.  The TileJSON version is set by the "tilejson" parameter in query,
+// if present, or by ServiceSetConfig.TileJSONVersion.
 func (ts *Tileset) TileJSON(svcURL string, query string) (map[string]interface{}, error) {
 	if ts == nil || !ts.published {
 		return nil, fmt.Errorf("Tileset does not exist")
@@ -129,9 +249,22 @@ func (ts *Tileset) TileJSON(svcURL string, query string) (map[string]interface{}
 
 	db := ts.db
 
+	values, _ := url.ParseQuery(strings.TrimPrefix(query, "?"))
+	version := ts.svc.tileJSONVersion
+	if v := values.Get("tilejson"); v != "" {
+		if !validTileJSONVersion(v) {
+			return nil, fmt.Errorf("unsupported TileJSON version %q", v)
+		}
+		version = v
+	}
+	filter, err := parseTileFilter(values)
+	if err != nil {
+		return nil, err
+	}
+
 	imgFormat := db.GetTileFormat().String()
 	out := map[string]interface{}{
-		"tilejson": "2.1.0",
+		"tilejson": version,
 		"scheme":   "xyz",
 		"format":   imgFormat,
 		"tiles":    []string{fmt.Sprintf("%s/tiles/{z}/{x}/{y}.%s%s", svcURL, imgFormat, query)},
@@ -142,6 +275,15 @@ func (ts *Tileset) TileJSON(svcURL string, query string) (map[string]interface{}
 		out["tilesize"] = ts.tilesize
 	}
 
+	// image tiles are available in other tile sizes using "@2x" or the
+	// tilesize query parameter, which is passed through to the tile URLs
+	if sizes := ts.tileSizes(); len(sizes) > 1 {
+		out["tilesizes"] = sizes
+		if size, err := parseTileSize(values.Get("tilesize"), 0); err == nil && size != 0 {
+			out["tilesize"] = size
+		}
+	}
+
 	metadata, err := db.ReadMetadata()
 	if err != nil {
 		return nil, err
@@ -153,17 +295,65 @@ func (ts *Tileset) TileJSON(svcURL string, query string) (map[string]interface{}
 			continue
 
 		// strip out values that are not supported or are overridden below
-		case "grids", "interactivity", "modTime":
+		case "grids", "interactivity", "modTime", "fillzoom":
+			continue
+
+		// strip out stored thumbnail image, which is provided by the
+		// thumbnail endpoint
+		case "thumbnail":
 			continue
 
 		// strip out values that come from TileMill but aren't useful here
 		case "metatile", "scale", "autoscale", "_updated", "Layer", "Stylesheet":
 			continue
 
+		// these are derived from the tiles if missing or invalid, and are
+		// set below
+		case "bounds", "center", "minzoom", "maxzoom":
+			continue
+
+		// these may contain HTML, which is displayed by clients
+		case "attribution", "description":
+			if value, ok := v.(string); ok {
+				out[k] = sanitizeHTML(value)
+			}
+
 		default:
 			out[k] = v
 		}
 	}
+
+	out["bounds"] = ts.bounds
+	out["center"] = ts.center
+	out["minzoom"] = ts.minzoom
+	// tiles are available up to the overzoom max zoom level
+	out["maxzoom"] = ts.availableMaxZoom()
+
+	if version == tileJSONVersion3 {
+		// tiles above the max zoom level of the tileset are generated from
+		// tiles at that level
+		if ts.availableMaxZoom() > ts.maxzoom {
+			out["fillzoom"] = ts.maxzoom
+		}
+
+		// vector_layers is required for vector tiles
+		if ts.tileformat == mbtiles.PBF {
+			out["vector_layers"] = tileJSONVectorLayers(metadata["vector_layers"])
+		}
+	}
+
+	// only the layers included by the layers query parameter, which is
+	// passed through to the tile URLs, are available
+	if layers, ok := out["vector_layers"]; ok && ts.tileformat == mbtiles.PBF {
+		out["vector_layers"] = filter.filterVectorLayers(layers)
+	}
+
+	// tilestats computed from the tiles replace those in the metadata, once
+	// available
+	if stats, ok, _ := ts.getTileStats(); ok && stats != nil {
+		out["tilestats"] = stats.filter(filter)
+	}
+
 	return out, nil
 }
 
@@ -180,6 +370,11 @@ func (ts *Tileset) tileJSONHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := validateTileJSONQuery(r.URL.Query()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	query := ""
 	if r.URL.RawQuery != "" {
 		query = "?" + r.URL.RawQuery
@@ -205,6 +400,10 @@ func (ts *Tileset) tileJSONHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
+	setCacheControl(w, ts.cacheControl.TileJSON)
+	if checkNotModified(w, r, contentETag(bytes), ts.db.GetTimestamp()) {
+		return
+	}
 	_, err = w.Write(bytes)
 
 	if err != nil {
@@ -229,7 +428,6 @@ func (ts *Tileset) tileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db := ts.db
 	// split path components to extract tile coordinates x, y and z
 	pcs := strings.Split(r.URL.Path[1:], "/")
 	// we are expecting at least "services", <id> , "tiles", <z>, <x>, <y plus .ext>
@@ -239,29 +437,203 @@ func (ts *Tileset) tileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	z, x, y := pcs[l-3], pcs[l-2], pcs[l-1]
-	tc, _, err := tileCoordFromString(z, x, y)
+	tc, ext, size, err := parseTileRequest(r, z, x, y)
 	if err != nil {
-		http.Error(w, "invalid tile coordinates", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if ts.svc.enableGeoJSON && ts.tileformat == mbtiles.PBF && strings.ToLower(ext) == ".geojson" {
+		ts.writeGeoJSONTile(w, r, tc)
 		return
 	}
+
+	ts.writeTile(w, r, tc, ext, size)
+}
+
+// parseTileRequest parses the tile coordinate, optional extension, and the
+// tile size requested using "@2x" or the "tilesize" query parameter from the
+// z, x, and y components of a tile request.  Returned errors can be sent to
+// the client.
+func parseTileRequest(r *http.Request, z, x, y string) (tileCoord, string, uint32, error) {
+	y, scale, err := tileScaleFromString(y)
+	if err != nil {
+		return tileCoord{}, "", 0, err
+	}
+	tc, ext, err := tileCoordFromString(z, x, y)
+	if err != nil {
+		return tc, ext, 0, errors.New("invalid tile coordinates")
+	}
+	size, err := parseTileSize(r.URL.Query().Get("tilesize"), scale)
+	return tc, ext, size, err
+}
+
+// readTile reads the tile for XYZ tile coordinate tc from the tile cache, if
+// enabled, or the mbtiles file.  Returns nil data if the tile does not exist.
+// The returned data may be shared and must not be modified.
+func (ts *Tileset) readTile(tc tileCoord) ([]byte, error) {
+	if ts.svc.tileCache == nil {
+		return ts.readTileFromDB(tc)
+	}
+	return ts.svc.tileCache.get(ts.id, tc, func() ([]byte, error) {
+		return ts.readTileFromDB(tc)
+	})
+}
+
+// readTileFromDB reads the tile for XYZ tile coordinate tc from the mbtiles
+// file.  Returns nil data if the tile does not exist.
+func (ts *Tileset) readTileFromDB(tc tileCoord) ([]byte, error) {
 	var data []byte
 	// flip y to match the spec
-	tc.y = (1 << uint64(tc.z)) - 1 - tc.y
-	err = db.ReadTile(tc.z, tc.x, tc.y, &data)
+	err := ts.db.ReadTile(tc.z, tc.x, (1<<uint64(tc.z))-1-tc.y, &data)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) <= 1 {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// getTile returns the tile for XYZ tile coordinate tc and its format.
+// If a tile is not found, it is synthesized from its nearest ancestor tile if
+// overzoom is enabled.  Returns nil data if the tile does not exist.
+func (ts *Tileset) getTile(tc tileCoord) ([]byte, mbtiles.TileFormat, error) {
+	data, err := ts.readTile(tc)
+	if err != nil {
+		return nil, ts.tileformat, fmt.Errorf("cannot fetch tile from DB: %v", err)
+	}
+	if data == nil && ts.overzoom {
+		data, format, err := ts.overzoomTile(tc)
+		if err != nil {
+			return nil, format, fmt.Errorf("cannot create overzoomed tile: %v", err)
+		}
+		return data, format, nil
+	}
+	return data, ts.tileformat, nil
+}
+
+// writeTile writes the tile for XYZ tile coordinate tc to w, in the format
+// requested by ext and at the requested tile size (0 uses the tile size of
+// the tileset).  If a tile is not found, the default response for a missing
+// tile is returned.  Vector tiles include only the layers and features
+// selected by the layers and filter query parameters, if present.
+func (ts *Tileset) writeTile(w http.ResponseWriter, r *http.Request, tc tileCoord, ext string, size uint32) {
+	// the output format is determined by the extension if provided, otherwise
+	// by the Accept header
+	target, ok := ts.tileformat, true
+	if ext != "" {
+		target, ok = tileFormatFromExtension(ext)
+		ok = ok && canTranscode(ts.tileformat, target)
+	} else {
+		w.Header().Add("Vary", "Accept")
+		target, ok = negotiateTileFormat(r, ts.tileformat)
+	}
+	if !ok {
+		http.Error(w, fmt.Sprintf("tiles cannot be provided in the requested format; tileset format is %s", ts.tileformat.String()), http.StatusNotAcceptable)
+		return
+	}
+
+	filter, err := parseTileFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter != nil && ts.tileformat != mbtiles.PBF {
+		http.Error(w, "layers and filter are only available for vector tilesets", http.StatusBadRequest)
+		return
+	}
+
+	if size == 0 {
+		size = ts.tilesize
+	}
+	if !ts.canResize(size) {
+		http.Error(w, fmt.Sprintf("tiles cannot be provided at the requested tile size; tileset tile size is %d", ts.tilesize), http.StatusBadRequest)
+		return
+	}
 
+	var data []byte
+	var format mbtiles.TileFormat
+	if size == ts.tilesize {
+		data, format, err = ts.getTile(tc)
+	} else {
+		data, format, err = ts.resizeTile(tc, size)
+	}
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		ts.svc.logError("cannot fetch tile from DB for z=%d, x=%d, y=%d at path %v: %v", tc.z, tc.x, tc.y, r.URL.Path, err)
+		ts.svc.logError("cannot fetch tile for z=%d, x=%d, y=%d at path %v: %v", tc.z, tc.x, tc.y, r.URL.Path, err)
 		return
 	}
-	if data == nil || len(data) <= 1 {
-		tileNotFoundHandler(w, r, ts.tileformat, ts.tilesize, ts.svc.returnMissingImageTile404)
+
+	if data == nil {
+		setCacheControl(w, ts.cacheControl.MissingTiles)
+		tileNotFoundHandler(w, r, ts.tileformat, size, ts.svc.returnMissingImageTile404)
 		return
 	}
 
-	w.Header().Set("Content-Type", db.GetTileFormat().MimeType())
-	if db.GetTileFormat() == mbtiles.PBF {
-		w.Header().Set("Content-Encoding", "gzip")
+	if filter != nil {
+		data, err = filterVectorTile(data, filter)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			ts.svc.logError("could not filter tile for %v: %v", r.URL.Path, err)
+			return
+		}
+	}
+
+	// overzoomed WEBP tiles are encoded as PNG; return these as-is when the
+	// native format is requested
+	if target == ts.tileformat {
+		target = format
+	}
+
+	etag := contentETag(data)
+	if target != format {
+		etag = etagWithSuffix(etag, target.String())
+	}
+
+	// vector tiles may be stored compressed or uncompressed; negotiate the
+	// encoding sent to the client and recompress if needed
+	stored, encoding := encodingIdentity, encodingIdentity
+	if format == mbtiles.PBF {
+		stored = detectEncoding(data)
+		encoding = negotiateEncoding(r, stored)
+		w.Header().Add("Vary", "Accept-Encoding")
+		if encoding == "" {
+			http.Error(w, "no supported content encoding is acceptable", http.StatusNotAcceptable)
+			return
+		}
+
+		if encoding != stored {
+			etag = etagWithSuffix(etag, encoding)
+		}
+	}
+
+	w.Header().Set("Content-Type", target.MimeType())
+	setCacheControl(w, ts.cacheControl.Tiles)
+	if encoding != encodingIdentity {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+
+	if checkNotModified(w, r, etag, ts.db.GetTimestamp()) {
+		return
+	}
+
+	if target != format {
+		data, err = transcodeImage(data, target, ts.svc.jpegQuality)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			ts.svc.logError("could not convert tile from %s to %s for %v: %v", format.String(), target.String(), r.URL.Path, err)
+			return
+		}
+	}
+
+	if encoding != stored {
+		data, err = recode(data, stored, encoding)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			ts.svc.logError("could not encode tile data for %v: %v", r.URL.Path, err)
+			return
+		}
 	}
 
 	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
@@ -293,6 +665,11 @@ func (ts *Tileset) previewHandler(w http.ResponseWriter, r *http.Request) {
 		query = "?" + r.URL.RawQuery
 	}
 
+	if err := validateTileJSONQuery(r.URL.Query()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	tilesetURL := fmt.Sprintf("%s://%s%s", scheme(r), getRequestHost(r), strings.TrimSuffix(r.URL.Path, "/map"))
 
 	tileJSON, err := ts.TileJSON(tilesetURL, query)
@@ -309,20 +686,37 @@ func (ts *Tileset) previewHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	style, err := ts.Style(tilesetURL, query)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		ts.svc.logError("could not create style for preview for %v: %v", r.URL.Path, err)
+		return
+	}
+
+	styleBytes, err := json.Marshal(style)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		ts.svc.logError("could not render style for preview for %v: %v", r.URL.Path, err)
+		return
+	}
+
 	p := struct {
 		URL             string
 		ID              string
 		TileJSON        template.JS
+		Style           template.JS
 		BasemapStyleURL string
 		BasemapTilesURL string
 	}{
 		tilesetURL,
 		ts.id,
 		template.JS(string(bytes)),
+		template.JS(string(styleBytes)),
 		ts.svc.basemapStyleURL,
 		ts.svc.basemapTilesURL,
 	}
 
+	setCacheControl(w, ts.cacheControl.Preview)
 	executeTemplate(w, "map", p)
 }
 
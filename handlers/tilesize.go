@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+
+	mbtiles "github.com/brendan-ward/mbtiles-go"
+	xdraw "golang.org/x/image/draw"
+)
+
+// Tile sizes that image tilesets can be converted between
+const (
+	tileSize256 uint32 = 256
+	tileSize512 uint32 = 512
+)
+
+// parseTileSize returns the tile size requested using a scale factor parsed
+// from the tile URL (e.g., 2 for "@2x") and / or the value of the "tilesize"
+// query parameter.  Returns 0 if no tile size was requested.
+func parseTileSize(value string, scale int) (uint32, error) {
+	var size uint32
+	if scale > 0 {
+		size = tileSize256 * uint32(scale)
+	}
+
+	if value != "" {
+		s, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || (uint32(s) != tileSize256 && uint32(s) != tileSize512) {
+			return 0, fmt.Errorf("tilesize must be %d or %d", tileSize256, tileSize512)
+		}
+		if size != 0 && size != uint32(s) {
+			return 0, fmt.Errorf("tilesize %d conflicts with @%dx scale", s, scale)
+		}
+		size = uint32(s)
+	}
+	return size, nil
+}
+
+// canResize returns true if tiles in the tileset can be converted to size
+func (ts *Tileset) canResize(size uint32) bool {
+	if size == ts.tilesize {
+		return true
+	}
+	if !isImageFormat(ts.tileformat) {
+		return false
+	}
+	return size == ts.tilesize*2 || size*2 == ts.tilesize
+}
+
+// tileSizes returns the tile sizes that are available for the tileset
+func (ts *Tileset) tileSizes() []uint32 {
+	var sizes []uint32
+	for _, size := range []uint32{tileSize256, tileSize512} {
+		if ts.canResize(size) {
+			sizes = append(sizes, size)
+		}
+	}
+	return sizes
+}
+
+// standardTileSize returns the tile size used for tile matrix sets with 256
+// pixel tiles, such as WMTS GoogleMapsCompatible: 256 pixels for image
+// tilesets that can be resized, otherwise 0 to use the native tile size.
+func (ts *Tileset) standardTileSize() uint32 {
+	if ts.canResize(tileSize256) {
+		return tileSize256
+	}
+	return 0
+}
+
+// resizeTile creates an image tile of size x size pixels for XYZ tile
+// coordinate tc from a tileset with a different tile size.
+//
+// Tiles twice the size of the tileset are created by mosaicking the four
+// child tiles of tc, or by upsampling the tile itself above the maximum zoom
+// level of the tileset.  Tiles half the size of the tileset are created by
+// cropping the corresponding quadrant of the parent tile of tc, or by
+// downsampling the tile itself at zoom level 0.
+//
+// Returns the encoded tile and its format, which is PNG for WEBP tilesets.
+// Returns nil data if the tile cannot be created.
+func (ts *Tileset) resizeTile(tc tileCoord, size uint32) ([]byte, mbtiles.TileFormat, error) {
+	var img image.Image
+	var err error
+	switch {
+	case size == ts.tilesize*2 && tc.z < int64(ts.maxzoom):
+		img, err = ts.mosaicChildren(tc, int(size))
+
+	case size*2 == ts.tilesize && tc.z > 0:
+		parent := tileCoord{z: tc.z - 1, x: tc.x >> 1, y: tc.y >> 1}
+		img, err = ts.readImage(parent)
+		if img != nil {
+			span := float64(img.Bounds().Dx()) / 2
+			img = scaleSubImage(img, float64(tc.x&1)*span, float64(tc.y&1)*span, span, int(size))
+		}
+
+	default:
+		img, err = ts.readImage(tc)
+		if img != nil {
+			img = scaleSubImage(img, 0, 0, float64(img.Bounds().Dx()), int(size))
+		}
+	}
+	if err != nil || img == nil {
+		return nil, ts.tileformat, err
+	}
+
+	return encodeImage(img, ts.tileformat, ts.svc.jpegQuality)
+}
+
+// readImage reads and decodes the image tile for XYZ tile coordinate tc.
+// Returns a nil image if the tile does not exist.
+func (ts *Tileset) readImage(tc tileCoord) (image.Image, error) {
+	data, _, err := ts.getTile(tc)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return decodeImage(data)
+}
+
+// mosaicChildren creates an image of size x size pixels from the four child
+// tiles of tc.  Missing child tiles are left transparent.
+// Returns a nil image if none of the child tiles exist.
+func (ts *Tileset) mosaicChildren(tc tileCoord, size int) (image.Image, error) {
+	var children [4]image.Image
+	found := false
+	for i := range children {
+		child := tileCoord{z: tc.z + 1, x: tc.x<<1 + int64(i%2), y: tc.y<<1 + int64(i/2)}
+		img, err := ts.readImage(child)
+		if err != nil {
+			return nil, err
+		}
+		children[i] = img
+		found = found || img != nil
+	}
+	if !found {
+		return nil, nil
+	}
+	return mosaicImages(children, size), nil
+}
+
+// mosaicImages arranges four images into quadrants of a new image of
+// size x size pixels, in order: top left, top right, bottom left, bottom
+// right.  Images are resampled to fit their quadrant if needed; nil images
+// are left transparent.
+func mosaicImages(images [4]image.Image, size int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	half := size / 2
+	for i, img := range images {
+		if img == nil {
+			continue
+		}
+		x, y := (i%2)*half, (i/2)*half
+		xdraw.BiLinear.Scale(dst, image.Rect(x, y, x+half, y+half), img, img.Bounds(), xdraw.Src, nil)
+	}
+	return dst
+}
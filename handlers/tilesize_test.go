@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func Test_ParseTileSize(t *testing.T) {
+	tests := []struct {
+		value    string
+		scale    int
+		expected uint32
+		err      bool
+	}{
+		{value: "", scale: 0, expected: 0},
+		{value: "", scale: 1, expected: 256},
+		{value: "", scale: 2, expected: 512},
+		{value: "256", scale: 0, expected: 256},
+		{value: "512", scale: 0, expected: 512},
+		{value: "512", scale: 2, expected: 512},
+		{value: "256", scale: 2, err: true},
+		{value: "1024", scale: 0, err: true},
+		{value: "foo", scale: 0, err: true},
+	}
+
+	for _, tc := range tests {
+		size, err := parseTileSize(tc.value, tc.scale)
+		if tc.err {
+			if err == nil {
+				t.Error("parseTileSize did not raise expected error for:", tc.value, tc.scale)
+			}
+			continue
+		}
+		if err != nil {
+			t.Error("parseTileSize raised unexpected error for:", tc.value, tc.scale, err)
+			continue
+		}
+		if size != tc.expected {
+			t.Error("parseTileSize returned unexpected size:", size, "expected:", tc.expected)
+			continue
+		}
+	}
+}
+
+func Test_MosaicImages(t *testing.T) {
+	src := quadrantImage()
+	red := color.RGBA{255, 0, 0, 255}
+	yellow := color.RGBA{255, 255, 0, 255}
+
+	out := mosaicImages([4]image.Image{src, nil, nil, src}, 512)
+	if out.Bounds().Dx() != 512 || out.Bounds().Dy() != 512 {
+		t.Fatal("mosaicImages returned unexpected size:", out.Bounds())
+	}
+
+	tests := []struct {
+		x, y     int
+		expected color.RGBA
+	}{
+		{x: 64, y: 64, expected: red},
+		{x: 192, y: 192, expected: yellow},
+		{x: 320, y: 320, expected: red},
+		{x: 448, y: 448, expected: yellow},
+		{x: 384, y: 128, expected: color.RGBA{}},
+		{x: 128, y: 384, expected: color.RGBA{}},
+	}
+
+	for _, tc := range tests {
+		if c := out.RGBAAt(tc.x, tc.y); c != tc.expected {
+			t.Error("mosaicImages returned unexpected color at:", tc.x, tc.y, ":", c, "expected:", tc.expected)
+		}
+	}
+}
@@ -0,0 +1,438 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"crawshaw.io/sqlite"
+	mbtiles "github.com/brendan-ward/mbtiles-go"
+	"github.com/paulmach/orb/encoding/mvt"
+)
+
+const (
+	// limits of tilestats, following the mapbox tilestats format: the
+	// maximum number of layers, attributes per layer, and values per
+	// attribute that are reported
+	tileStatsMaxLayers     = 1000
+	tileStatsMaxAttributes = 1000
+	tileStatsMaxValues     = 100
+
+	// tileStatsMaxUniqueValues is the maximum number of unique values of
+	// each attribute that are counted
+	tileStatsMaxUniqueValues = 10000
+
+	// tileStatsMaxFeatureIDs is the maximum number of feature IDs of each
+	// layer that are kept to count features split across tiles once
+	tileStatsMaxFeatureIDs = 100000
+)
+
+// tileStats describes the layers of a vector tileset in the mapbox tilestats
+// format
+type tileStats struct {
+	LayerCount int               `json:"layerCount"`
+	Layers     []*tileStatsLayer `json:"layers"`
+}
+
+// tileStatsLayer describes the features of a layer.  Count is the number of
+// features at the highest zoom level of the layer, where features with the
+// same ID in different tiles are counted once; this is approximate for layers
+// with more than tileStatsMaxFeatureIDs IDs.  Geometry is the most common
+// geometry type of these features.
+type tileStatsLayer struct {
+	Layer          string                `json:"layer"`
+	Count          int                   `json:"count"`
+	Geometry       string                `json:"geometry"`
+	AttributeCount int                   `json:"attributeCount"`
+	Attributes     []*tileStatsAttribute `json:"attributes"`
+}
+
+// tileStatsAttribute describes the values of an attribute of a layer.  Count
+// is the number of unique values (up to tileStatsMaxUniqueValues), and Type
+// is "string", "number", "boolean", or "mixed".  Values are a sample of the
+// unique values, and Min and Max are the range of numeric values, if any.
+type tileStatsAttribute struct {
+	Attribute string        `json:"attribute"`
+	Count     int           `json:"count"`
+	Type      string        `json:"type"`
+	Values    []interface{} `json:"values"`
+	Min       *float64      `json:"min,omitempty"`
+	Max       *float64      `json:"max,omitempty"`
+}
+
+// filter returns the tilestats of the layers included by filter f
+func (s *tileStats) filter(f *tileFilter) *tileStats {
+	if f == nil || f.layers == nil {
+		return s
+	}
+	out := &tileStats{Layers: []*tileStatsLayer{}}
+	for _, layer := range s.Layers {
+		if f.includesLayer(layer.Layer) {
+			out.Layers = append(out.Layers, layer)
+		}
+	}
+	out.LayerCount = len(out.Layers)
+	return out
+}
+
+// attributeStatsBuilder accumulates the values of an attribute
+type attributeStatsBuilder struct {
+	types    map[string]bool
+	unique   map[interface{}]bool
+	values   []interface{}
+	min, max *float64
+}
+
+// add adds value v, which is ignored if it is not a string, finite number,
+// or boolean
+func (b *attributeStatsBuilder) add(v interface{}) {
+	switch v := v.(type) {
+	case string:
+		b.types["string"] = true
+	case float64:
+		if !isFinite(v) {
+			return
+		}
+		b.types["number"] = true
+		if b.min == nil || v < *b.min {
+			b.min = &v
+		}
+		if b.max == nil || v > *b.max {
+			b.max = &v
+		}
+	case bool:
+		b.types["boolean"] = true
+	default:
+		return
+	}
+	if !b.unique[v] && len(b.unique) < tileStatsMaxUniqueValues {
+		b.unique[v] = true
+		if len(b.values) < tileStatsMaxValues {
+			b.values = append(b.values, v)
+		}
+	}
+}
+
+// layerStatsBuilder accumulates the features of a layer
+type layerStatsBuilder struct {
+	// zoom level that features are counted at; -1 until the first feature
+	zoom       int
+	count      int
+	ids        map[interface{}]bool
+	geometries map[string]int
+	attributes map[string]*attributeStatsBuilder
+}
+
+// tileStatsBuilder accumulates the layers of vector tiles, which must be
+// added in descending order of zoom level
+type tileStatsBuilder struct {
+	layers map[string]*layerStatsBuilder
+	// maximum number of feature IDs kept per layer; features with other IDs
+	// are counted in each tile they appear in once it is reached
+	maxIDs int
+}
+
+// newTileStatsBuilder returns a new, empty tileStatsBuilder
+func newTileStatsBuilder() *tileStatsBuilder {
+	return &tileStatsBuilder{
+		layers: make(map[string]*layerStatsBuilder),
+		maxIDs: tileStatsMaxFeatureIDs,
+	}
+}
+
+// add adds the layers of a vector tile at zoom level z
+func (b *tileStatsBuilder) add(z int, layers mvt.Layers) {
+	for _, layer := range layers {
+		lb, ok := b.layers[layer.Name]
+		if !ok {
+			if len(b.layers) >= tileStatsMaxLayers {
+				continue
+			}
+			lb = &layerStatsBuilder{
+				zoom:       -1,
+				ids:        make(map[interface{}]bool),
+				geometries: make(map[string]int),
+				attributes: make(map[string]*attributeStatsBuilder),
+			}
+			b.layers[layer.Name] = lb
+		}
+
+		for _, f := range layer.Features {
+			if lb.zoom == -1 {
+				lb.zoom = z
+			}
+			// features are only counted at the highest zoom level of the
+			// layer; those with IDs may be split across tiles
+			if z == lb.zoom && (f.ID == nil || !lb.ids[f.ID]) {
+				if f.ID != nil && len(lb.ids) < b.maxIDs {
+					lb.ids[f.ID] = true
+				}
+				lb.count++
+				if f.Geometry != nil {
+					lb.geometries[filterGeometryType(f.Geometry)]++
+				}
+			}
+
+			for k, v := range f.Properties {
+				ab, ok := lb.attributes[k]
+				if !ok {
+					if len(lb.attributes) >= tileStatsMaxAttributes {
+						continue
+					}
+					ab = &attributeStatsBuilder{
+						types:  make(map[string]bool),
+						unique: make(map[interface{}]bool),
+					}
+					lb.attributes[k] = ab
+				}
+				ab.add(v)
+			}
+		}
+	}
+}
+
+// stats returns the tilestats of the layers that have been added, sorted by
+// name
+func (b *tileStatsBuilder) stats() *tileStats {
+	out := &tileStats{Layers: make([]*tileStatsLayer, 0, len(b.layers))}
+	for name, lb := range b.layers {
+		layer := &tileStatsLayer{
+			Layer:      name,
+			Count:      lb.count,
+			Attributes: make([]*tileStatsAttribute, 0, len(lb.attributes)),
+		}
+
+		// most common geometry type, preferring points, then lines
+		count := 0
+		for _, geometry := range []string{"Point", "LineString", "Polygon"} {
+			if lb.geometries[geometry] > count {
+				layer.Geometry, count = geometry, lb.geometries[geometry]
+			}
+		}
+
+		for attribute, ab := range lb.attributes {
+			a := &tileStatsAttribute{
+				Attribute: attribute,
+				Count:     len(ab.unique),
+				Type:      "mixed",
+				Values:    ab.values,
+				Min:       ab.min,
+				Max:       ab.max,
+			}
+			if len(ab.types) == 1 {
+				for t := range ab.types {
+					a.Type = t
+				}
+			}
+			sortValues(a.Values)
+			layer.Attributes = append(layer.Attributes, a)
+		}
+		sort.Slice(layer.Attributes, func(i, j int) bool {
+			return layer.Attributes[i].Attribute < layer.Attributes[j].Attribute
+		})
+		layer.AttributeCount = len(layer.Attributes)
+
+		out.Layers = append(out.Layers, layer)
+	}
+	sort.Slice(out.Layers, func(i, j int) bool {
+		return out.Layers[i].Layer < out.Layers[j].Layer
+	})
+	out.LayerCount = len(out.Layers)
+	return out
+}
+
+// sortValues sorts attribute values: booleans, then numbers, then strings
+func sortValues(values []interface{}) {
+	rank := func(v interface{}) int {
+		switch v.(type) {
+		case bool:
+			return 0
+		case float64:
+			return 1
+		}
+		return 2
+	}
+	sort.Slice(values, func(i, j int) bool {
+		a, b := values[i], values[j]
+		if ra, rb := rank(a), rank(b); ra != rb {
+			return ra < rb
+		}
+		if a, ok := a.(bool); ok {
+			return !a && b.(bool)
+		}
+		c, _ := compareValues(a, b)
+		return c < 0
+	})
+}
+
+// computeTileStats returns the tilestats of the vector tiles in the mbtiles
+// file, read in descending order of zoom level.  Tiles that cannot be decoded
+// are logged with logError and skipped.  Returns the error of ctx if it is
+// done before all tiles are read.
+func computeTileStats(ctx context.Context, filename string, logError func(format string, args ...interface{})) (*tileStats, error) {
+	con, err := sqlite.OpenConn(filename, sqlite.SQLITE_OPEN_READONLY|sqlite.SQLITE_OPEN_NOMUTEX)
+	if err != nil {
+		return nil, err
+	}
+	defer con.Close()
+
+	query, _, err := con.PrepareTransient("SELECT zoom_level, tile_column, tile_row, tile_data FROM tiles WHERE zoom_level BETWEEN 0 AND $maxzoom ORDER BY zoom_level DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer query.Finalize()
+	query.SetInt64("$maxzoom", maxZoomLevel)
+
+	b := newTileStatsBuilder()
+	for {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		hasRow, err := query.Step()
+		if err != nil {
+			return nil, err
+		}
+		if !hasRow {
+			break
+		}
+		z := query.ColumnInt(0)
+		data := make([]byte, query.ColumnLen(3))
+		query.ColumnBytes(3, data)
+		if len(data) <= 1 {
+			continue
+		}
+		layers, err := decodeVectorTile(data)
+		if err != nil {
+			// flip the TMS row of mbtiles to report the XYZ tile
+			logError("Could not decode tile %d/%d/%d of %q for tilestats: %v", z, query.ColumnInt64(1), (int64(1)<<z)-1-query.ColumnInt64(2), filename, err)
+			continue
+		}
+		b.add(z, layers)
+	}
+	return b.stats(), nil
+}
+
+// tileStatsScan is a background scan of the tiles of a tileset to compute its
+// tilestats.  stats and err are set before done is closed.
+type tileStatsScan struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	stats  *tileStats
+	err    error
+}
+
+// startTileStats starts computing the tilestats of the tileset in the
+// background, canceling any scan in progress.  Tilesets are scanned one at a
+// time.
+func (ts *Tileset) startTileStats() {
+	ctx, cancel := context.WithCancel(context.Background())
+	scan := &tileStatsScan{cancel: cancel, done: make(chan struct{})}
+	filename := ts.db.GetFilename()
+
+	ts.tileStatsMu.Lock()
+	if ts.tileStats != nil {
+		ts.tileStats.cancel()
+	}
+	ts.tileStats = scan
+	ts.tileStatsMu.Unlock()
+
+	go func() {
+		defer close(scan.done)
+
+		select {
+		case ts.svc.tileStatsSem <- struct{}{}:
+			defer func() { <-ts.svc.tileStatsSem }()
+		case <-ctx.Done():
+			scan.err = ctx.Err()
+			return
+		}
+
+		scan.stats, scan.err = computeTileStats(ctx, filename, ts.svc.logError)
+		if scan.err != nil && ctx.Err() == nil {
+			ts.svc.logError("Could not compute tilestats for tileset %q: %v", ts.id, scan.err)
+		}
+	}()
+}
+
+// stopTileStats cancels the scan of the tileset for tilestats, if any
+func (ts *Tileset) stopTileStats() {
+	ts.tileStatsMu.Lock()
+	if ts.tileStats != nil {
+		ts.tileStats.cancel()
+	}
+	ts.tileStats = nil
+	ts.tileStatsMu.Unlock()
+}
+
+// getTileStats returns the tilestats of the tileset and true if the scan to
+// compute them has finished.  Returns false if tilestats are not enabled for
+// the tileset or are still being computed.
+func (ts *Tileset) getTileStats() (*tileStats, bool, error) {
+	ts.tileStatsMu.Lock()
+	scan := ts.tileStats
+	ts.tileStatsMu.Unlock()
+
+	if scan == nil {
+		return nil, false, nil
+	}
+	select {
+	case <-scan.done:
+		return scan.stats, true, scan.err
+	default:
+		return nil, false, nil
+	}
+}
+
+// tileStatsHandler is an http.HandlerFunc that returns the tilestats of a
+// vector tileset, of the form /services/<id>/tilestats[?layers=<layers>]
+// Returns HTTP 503 until the tilestats have been computed.
+func (ts *Tileset) tileStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if !ts.published {
+		http.NotFound(w, r)
+		return
+	}
+
+	// wait up to 30 seconds to see if tileset is ready and return it if possible
+	if ts.isLockedWithTimeout(30 * time.Second) {
+		tilesetLockedHandler(w, r)
+		return
+	}
+
+	if ts.tileformat != mbtiles.PBF {
+		http.Error(w, "tilestats are only available for vector tilesets", http.StatusBadRequest)
+		return
+	}
+
+	filter, err := parseTileFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stats, ok, err := ts.getTileStats()
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, "tilestats are being computed", http.StatusServiceUnavailable)
+		return
+	}
+
+	bytes, err := json.Marshal(stats.filter(filter))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		ts.svc.logError("Could not encode tilestats for %v: %v", r.URL.Path, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	setCacheControl(w, ts.cacheControl.TileJSON)
+	if checkNotModified(w, r, contentETag(bytes), ts.db.GetTimestamp()) {
+		return
+	}
+	w.Write(bytes)
+}
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+)
+
+// waitTileStats waits for the scan of tileset id for tilestats to finish
+func waitTileStats(t *testing.T, svc *ServiceSet, id string) {
+	ts := svc.tilesets[id]
+	ts.tileStatsMu.Lock()
+	scan := ts.tileStats
+	ts.tileStatsMu.Unlock()
+	if scan == nil {
+		t.Fatal("Expected tilestats scan for tileset:", id)
+	}
+	<-scan.done
+}
+
+func Test_TileStatsBuilder(t *testing.T) {
+	newLayer := func(name string, features ...*geojson.Feature) *mvt.Layer {
+		fc := geojson.NewFeatureCollection()
+		fc.Features = features
+		return mvt.NewLayer(name, fc)
+	}
+	newFeature := func(id interface{}, g orb.Geometry, properties geojson.Properties) *geojson.Feature {
+		f := geojson.NewFeature(g)
+		f.ID = id
+		f.Properties = properties
+		return f
+	}
+
+	b := newTileStatsBuilder()
+	// the same road split across two tiles
+	b.add(2, mvt.Layers{
+		newLayer("roads",
+			newFeature(float64(1), orb.LineString{{0, 0}, {1, 1}}, geojson.Properties{"class": "primary", "lanes": float64(2)}),
+			newFeature(nil, orb.Point{0, 0}, geojson.Properties{"class": "stop", "lanes": "unknown"}),
+		),
+	})
+	b.add(2, mvt.Layers{
+		newLayer("roads",
+			newFeature(float64(1), orb.LineString{{1, 1}, {2, 2}}, geojson.Properties{"class": "primary", "lanes": float64(2)}),
+			newFeature(float64(2), orb.LineString{{1, 1}, {2, 2}}, geojson.Properties{"class": "secondary", "lanes": float64(4), "bridge": true}),
+			newFeature(float64(4), orb.LineString{{2, 2}, {3, 3}}, geojson.Properties{"class": "primary", "lanes": math.NaN()}),
+		),
+	})
+	// features at lower zoom levels are not counted, but their attributes
+	// are included
+	b.add(1, mvt.Layers{
+		newLayer("roads",
+			newFeature(float64(3), orb.LineString{{0, 0}, {2, 2}}, geojson.Properties{"class": "motorway", "lanes": float64(-1), "bridge": false}),
+		),
+		newLayer("countries",
+			newFeature(float64(1), orb.Polygon{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}, geojson.Properties{"name": "A"}),
+			newFeature(float64(1), orb.MultiPolygon{{{{1, 0}, {2, 0}, {2, 1}, {1, 0}}}}, geojson.Properties{"name": "A"}),
+			newFeature(float64(2), orb.Point{0, 0}, geojson.Properties{"name": "B"}),
+		),
+		newLayer("empty"),
+	})
+
+	min, max := float64(-1), float64(4)
+	expected := &tileStats{
+		LayerCount: 3,
+		Layers: []*tileStatsLayer{
+			{
+				Layer:          "countries",
+				Count:          2,
+				Geometry:       "Point",
+				AttributeCount: 1,
+				Attributes: []*tileStatsAttribute{
+					{Attribute: "name", Count: 2, Type: "string", Values: []interface{}{"A", "B"}},
+				},
+			},
+			{
+				Layer:          "empty",
+				Count:          0,
+				Geometry:       "",
+				AttributeCount: 0,
+				Attributes:     []*tileStatsAttribute{},
+			},
+			{
+				Layer:          "roads",
+				Count:          4,
+				Geometry:       "LineString",
+				AttributeCount: 3,
+				Attributes: []*tileStatsAttribute{
+					{Attribute: "bridge", Count: 2, Type: "boolean", Values: []interface{}{false, true}},
+					{Attribute: "class", Count: 4, Type: "string", Values: []interface{}{"motorway", "primary", "secondary", "stop"}},
+					{Attribute: "lanes", Count: 4, Type: "mixed", Values: []interface{}{float64(-1), float64(2), float64(4), "unknown"}, Min: &min, Max: &max},
+				},
+			},
+		},
+	}
+
+	stats := b.stats()
+	if !reflect.DeepEqual(stats, expected) {
+		actual, _ := json.Marshal(stats)
+		e, _ := json.Marshal(expected)
+		t.Errorf("Unexpected tilestats:\n%s\nexpected:\n%s", actual, e)
+	}
+
+	filtered := stats.filter(&tileFilter{layers: map[string]bool{"roads": true, "foo": true}})
+	if filtered.LayerCount != 1 || filtered.Layers[0].Layer != "roads" {
+		t.Error("Unexpected filtered tilestats:", filtered.Layers)
+	}
+	if stats.filter(nil) != stats {
+		t.Error("Expected tilestats to be returned as-is without filter")
+	}
+
+	// features are counted in each tile once the maximum number of IDs is
+	// reached
+	b = newTileStatsBuilder()
+	b.maxIDs = 1
+	for i := 0; i < 2; i++ {
+		b.add(0, mvt.Layers{
+			newLayer("roads",
+				newFeature(float64(1), orb.LineString{{0, 0}, {1, 1}}, nil),
+				newFeature(float64(2), orb.LineString{{1, 1}, {2, 2}}, nil),
+			),
+		})
+	}
+	if count := b.stats().Layers[0].Count; count != 3 {
+		t.Error("Unexpected approximate feature count:", count, "expected:", 3)
+	}
+}
+
+func Test_ComputeTileStats_InvalidTile(t *testing.T) {
+	point := geojson.NewFeature(orb.Point{512, 512})
+	point.Properties["rank"] = 3
+	filename := newTestVectorFile(t, point)
+
+	con, err := sqlite.OpenConn(filename, sqlite.SQLITE_OPEN_READWRITE)
+	if err != nil {
+		t.Fatal("Could not open mbtiles file:", err)
+	}
+	// truncated gzip data
+	err = sqlitex.Exec(con, "INSERT INTO tiles VALUES (?, ?, ?, ?)", nil, 0, 0, 0, []byte{0x1f, 0x8b, 0x08})
+	con.Close()
+	if err != nil {
+		t.Fatal("Could not write tile:", err)
+	}
+
+	var messages []string
+	logError := func(format string, args ...interface{}) {
+		messages = append(messages, fmt.Sprintf(format, args...))
+	}
+	stats, err := computeTileStats(context.Background(), filename, logError)
+	if err != nil {
+		t.Fatal("Could not compute tilestats:", err)
+	}
+	if stats.LayerCount != 1 || stats.Layers[0].Count != 1 {
+		t.Error("Unexpected tilestats:", stats.Layers)
+	}
+	if len(messages) != 1 || !strings.Contains(messages[0], "Could not decode tile 0/0/0") {
+		t.Error("Expected invalid tile to be logged:", messages)
+	}
+}
+
+func Test_TileStatsHandler(t *testing.T) {
+	svc := newTestServiceSet(t, &ServiceSetConfig{EnableTileStats: true, EnableTileJSON: true}, "geography-class-png")
+
+	line := geojson.NewFeature(orb.LineString{{1024, 512}, {3072, 512}})
+	line.ID = 1
+	line.Properties["class"] = "primary"
+	point := geojson.NewFeature(orb.Point{512, 512})
+	point.Properties["rank"] = 3
+	filename := newTestVectorFile(t, line, point)
+
+	// hold the scan until the response while it is in progress is checked
+	svc.tileStatsSem <- struct{}{}
+	if err := svc.AddTileset(filename, "vector"); err != nil {
+		t.Fatal("Could not add tileset:", err)
+	}
+
+	get := func(path string) (int, []byte) {
+		rec := httptest.NewRecorder()
+		svc.Handler().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code, rec.Body.Bytes()
+	}
+	getTileJSON := func(path string) map[string]interface{} {
+		status, body := get(path)
+		var tileJSON map[string]interface{}
+		if err := json.Unmarshal(body, &tileJSON); status != 200 || err != nil {
+			t.Fatal("Could not get TileJSON:", path, status, err)
+		}
+		return tileJSON
+	}
+
+	if status, _ := get("/services/vector/tilestats"); status != 503 {
+		t.Error("Unexpected status code while computing tilestats:", status, "expected:", 503)
+	}
+	if _, ok := getTileJSON("/services/vector")["tilestats"]; ok {
+		t.Error("Expected no tilestats in TileJSON while computing tilestats")
+	}
+
+	<-svc.tileStatsSem
+	waitTileStats(t, svc, "vector")
+
+	status, body := get("/services/vector/tilestats")
+	expected := `{"layerCount":1,"layers":[{"layer":"data","count":2,"geometry":"Point","attributeCount":2,"attributes":[` +
+		`{"attribute":"class","count":1,"type":"string","values":["primary"]},` +
+		`{"attribute":"rank","count":1,"type":"number","values":[3],"min":3,"max":3}]}]}`
+	if status != 200 || string(body) != expected {
+		t.Errorf("Unexpected tilestats response: %d\n%s\nexpected:\n%s", status, body, expected)
+	}
+
+	if status, body = get("/services/vector/tilestats?layers=foo"); status != 200 || string(body) != `{"layerCount":0,"layers":[]}` {
+		t.Error("Unexpected filtered tilestats response:", status, string(body))
+	}
+
+	stats, ok := getTileJSON("/services/vector")["tilestats"].(map[string]interface{})
+	if !ok || stats["layerCount"] != float64(1) {
+		t.Error("Expected tilestats in TileJSON:", stats)
+	}
+	stats, ok = getTileJSON("/services/vector?layers=foo")["tilestats"].(map[string]interface{})
+	if !ok || stats["layerCount"] != float64(0) {
+		t.Error("Expected filtered tilestats in TileJSON:", stats)
+	}
+
+	if status, _ := get("/services/geography-class-png/tilestats"); status != 400 {
+		t.Error("Unexpected status code for image tileset:", status, "expected:", 400)
+	}
+	if id := svc.IDFromURLPath("/services/vector/tilestats"); id != "vector" {
+		t.Error("Unexpected ID from URL path:", id)
+	}
+
+	// tilestats are computed again when the tileset is reloaded
+	if err := svc.UpdateTileset("vector"); err != nil {
+		t.Fatal("Could not update tileset:", err)
+	}
+	waitTileStats(t, svc, "vector")
+	if status, _ := get("/services/vector/tilestats"); status != 200 {
+		t.Error("Unexpected status code after reload:", status, "expected:", 200)
+	}
+
+	// the scan is canceled when the tileset is removed
+	ts := svc.tilesets["vector"]
+	svc.tileStatsSem <- struct{}{}
+	ts.startTileStats()
+	svc.RemoveTileset("vector")
+	<-svc.tileStatsSem
+	if stats, ok, _ := ts.getTileStats(); ok || stats != nil {
+		t.Error("Expected no tilestats after tileset was removed:", stats)
+	}
+
+	// tilestats are not available unless enabled
+	svc = newTestServiceSet(t, &ServiceSetConfig{EnableTileJSON: true}, "world_cities")
+	if status, _ := get("/services/world_cities/tilestats"); status != 404 {
+		t.Error("Unexpected status code when disabled:", status, "expected:", 404)
+	}
+	if svc.tilesets["world_cities"].tileStats != nil {
+		t.Error("Expected tilestats not to be computed when disabled")
+	}
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// root of TMS is at /tms
+// the TileMapService is served at /tms/1.0.0 and tilesets at /tms/1.0.0/<id>
+
+const TMSRoot = "/tms"
+const TMSServiceRoot = TMSRoot + "/1.0.0"
+
+const tmsSRS = "EPSG:3857"
+const tmsProfile = "global-mercator"
+
+type tmsServices struct {
+	XMLName         xml.Name               `xml:"Services"`
+	TileMapServices []tmsTileMapServiceRef `xml:"TileMapService"`
+}
+
+type tmsTileMapServiceRef struct {
+	Title   string `xml:"title,attr"`
+	Version string `xml:"version,attr"`
+	Href    string `xml:"href,attr"`
+}
+
+type tmsTileMapService struct {
+	XMLName  xml.Name        `xml:"TileMapService"`
+	Version  string          `xml:"version,attr"`
+	Services string          `xml:"services,attr"`
+	Title    string          `xml:"Title"`
+	Abstract string          `xml:"Abstract"`
+	TileMaps []tmsTileMapRef `xml:"TileMaps>TileMap"`
+}
+
+type tmsTileMapRef struct {
+	Title   string `xml:"title,attr"`
+	SRS     string `xml:"srs,attr"`
+	Profile string `xml:"profile,attr"`
+	Href    string `xml:"href,attr"`
+}
+
+type tmsTileMap struct {
+	XMLName        xml.Name       `xml:"TileMap"`
+	Version        string         `xml:"version,attr"`
+	TileMapService string         `xml:"tilemapservice,attr"`
+	Title          string         `xml:"Title"`
+	Abstract       string         `xml:"Abstract"`
+	SRS            string         `xml:"SRS"`
+	BoundingBox    tmsBoundingBox `xml:"BoundingBox"`
+	Origin         tmsOrigin      `xml:"Origin"`
+	TileFormat     tmsTileFormat  `xml:"TileFormat"`
+	TileSets       tmsTileSets    `xml:"TileSets"`
+}
+
+// coordinates are formatted as strings to avoid exponential notation
+
+type tmsBoundingBox struct {
+	MinX string `xml:"minx,attr"`
+	MinY string `xml:"miny,attr"`
+	MaxX string `xml:"maxx,attr"`
+	MaxY string `xml:"maxy,attr"`
+}
+
+type tmsOrigin struct {
+	X string `xml:"x,attr"`
+	Y string `xml:"y,attr"`
+}
+
+type tmsTileFormat struct {
+	Width     uint32 `xml:"width,attr"`
+	Height    uint32 `xml:"height,attr"`
+	MimeType  string `xml:"mime-type,attr"`
+	Extension string `xml:"extension,attr"`
+}
+
+type tmsTileSets struct {
+	Profile  string       `xml:"profile,attr"`
+	TileSets []tmsTileSet `xml:"TileSet"`
+}
+
+type tmsTileSet struct {
+	Href          string `xml:"href,attr"`
+	UnitsPerPixel string `xml:"units-per-pixel,attr"`
+	Order         int    `xml:"order,attr"`
+}
+
+// formatFloat formats v without exponential notation
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// writeXML writes v to w as an XML document, or a 304 Not Modified response
+// if the client already has the current version of the document.
+func writeXML(w http.ResponseWriter, r *http.Request, v interface{}, modTime time.Time) error {
+	bytes, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	bytes = append([]byte(xml.Header), bytes...)
+	w.Header().Set("Content-Type", "application/xml")
+	if checkNotModified(w, r, contentETag(bytes), modTime) {
+		return nil
+	}
+	_, err = w.Write(bytes)
+	return err
+}
+
+// tmsRootHandler is an http.HandlerFunc that returns the TMS root resource,
+// which lists the TileMapService.
+func (s *ServiceSet) tmsRootHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != TMSRoot && r.URL.Path != TMSRoot+"/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	rootURL := fmt.Sprintf("%s://%s", scheme(r), getRequestHost(r))
+	services := tmsServices{
+		TileMapServices: []tmsTileMapServiceRef{{
+			Title:   "mbtileserver",
+			Version: "1.0.0",
+			Href:    rootURL + TMSServiceRoot + "/",
+		}},
+	}
+
+	setCacheControl(w, s.cacheControl.ServiceList)
+	if err := writeXML(w, r, services, time.Time{}); err != nil {
+		s.logError("Could not write TMS root content for %v: %v", r.URL.Path, err)
+	}
+}
+
+// tmsServiceHandler is an http.HandlerFunc that returns the TMS
+// TileMapService resource, which lists all tilesets in the ServiceSet.
+// Requests for individual tilesets are routed to the tileset.
+func (s *ServiceSet) tmsServiceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != TMSServiceRoot && r.URL.Path != TMSServiceRoot+"/" {
+		s.tilesetHandler(w, r)
+		return
+	}
+
+	rootURL := fmt.Sprintf("%s://%s", scheme(r), getRequestHost(r))
+	service := tmsTileMapService{
+		Version:  "1.0.0",
+		Services: rootURL + TMSRoot + "/",
+		Title:    "mbtileserver",
+		TileMaps: []tmsTileMapRef{},
+	}
+
+	// sort ids alpabetically
+	var ids []string
+	for id := range s.tilesets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var modTime time.Time
+	for _, id := range ids {
+		ts := s.tilesets[id]
+		if ts.db == nil || !ts.published {
+			continue
+		}
+		if ts.db.GetTimestamp().After(modTime) {
+			modTime = ts.db.GetTimestamp()
+		}
+		service.TileMaps = append(service.TileMaps, tmsTileMapRef{
+			Title:   ts.name,
+			SRS:     tmsSRS,
+			Profile: tmsProfile,
+			Href:    rootURL + TMSServiceRoot + "/" + id,
+		})
+	}
+
+	setCacheControl(w, s.cacheControl.ServiceList)
+	if err := writeXML(w, r, service, modTime); err != nil {
+		s.logError("Could not write TMS TileMapService content for %v: %v", r.URL.Path, err)
+	}
+}
+
+// tmsTileMap returns the TMS TileMap resource for the tileset, which
+// describes its bounds, origin, tile format, and the TileSets for each zoom
+// level.  serviceURL is the URL of the TileMapService.
+func (ts *Tileset) tmsTileMap(serviceURL string) (*tmsTileMap, error) {
+	metadata, err := ts.db.ReadMetadata()
+	if err != nil {
+		return nil, err
+	}
+	description, _ := metadata["description"].(string)
+
+	bounds := ts.bounds[:]
+	xmin, ymin := lonLatToMercator(bounds[0], bounds[1])
+	xmax, ymax := lonLatToMercator(bounds[2], bounds[3])
+
+	tilesize := ts.tilesize
+	if tilesize == 0 {
+		tilesize = tileSize256
+	}
+
+	tileMapURL := serviceURL + ts.id
+	tileSets := []tmsTileSet{}
+	for z := ts.minzoom; z <= ts.availableMaxZoom(); z++ {
+		tileSets = append(tileSets, tmsTileSet{
+			Href:          fmt.Sprintf("%s/%d", tileMapURL, z),
+			UnitsPerPixel: formatFloat(2 * earthCircumference / float64(tilesize) / math.Exp2(float64(z))),
+			Order:         z,
+		})
+	}
+
+	return &tmsTileMap{
+		Version:        "1.0.0",
+		TileMapService: serviceURL,
+		Title:          ts.name,
+		Abstract:       description,
+		SRS:            tmsSRS,
+		BoundingBox: tmsBoundingBox{
+			MinX: formatFloat(xmin),
+			MinY: formatFloat(ymin),
+			MaxX: formatFloat(xmax),
+			MaxY: formatFloat(ymax),
+		},
+		Origin: tmsOrigin{
+			X: formatFloat(-earthCircumference),
+			Y: formatFloat(-earthCircumference),
+		},
+		TileFormat: tmsTileFormat{
+			Width:     tilesize,
+			Height:    tilesize,
+			MimeType:  ts.tileformat.MimeType(),
+			Extension: ts.tileformat.String(),
+		},
+		TileSets: tmsTileSets{
+			Profile:  tmsProfile,
+			TileSets: tileSets,
+		},
+	}, nil
+}
+
+// tmsTileMapHandler is an http.HandlerFunc for the TMS TileMap resource of
+// the tileset
+func (ts *Tileset) tmsTileMapHandler(w http.ResponseWriter, r *http.Request) {
+	if ts == nil || !ts.published {
+		http.NotFound(w, r)
+		return
+	}
+
+	// wait up to 30 seconds to see if tileset is ready and return it if possible
+	if ts.isLockedWithTimeout(30 * time.Second) {
+		tilesetLockedHandler(w, r)
+		return
+	}
+
+	serviceURL := fmt.Sprintf("%s://%s%s/", scheme(r), getRequestHost(r), TMSServiceRoot)
+	tileMap, err := ts.tmsTileMap(serviceURL)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		ts.svc.logError("Could not create TMS TileMap content for %v: %v", r.URL.Path, err)
+		return
+	}
+
+	setCacheControl(w, ts.cacheControl.TileJSON)
+	if err = writeXML(w, r, tileMap, ts.db.GetTimestamp()); err != nil {
+		ts.svc.logError("Could not write TMS TileMap content for %v: %v", r.URL.Path, err)
+	}
+}
+
+// tmsTileHandler is an http.HandlerFunc for TMS tiles of the tileset, which
+// use the same row order as the mbtiles file (y = 0 is the southernmost row).
+func (ts *Tileset) tmsTileHandler(w http.ResponseWriter, r *http.Request) {
+	if ts == nil || !ts.published {
+		tileNotFoundHandler(w, r, ts.tileformat, ts.tilesize, ts.svc.returnMissingImageTile404)
+		return
+	}
+
+	// wait up to 30 seconds to see if tileset is ready and return it if possible
+	if ts.isLockedWithTimeout(30 * time.Second) {
+		tilesetLockedHandler(w, r)
+		return
+	}
+
+	// strip off /tms/1.0.0/<id>/ and then we should have <z>, <x>, <y plus .ext>
+	pcs := strings.Split(strings.TrimPrefix(r.URL.Path, TMSServiceRoot+"/"+ts.id+"/"), "/")
+	if len(pcs) != 3 || pcs[2] == "" {
+		http.Error(w, "requested path is too short", http.StatusBadRequest)
+		return
+	}
+
+	tc, ext, size, err := parseTileRequest(r, pcs[0], pcs[1], pcs[2])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// flip y to XYZ
+	tc.y = (1 << uint64(tc.z)) - 1 - tc.y
+
+	ts.writeTile(w, r, tc, ext, size)
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+)
+
+// newTestServiceSet returns a new ServiceSet with tilesets from testdata,
+// which are closed when the test completes.
+func newTestServiceSet(t *testing.T, cfg *ServiceSetConfig, ids ...string) *ServiceSet {
+	cfg.RootURL = &url.URL{Path: "/services"}
+	svc, err := New(cfg)
+	if err != nil {
+		t.Fatal("Could not create ServiceSet:", err)
+	}
+	t.Cleanup(func() {
+		for id := range svc.tilesets {
+			svc.RemoveTileset(id)
+		}
+	})
+	for _, id := range ids {
+		// tilesets in subdirectories use the same file as the tileset with
+		// the base name of the ID
+		filename := "../testdata/" + path.Base(id) + ".mbtiles"
+		if err = svc.AddTileset(filename, id); err != nil {
+			t.Fatal("Could not add tileset:", id, err)
+		}
+	}
+	return svc
+}
+
+func Test_TMSIDFromURLPath(t *testing.T) {
+	svc := newTestServiceSet(t, &ServiceSetConfig{EnableTMS: true}, "geography-class-png", "world_cities", "nested/world_cities")
+
+	tests := []struct {
+		path string
+		id   string
+	}{
+		{path: "/tms/1.0.0/geography-class-png", id: "geography-class-png"},
+		{path: "/tms/1.0.0/geography-class-png/0/0/0.png", id: "geography-class-png"},
+		{path: "/tms/1.0.0/nested/world_cities", id: "nested/world_cities"},
+		{path: "/tms/1.0.0/nested/world_cities/1/0/1.pbf", id: "nested/world_cities"},
+		{path: "/tms/1.0.0/geography-class-png/0/0", id: ""},
+		{path: "/tms/1.0.0/foo/0/0/0.png", id: ""},
+		{path: "/tms/1.0.0", id: ""},
+	}
+
+	for _, tc := range tests {
+		if id := svc.IDFromURLPath(tc.path); id != tc.id {
+			t.Error("IDFromURLPath returned unexpected ID for:", tc.path, id, "expected:", tc.id)
+		}
+	}
+}
+
+func Test_TMSHandlers(t *testing.T) {
+	svc := newTestServiceSet(t, &ServiceSetConfig{EnableTMS: true}, "geography-class-png", "world_cities", "nested/world_cities")
+	handler := svc.Handler()
+
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+		contains    []string
+	}{
+		{path: "/tms", status: 200, contentType: "application/xml", contains: []string{`href="http://example.com/tms/1.0.0/"`}},
+		{path: "/tms/1.0.0", status: 200, contentType: "application/xml", contains: []string{
+			`href="http://example.com/tms/1.0.0/geography-class-png"`,
+			`href="http://example.com/tms/1.0.0/nested/world_cities"`,
+		}},
+		{path: "/tms/1.0.0/geography-class-png", status: 200, contentType: "application/xml", contains: []string{
+			`<SRS>EPSG:3857</SRS>`,
+			`<Origin x="-20037508.342789244" y="-20037508.342789244">`,
+			`<TileFormat width="256" height="256" mime-type="image/png" extension="png">`,
+			`href="http://example.com/tms/1.0.0/geography-class-png/1" units-per-pixel="78271.51696402048" order="1"`,
+		}},
+		{path: "/tms/1.0.0/geography-class-png/1/0/1.png", status: 200, contentType: "image/png"},
+		{path: "/tms/1.0.0/nested/world_cities/1/0/1.pbf", status: 200, contentType: "application/x-protobuf"},
+		{path: "/tms/1.0.0/geography-class-png/0/1/1.png", status: 400},
+		{path: "/tms/1.0.0/geography-class-png/-1/0/0.png", status: 400},
+		{path: "/tms/1.0.0/foo", status: 404},
+	}
+
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != tc.status {
+			t.Error("Unexpected status code for:", tc.path, w.Code, "expected:", tc.status)
+			continue
+		}
+		if tc.contentType != "" && w.Header().Get("Content-Type") != tc.contentType {
+			t.Error("Unexpected content type for:", tc.path, w.Header().Get("Content-Type"), "expected:", tc.contentType)
+			continue
+		}
+		for _, s := range tc.contains {
+			if !strings.Contains(w.Body.String(), s) {
+				t.Errorf("Response for %s does not contain %q:\n%s", tc.path, s, w.Body.String())
+			}
+		}
+	}
+
+	// unpublished tilesets are not listed
+	svc.tilesets["geography-class-png"].published = false
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/tms/1.0.0/geography-class-png", nil))
+	if w.Code != 404 {
+		t.Error("Unexpected status code for TileMap of unpublished tileset:", w.Code, "expected:", 404)
+	}
+}
+
+func Test_HandlerRootURL(t *testing.T) {
+	// the root URL can be the path of disabled endpoints
+	for _, root := range []string{TMSRoot, WMTSRoot, OGCAPIRoot, WMSRoot, StylesRoot, FontsRoot} {
+		svc, err := New(&ServiceSetConfig{RootURL: &url.URL{Path: root}})
+		if err != nil {
+			t.Fatal("Could not create ServiceSet:", err)
+		}
+		if err = svc.AddTileset("../testdata/world_cities.mbtiles", "world_cities"); err != nil {
+			t.Fatal("Could not add tileset:", err)
+		}
+
+		w := httptest.NewRecorder()
+		svc.Handler().ServeHTTP(w, httptest.NewRequest("GET", root+"/world_cities/tiles/0/0/0.pbf", nil))
+		if w.Code != 200 {
+			t.Error("Unexpected status code for tile at root URL:", root, w.Code, "expected:", 200)
+		}
+		svc.RemoveTileset("world_cities")
+	}
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/project"
+	"github.com/paulmach/protoscan"
+)
+
+// vectorTileBuffer is the buffer in tile coordinate units (at 4096 extent)
+// that geometries are clipped to around tiles created from other tiles, to
+// avoid rendering artifacts along tile edges.
+const vectorTileBuffer = 64
+
+// Field numbers of the vector tile protobuf messages
+const (
+	tileFieldLayers    = 3 // tile.layers
+	layerFieldFeatures = 2 // layer.features
+	featureFieldID     = 1 // feature.id
+)
+
+// decodeVectorTile decodes vector tile data that may be compressed using gzip
+// or zlib into layers.  Geometries are in tile coordinates.  Feature IDs are
+// uint64 and attribute values that are numbers are float64.
+func decodeVectorTile(data []byte) (mvt.Layers, error) {
+	raw, err := recode(data, detectEncoding(data), encodingIdentity)
+	if err != nil {
+		return nil, err
+	}
+	layers, err := mvt.Unmarshal(raw)
+	if err == nil {
+		err = decodeFeatureIDs(raw, layers)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not decode vector tile: %v", err)
+	}
+	return layers, nil
+}
+
+// decodeFeatureIDs sets the IDs of the features of layers decoded from raw
+// vector tile data to their exact uint64 values, since mvt.Unmarshal decodes
+// them as float64, which cannot represent IDs above 2^53.
+func decodeFeatureIDs(raw []byte, layers mvt.Layers) error {
+	msg := protoscan.New(raw)
+	for i := 0; msg.Next(); {
+		if msg.FieldNumber() != tileFieldLayers {
+			msg.Skip()
+			continue
+		}
+		layerData, err := msg.MessageData()
+		if err != nil {
+			return err
+		}
+		if i >= len(layers) {
+			break
+		}
+		features := layers[i].Features
+		i++
+
+		layer := protoscan.New(layerData)
+		for j := 0; layer.Next(); {
+			if layer.FieldNumber() != layerFieldFeatures {
+				layer.Skip()
+				continue
+			}
+			featureData, err := layer.MessageData()
+			if err != nil {
+				return err
+			}
+			if j >= len(features) {
+				break
+			}
+			f := features[j]
+			j++
+
+			feature := protoscan.New(featureData)
+			for feature.Next() {
+				if feature.FieldNumber() != featureFieldID {
+					feature.Skip()
+					continue
+				}
+				id, err := feature.Uint64()
+				if err != nil {
+					return err
+				}
+				f.ID = id
+				break
+			}
+			if feature.Err() != nil {
+				return feature.Err()
+			}
+		}
+		if layer.Err() != nil {
+			return layer.Err()
+		}
+	}
+	return msg.Err()
+}
+
+// encodeVectorTile encodes layers into uncompressed vector tile data.
+// Attribute values that are integral float64 numbers, as decoded from integer
+// values by decodeVectorTile, are encoded as integers rather than doubles.
+func encodeVectorTile(layers mvt.Layers) ([]byte, error) {
+	out := make(mvt.Layers, len(layers))
+	for i, layer := range layers {
+		l := *layer
+		l.Features = make([]*geojson.Feature, len(layer.Features))
+		for j, f := range layer.Features {
+			if properties, ok := integerProperties(f.Properties); ok {
+				copied := *f
+				copied.Properties = properties
+				f = &copied
+			}
+			l.Features[j] = f
+		}
+		out[i] = &l
+	}
+
+	data, err := mvt.Marshal(out)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode vector tile: %v", err)
+	}
+	return data, nil
+}
+
+// integerProperties returns a copy of properties where integral float64
+// values are converted to int64 if negative or uint64 otherwise.  Returns
+// false if there are no such values.
+func integerProperties(properties geojson.Properties) (geojson.Properties, bool) {
+	var out geojson.Properties
+	for k, v := range properties {
+		n, ok := v.(float64)
+		if !ok || math.IsInf(n, 0) || n != math.Trunc(n) || n < -(1<<63) || n >= 1<<64 {
+			continue
+		}
+		if out == nil {
+			out = properties.Clone()
+		}
+		if n < 0 {
+			out[k] = int64(n)
+		} else {
+			out[k] = uint64(n)
+		}
+	}
+	return out, out != nil
+}
+
+// overzoomLayers transforms the layers of an ancestor tile into those of a
+// descendant tile dz zoom levels below it at offset (dx, dy) in tiles from
+// the first descendant of the ancestor at that zoom level.  Geometries are
+// scaled to the default extent of 4096 and clipped to the descendant tile
+// plus a small buffer; features that fall outside are removed.
+func overzoomLayers(layers mvt.Layers, dz uint64, dx, dy int64) {
+	n := float64(uint64(1) << dz)
+	for _, layer := range layers {
+		extent := float64(layer.Extent)
+		if extent == 0 {
+			extent = mvt.DefaultExtent
+		}
+		span := extent / n
+		x0, y0 := float64(dx)*span, float64(dy)*span
+		k := mvt.DefaultExtent / span
+
+		transform := func(p orb.Point) orb.Point {
+			return orb.Point{(p[0] - x0) * k, (p[1] - y0) * k}
+		}
+		for _, f := range layer.Features {
+			f.Geometry = project.Geometry(f.Geometry, transform)
+		}
+
+		layer.Extent = mvt.DefaultExtent
+		layer.Clip(orb.Bound{
+			Min: orb.Point{-vectorTileBuffer, -vectorTileBuffer},
+			Max: orb.Point{mvt.DefaultExtent + vectorTileBuffer, mvt.DefaultExtent + vectorTileBuffer},
+		})
+	}
+}
+
+// projectLayerToWGS84 projects the geometries of layer from the coordinates of
+// XYZ tile tc to longitude and latitude.
+func projectLayerToWGS84(layer *mvt.Layer, tc tileCoord) {
+	extent := float64(layer.Extent)
+	if extent == 0 {
+		extent = mvt.DefaultExtent
+	}
+	x0, y0 := float64(tc.x), float64(tc.y)
+	transform := func(p orb.Point) orb.Point {
+		lon, lat := tileToLonLat(x0+p[0]/extent, y0+p[1]/extent, int(tc.z))
+		return orb.Point{lon, lat}
+	}
+	for _, f := range layer.Features {
+		f.Geometry = project.Geometry(f.Geometry, transform)
+	}
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/encoding/mvt/vectortile"
+	"github.com/paulmach/orb/geojson"
+)
+
+// tileValueTypes returns the ID of the first feature of the first layer of
+// uncompressed vector tile data and the protobuf types of its attribute
+// values, keyed by attribute name.
+func tileValueTypes(t *testing.T, data []byte) (uint64, map[string]string) {
+	tile := &vectortile.Tile{}
+	if err := tile.Unmarshal(data); err != nil {
+		t.Fatal("Could not unmarshal vector tile:", err)
+	}
+	if len(tile.Layers) == 0 || len(tile.Layers[0].Features) == 0 {
+		t.Fatal("Expected features in vector tile")
+	}
+	layer := tile.Layers[0]
+	f := layer.Features[0]
+
+	types := make(map[string]string)
+	for i := 0; i+1 < len(f.Tags); i += 2 {
+		v := layer.Values[f.Tags[i+1]]
+		switch {
+		case v.StringValue != nil:
+			types[layer.Keys[f.Tags[i]]] = "string"
+		case v.FloatValue != nil, v.DoubleValue != nil:
+			types[layer.Keys[f.Tags[i]]] = "double"
+		case v.IntValue != nil, v.SintValue != nil:
+			types[layer.Keys[f.Tags[i]]] = "int"
+		case v.UintValue != nil:
+			types[layer.Keys[f.Tags[i]]] = "uint"
+		case v.BoolValue != nil:
+			types[layer.Keys[f.Tags[i]]] = "bool"
+		}
+	}
+	return f.GetId(), types
+}
+
+func Test_OverzoomLayers(t *testing.T) {
+	newLayers := func() mvt.Layers {
+		fc := geojson.NewFeatureCollection()
+		fc.Append(geojson.NewFeature(orb.Point{100, 100}))
+		fc.Append(geojson.NewFeature(orb.Point{3000, 1000}))
+		fc.Append(geojson.NewFeature(orb.LineString{{0, 10}, {4096, 10}}))
+		layer := mvt.NewLayer("test", fc)
+		layer.Extent = 4096
+		return mvt.Layers{layer}
+	}
+
+	tests := []struct {
+		dz       uint64
+		dx, dy   int64
+		features int
+		point    orb.Point
+	}{
+		// top left quadrant: first point and line
+		{dz: 1, dx: 0, dy: 0, features: 2, point: orb.Point{200, 200}},
+		// top right quadrant: second point and line
+		{dz: 1, dx: 1, dy: 0, features: 2, point: orb.Point{1904, 2000}},
+		// bottom left quadrant: nothing
+		{dz: 1, dx: 0, dy: 1, features: 0},
+		// two zoom levels down, second column
+		{dz: 2, dx: 2, dy: 0, features: 2, point: orb.Point{3808, 4000}},
+	}
+
+	for _, tc := range tests {
+		layers := newLayers()
+		overzoomLayers(layers, tc.dz, tc.dx, tc.dy)
+
+		features := layers[0].Features
+		if len(features) != tc.features {
+			t.Error("overzoomLayers returned unexpected number of features for", tc.dz, tc.dx, tc.dy, ":", len(features), "expected:", tc.features)
+			continue
+		}
+		if tc.features == 0 {
+			continue
+		}
+		if p, ok := features[0].Geometry.(orb.Point); !ok || !p.Equal(tc.point) {
+			t.Error("overzoomLayers returned unexpected point for", tc.dz, tc.dx, tc.dy, ":", features[0].Geometry, "expected:", tc.point)
+			continue
+		}
+		if b := features[1].Geometry.Bound(); b.Min[0] < -vectorTileBuffer || b.Max[0] > mvt.DefaultExtent+vectorTileBuffer {
+			t.Error("overzoomLayers did not clip line to tile:", b)
+		}
+	}
+}
+
+func Test_EncodeVectorTile_Integers(t *testing.T) {
+	f := geojson.NewFeature(orb.Point{100, 100})
+	f.ID = uint64(1<<60 + 1)
+	f.Properties = geojson.Properties{
+		"negative": int64(-3),
+		"positive": uint64(7),
+		"large":    uint64(1 << 62),
+		"double":   1.5,
+		"name":     "a",
+		"flag":     true,
+	}
+	fc := geojson.NewFeatureCollection()
+	fc.Append(f)
+	data, err := encodeVectorTile(mvt.Layers{mvt.NewLayer("test", fc)})
+	if err != nil {
+		t.Fatal("Could not encode vector tile:", err)
+	}
+
+	// integers are decoded as float64 and must be encoded as integers again
+	layers, err := decodeVectorTile(data)
+	if err != nil {
+		t.Fatal("Could not decode vector tile:", err)
+	}
+	decoded := layers[0].Features[0]
+	if decoded.ID != uint64(1<<60+1) {
+		t.Errorf("Unexpected decoded feature ID: %v (%T)", decoded.ID, decoded.ID)
+	}
+	if decoded.Properties["negative"] != float64(-3) {
+		t.Errorf("Unexpected decoded value: %v (%T)", decoded.Properties["negative"], decoded.Properties["negative"])
+	}
+
+	data, err = encodeVectorTile(layers)
+	if err != nil {
+		t.Fatal("Could not encode vector tile:", err)
+	}
+	id, types := tileValueTypes(t, data)
+	if id != 1<<60+1 {
+		t.Error("Unexpected feature ID:", id, "expected:", uint64(1<<60+1))
+	}
+	expected := map[string]string{"negative": "int", "positive": "uint", "large": "uint", "double": "double", "name": "string", "flag": "bool"}
+	if !reflect.DeepEqual(types, expected) {
+		t.Error("Unexpected value types:", types, "expected:", expected)
+	}
+
+	// the properties of the layers are not changed
+	if decoded.Properties["positive"] != float64(7) {
+		t.Errorf("Unexpected value after encoding: %v (%T)", decoded.Properties["positive"], decoded.Properties["positive"])
+	}
+}
+
+func Test_DecodeVectorTile(t *testing.T) {
+	fc := geojson.NewFeatureCollection()
+	fc.Append(geojson.NewFeature(orb.Point{100, 100}))
+	data, err := encodeVectorTile(mvt.Layers{mvt.NewLayer("test", fc)})
+	if err != nil {
+		t.Fatal("Could not encode vector tile:", err)
+	}
+
+	for _, encoding := range []string{encodingIdentity, encodingGzip, encodingDeflate} {
+		encoded, err := recode(data, encodingIdentity, encoding)
+		if err != nil {
+			t.Error("Could not encode vector tile using:", encoding, err)
+			continue
+		}
+		layers, err := decodeVectorTile(encoded)
+		if err != nil {
+			t.Error("Could not decode vector tile encoded using:", encoding, err)
+			continue
+		}
+		if len(layers) != 1 || layers[0].Name != "test" || len(layers[0].Features) != 1 {
+			t.Error("decodeVectorTile returned unexpected layers for encoding:", encoding)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	mbtiles "github.com/brendan-ward/mbtiles-go"
+)
+
+// root of WMS is at /wms, which handles KVP requests
+
+const WMSRoot = "/wms"
+
+const (
+	wmsVersion = "1.3.0"
+	wmsStyle   = "default"
+
+	wmsCRS3857 = "EPSG:3857"
+	wmsCRS4326 = "EPSG:4326"
+	wmsCRS84   = "CRS:84"
+)
+
+// wmsFormats lists the image formats supported for GetMap
+var wmsFormats = []mbtiles.TileFormat{mbtiles.PNG, mbtiles.JPG}
+
+type wmsCapabilities struct {
+	XMLName    xml.Name      `xml:"WMS_Capabilities"`
+	Xmlns      string        `xml:"xmlns,attr"`
+	XmlnsXlink string        `xml:"xmlns:xlink,attr"`
+	Version    string        `xml:"version,attr"`
+	Service    wmsService    `xml:"Service"`
+	Capability wmsCapability `xml:"Capability"`
+}
+
+type wmsService struct {
+	Name           string  `xml:"Name"`
+	Title          string  `xml:"Title"`
+	OnlineResource wmsLink `xml:"OnlineResource"`
+	MaxWidth       int     `xml:"MaxWidth"`
+	MaxHeight      int     `xml:"MaxHeight"`
+}
+
+type wmsLink struct {
+	Type string `xml:"xlink:type,attr"`
+	Href string `xml:"xlink:href,attr"`
+}
+
+type wmsCapability struct {
+	GetCapabilities wmsOperation `xml:"Request>GetCapabilities"`
+	GetMap          wmsOperation `xml:"Request>GetMap"`
+	Exception       []string     `xml:"Exception>Format"`
+	Layer           wmsLayer     `xml:"Layer"`
+}
+
+type wmsOperation struct {
+	Formats        []string `xml:"Format"`
+	OnlineResource wmsLink  `xml:"DCPType>HTTP>Get>OnlineResource"`
+}
+
+type wmsLayer struct {
+	Queryable      int                      `xml:"queryable,attr"`
+	Opaque         int                      `xml:"opaque,attr"`
+	Name           string                   `xml:"Name,omitempty"`
+	Title          string                   `xml:"Title"`
+	Abstract       string                   `xml:"Abstract,omitempty"`
+	CRS            []string                 `xml:"CRS"`
+	GeographicBBox wmsGeographicBoundingBox `xml:"EX_GeographicBoundingBox"`
+	BoundingBoxes  []wmsBoundingBox         `xml:"BoundingBox"`
+	Styles         []wmsStyleEntry          `xml:"Style"`
+	Layers         []wmsLayer               `xml:"Layer"`
+}
+
+type wmsGeographicBoundingBox struct {
+	West  string `xml:"westBoundLongitude"`
+	East  string `xml:"eastBoundLongitude"`
+	South string `xml:"southBoundLatitude"`
+	North string `xml:"northBoundLatitude"`
+}
+
+type wmsBoundingBox struct {
+	CRS  string `xml:"CRS,attr"`
+	MinX string `xml:"minx,attr"`
+	MinY string `xml:"miny,attr"`
+	MaxX string `xml:"maxx,attr"`
+	MaxY string `xml:"maxy,attr"`
+}
+
+type wmsStyleEntry struct {
+	Name  string `xml:"Name"`
+	Title string `xml:"Title"`
+}
+
+type wmsExceptionReport struct {
+	XMLName   xml.Name     `xml:"ServiceExceptionReport"`
+	Xmlns     string       `xml:"xmlns,attr"`
+	Version   string       `xml:"version,attr"`
+	Exception wmsException `xml:"ServiceException"`
+}
+
+type wmsException struct {
+	Code string `xml:"code,attr,omitempty"`
+	Text string `xml:",chardata"`
+}
+
+// wmsError writes a WMS service exception report to w
+func wmsError(w http.ResponseWriter, status int, code, text string) {
+	bytes, _ := xml.MarshalIndent(wmsExceptionReport{
+		Xmlns:     "http://www.opengis.net/ogc",
+		Version:   wmsVersion,
+		Exception: wmsException{Code: code, Text: text},
+	}, "", "  ")
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(status)
+	w.Write(append([]byte(xml.Header), bytes...))
+}
+
+// wmsLayer returns the WMS layer describing the tileset
+func (ts *Tileset) wmsLayer() (*wmsLayer, error) {
+	metadata, err := ts.db.ReadMetadata()
+	if err != nil {
+		return nil, err
+	}
+	description, _ := metadata["description"].(string)
+
+	bounds := ts.bounds[:]
+	xmin, ymin := lonLatToMercator(bounds[0], bounds[1])
+	xmax, ymax := lonLatToMercator(bounds[2], bounds[3])
+
+	return &wmsLayer{
+		Name:     ts.id,
+		Title:    ts.name,
+		Abstract: description,
+		GeographicBBox: wmsGeographicBoundingBox{
+			West:  formatFloat(bounds[0]),
+			East:  formatFloat(bounds[2]),
+			South: formatFloat(bounds[1]),
+			North: formatFloat(bounds[3]),
+		},
+		BoundingBoxes: []wmsBoundingBox{
+			{CRS: wmsCRS3857, MinX: formatFloat(xmin), MinY: formatFloat(ymin), MaxX: formatFloat(xmax), MaxY: formatFloat(ymax)},
+			// EPSG:4326 uses latitude, longitude axis order in WMS 1.3.0
+			{CRS: wmsCRS4326, MinX: formatFloat(bounds[1]), MinY: formatFloat(bounds[0]), MaxX: formatFloat(bounds[3]), MaxY: formatFloat(bounds[2])},
+			{CRS: wmsCRS84, MinX: formatFloat(bounds[0]), MinY: formatFloat(bounds[1]), MaxX: formatFloat(bounds[2]), MaxY: formatFloat(bounds[3])},
+		},
+		Styles: []wmsStyleEntry{{Name: wmsStyle, Title: "Default"}},
+	}, nil
+}
+
+// wmsCapabilities returns the WMS capabilities document listing each image
+// tileset in the ServiceSet as a layer.  rootURL is the scheme and host of
+// the server.  Also returns the most recent modification time of the
+// tilesets.
+func (s *ServiceSet) wmsCapabilities(rootURL string) (*wmsCapabilities, time.Time, error) {
+	link := wmsLink{Type: "simple", Href: rootURL + WMSRoot + "?"}
+
+	var formats []string
+	for _, format := range wmsFormats {
+		formats = append(formats, format.MimeType())
+	}
+
+	caps := &wmsCapabilities{
+		Xmlns:      "http://www.opengis.net/wms",
+		XmlnsXlink: "http://www.w3.org/1999/xlink",
+		Version:    wmsVersion,
+		Service: wmsService{
+			Name:           "WMS",
+			Title:          "mbtileserver",
+			OnlineResource: link,
+			MaxWidth:       maxRenderSize,
+			MaxHeight:      maxRenderSize,
+		},
+		Capability: wmsCapability{
+			GetCapabilities: wmsOperation{Formats: []string{"text/xml"}, OnlineResource: link},
+			GetMap:          wmsOperation{Formats: formats, OnlineResource: link},
+			Exception:       []string{"XML"},
+			Layer: wmsLayer{
+				Title: "mbtileserver",
+				CRS:   []string{wmsCRS3857, wmsCRS4326, wmsCRS84},
+				GeographicBBox: wmsGeographicBoundingBox{
+					West:  "-180",
+					East:  "180",
+					South: formatFloat(-maxMercatorLatitude),
+					North: formatFloat(maxMercatorLatitude),
+				},
+			},
+		},
+	}
+
+	// sort ids alpabetically
+	var ids []string
+	for id := range s.tilesets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var modTime time.Time
+	for _, id := range ids {
+		ts := s.tilesets[id]
+		if ts.db == nil || !ts.published || !isImageFormat(ts.tileformat) {
+			continue
+		}
+		layer, err := ts.wmsLayer()
+		if err != nil {
+			return nil, modTime, fmt.Errorf("could not create WMS layer for tileset %q: %v", id, err)
+		}
+		caps.Capability.Layer.Layers = append(caps.Capability.Layer.Layers, *layer)
+
+		if ts.db.GetTimestamp().After(modTime) {
+			modTime = ts.db.GetTimestamp()
+		}
+	}
+
+	return caps, modTime, nil
+}
+
+// wmsHandler is an http.HandlerFunc for WMS KVP requests
+func (s *ServiceSet) wmsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != WMSRoot && r.URL.Path != WMSRoot+"/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	if name := queryRepeated(query, "SERVICE", "REQUEST", "VERSION", "LAYERS", "STYLES", "CRS", "BBOX", "WIDTH", "HEIGHT", "FORMAT", "TRANSPARENT", "BGCOLOR"); name != "" {
+		wmsError(w, http.StatusBadRequest, "InvalidParameterValue", name+" must not be repeated")
+		return
+	}
+	if service := queryValue(query, "SERVICE"); !strings.EqualFold(service, "WMS") {
+		wmsError(w, http.StatusBadRequest, "", "SERVICE must be WMS")
+		return
+	}
+
+	switch request := queryValue(query, "REQUEST"); {
+	case strings.EqualFold(request, "GetCapabilities"):
+		rootURL := fmt.Sprintf("%s://%s", scheme(r), getRequestHost(r))
+		caps, modTime, err := s.wmsCapabilities(rootURL)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			s.logError("Could not create WMS capabilities for %v: %v", r.URL.Path, err)
+			return
+		}
+
+		setCacheControl(w, s.cacheControl.ServiceList)
+		if err = writeXML(w, r, caps, modTime); err != nil {
+			s.logError("Could not write WMS capabilities for %v: %v", r.URL.Path, err)
+		}
+
+	case strings.EqualFold(request, "GetMap"):
+		s.wmsGetMapHandler(w, r)
+
+	case request == "":
+		wmsError(w, http.StatusBadRequest, "", "REQUEST is required")
+
+	default:
+		wmsError(w, http.StatusNotImplemented, "OperationNotSupported", "REQUEST is not supported")
+	}
+}
+
+// wmsGetMapHandler writes the image for a WMS GetMap request, which
+// composites the requested layers in order.
+func (s *ServiceSet) wmsGetMapHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	for _, name := range []string{"VERSION", "LAYERS", "CRS", "BBOX", "WIDTH", "HEIGHT", "FORMAT"} {
+		if queryValue(query, name) == "" {
+			wmsError(w, http.StatusBadRequest, "MissingParameterValue", name+" is required")
+			return
+		}
+	}
+	if version := queryValue(query, "VERSION"); version != wmsVersion {
+		wmsError(w, http.StatusBadRequest, "InvalidParameterValue", "VERSION must be "+wmsVersion)
+		return
+	}
+
+	var tilesets []*Tileset
+	for _, id := range strings.Split(queryValue(query, "LAYERS"), ",") {
+		ts, ok := s.tilesets[id]
+		if !ok || ts == nil || !ts.published || !isImageFormat(ts.tileformat) {
+			wmsError(w, http.StatusBadRequest, "LayerNotDefined", fmt.Sprintf("layer %q does not exist", id))
+			return
+		}
+		tilesets = append(tilesets, ts)
+	}
+
+	if styles := queryValue(query, "STYLES"); styles != "" {
+		for _, style := range strings.Split(styles, ",") {
+			if style != "" && style != wmsStyle {
+				wmsError(w, http.StatusBadRequest, "StyleNotDefined", "style must be "+wmsStyle)
+				return
+			}
+		}
+	}
+
+	crs := strings.ToUpper(queryValue(query, "CRS"))
+	if crs != wmsCRS3857 && crs != wmsCRS4326 && crs != wmsCRS84 {
+		wmsError(w, http.StatusBadRequest, "InvalidCRS", "CRS must be one of "+strings.Join([]string{wmsCRS3857, wmsCRS4326, wmsCRS84}, ", "))
+		return
+	}
+
+	var bounds [4]float64
+	pcs := strings.Split(queryValue(query, "BBOX"), ",")
+	if len(pcs) != 4 {
+		wmsError(w, http.StatusBadRequest, "InvalidParameterValue", "BBOX must have 4 values")
+		return
+	}
+	for i, v := range pcs {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			wmsError(w, http.StatusBadRequest, "InvalidParameterValue", "BBOX must be numeric")
+			return
+		}
+		bounds[i] = f
+	}
+	if crs == wmsCRS4326 {
+		// EPSG:4326 uses latitude, longitude axis order in WMS 1.3.0
+		bounds = [4]float64{bounds[1], bounds[0], bounds[3], bounds[2]}
+	}
+	if bounds[0] >= bounds[2] || bounds[1] >= bounds[3] {
+		wmsError(w, http.StatusBadRequest, "InvalidParameterValue", "BBOX minimum values must be less than maximum values")
+		return
+	}
+
+	width, err := strconv.Atoi(queryValue(query, "WIDTH"))
+	if err != nil || width <= 0 || width > maxRenderSize {
+		wmsError(w, http.StatusBadRequest, "InvalidParameterValue", fmt.Sprintf("WIDTH must be between 1 and %d", maxRenderSize))
+		return
+	}
+	height, err := strconv.Atoi(queryValue(query, "HEIGHT"))
+	if err != nil || height <= 0 || height > maxRenderSize {
+		wmsError(w, http.StatusBadRequest, "InvalidParameterValue", fmt.Sprintf("HEIGHT must be between 1 and %d", maxRenderSize))
+		return
+	}
+
+	format, ok := tileFormatFromMimeType(queryValue(query, "FORMAT"))
+	if !ok || (format != mbtiles.PNG && format != mbtiles.JPG) {
+		wmsError(w, http.StatusBadRequest, "InvalidFormat", "FORMAT must be image/png or image/jpeg")
+		return
+	}
+
+	transparent := strings.EqualFold(queryValue(query, "TRANSPARENT"), "TRUE")
+	background := color.RGBA{255, 255, 255, 255}
+	if bg := queryValue(query, "BGCOLOR"); bg != "" {
+		c, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(bg), "0x"), 16, 32)
+		if err != nil || len(bg) != 8 {
+			wmsError(w, http.StatusBadRequest, "InvalidParameterValue", "BGCOLOR must be a hexadecimal color of the form 0xRRGGBB")
+			return
+		}
+		background = color.RGBA{uint8(c >> 16), uint8(c >> 8), uint8(c), 255}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	var modTime time.Time
+	for _, ts := range tilesets {
+		// wait up to 30 seconds to see if tileset is ready and return it if possible
+		if ts.isLockedWithTimeout(30 * time.Second) {
+			tilesetLockedHandler(w, r)
+			return
+		}
+
+		var layer *image.RGBA
+		if crs == wmsCRS3857 {
+			layer, err = ts.renderMercator(bounds, width, height)
+		} else {
+			layer, err = ts.renderGeographic(bounds, width, height)
+		}
+		if errors.Is(err, errTooManyTiles) {
+			wmsError(w, http.StatusBadRequest, "InvalidParameterValue", fmt.Sprintf("layer %q cannot be rendered at the requested scale", ts.id))
+			return
+		}
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			s.logError("Could not render WMS map for %v: %v", ts.id, err)
+			return
+		}
+		draw.Draw(img, img.Bounds(), layer, image.Point{}, draw.Over)
+
+		if ts.db.GetTimestamp().After(modTime) {
+			modTime = ts.db.GetTimestamp()
+		}
+	}
+
+	var out image.Image = img
+	if !transparent || format == mbtiles.JPG {
+		out = flatten(img, background)
+	}
+	data, format, err := encodeImage(out, format, s.jpegQuality)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.logError("Could not encode WMS map for %v: %v", r.URL.String(), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.MimeType())
+	setCacheControl(w, tilesets[0].cacheControl.Tiles)
+	if checkNotModified(w, r, contentETag(data), modTime) {
+		return
+	}
+	w.Write(data)
+}
+
+// wmsLayersFromRequest returns the layers of a WMS GetMap request, if any
+func wmsLayersFromRequest(r *http.Request) string {
+	if r.URL.Path != WMSRoot && r.URL.Path != WMSRoot+"/" {
+		return ""
+	}
+	return queryValue(r.URL.Query(), "LAYERS")
+}
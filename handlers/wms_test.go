@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"image"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_WMSHandlers(t *testing.T) {
+	svc := newTestServiceSet(t, &ServiceSetConfig{EnableWMS: true}, "geography-class-png", "geography-class-jpg", "world_cities")
+	handler := svc.Handler()
+
+	getMap := "/wms?SERVICE=WMS&REQUEST=GetMap&VERSION=1.3.0&WIDTH=256&HEIGHT=128"
+
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+		contains    []string
+	}{
+		{path: "/wms?service=WMS&request=GetCapabilities", status: 200, contentType: "application/xml", contains: []string{
+			`<Name>geography-class-png</Name>`,
+			`<Name>geography-class-jpg</Name>`,
+			`<BoundingBox CRS="EPSG:4326" minx="-85.0511" miny="-180" maxx="85.0511" maxy="180"></BoundingBox>`,
+			`<Format>image/jpeg</Format>`,
+		}},
+		{path: getMap + "&LAYERS=geography-class-png&STYLES=&CRS=EPSG:3857&BBOX=-20037508,0,20037508,20037508&FORMAT=image/png", status: 200, contentType: "image/png"},
+		{path: getMap + "&LAYERS=geography-class-png&CRS=EPSG:4326&BBOX=-90,-180,90,180&FORMAT=image/jpeg", status: 200, contentType: "image/jpeg"},
+		{path: getMap + "&LAYERS=geography-class-jpg,geography-class-png&CRS=CRS:84&BBOX=-180,-90,180,90&FORMAT=image/png&TRANSPARENT=TRUE", status: 200, contentType: "image/png"},
+		{path: getMap + "&LAYERS=world_cities&CRS=EPSG:3857&BBOX=0,0,1,1&FORMAT=image/png", status: 400, contains: []string{`code="LayerNotDefined"`}},
+		{path: getMap + "&LAYERS=geography-class-png&CRS=EPSG:2000&BBOX=0,0,1,1&FORMAT=image/png", status: 400, contains: []string{`code="InvalidCRS"`}},
+		{path: getMap + "&LAYERS=geography-class-png&CRS=EPSG:3857&BBOX=0,0,1,1&FORMAT=image/gif", status: 400, contains: []string{`code="InvalidFormat"`}},
+		{path: getMap + "&LAYERS=geography-class-png&CRS=EPSG:3857&BBOX=1,0,0,1&FORMAT=image/png", status: 400, contains: []string{`code="InvalidParameterValue"`}},
+		{path: getMap + "&LAYERS=geography-class-png&CRS=EPSG:3857&BBOX=0,0,1&FORMAT=image/png", status: 400, contains: []string{`code="InvalidParameterValue"`}},
+		{path: getMap + "&LAYERS=geography-class-png&STYLES=foo&CRS=EPSG:3857&BBOX=0,0,1,1&FORMAT=image/png", status: 400, contains: []string{`code="StyleNotDefined"`}},
+		{path: getMap + "&LAYERS=geography-class-png&CRS=EPSG:3857&FORMAT=image/png", status: 400, contains: []string{`code="MissingParameterValue"`}},
+		{path: getMap + "&LAYERS=geography-class-png&layers=geography-class-jpg&CRS=EPSG:3857&BBOX=0,0,1,1&FORMAT=image/png", status: 400, contains: []string{`code="InvalidParameterValue"`, "LAYERS must not be repeated"}},
+		{path: "/wms?SERVICE=WMS&REQUEST=GetMap&VERSION=1.3.0&LAYERS=geography-class-png&CRS=EPSG:3857&BBOX=0,0,1,1&WIDTH=5000&HEIGHT=1&FORMAT=image/png", status: 400, contains: []string{`code="InvalidParameterValue"`}},
+		{path: "/wms?SERVICE=WMS&REQUEST=GetFeatureInfo", status: 501, contains: []string{`code="OperationNotSupported"`}},
+		{path: "/wms?SERVICE=WMTS&REQUEST=GetCapabilities", status: 400},
+	}
+
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != tc.status {
+			t.Error("Unexpected status code for:", tc.path, w.Code, "expected:", tc.status)
+			continue
+		}
+		if tc.contentType != "" && w.Header().Get("Content-Type") != tc.contentType {
+			t.Error("Unexpected content type for:", tc.path, w.Header().Get("Content-Type"), "expected:", tc.contentType)
+			continue
+		}
+		if strings.HasPrefix(tc.contentType, "image/") {
+			cfg, _, err := image.DecodeConfig(w.Body)
+			if err != nil || cfg.Width != 256 || cfg.Height != 128 {
+				t.Error("Unexpected image for:", tc.path, cfg.Width, cfg.Height, err)
+			}
+		}
+		for _, s := range tc.contains {
+			if !strings.Contains(w.Body.String(), s) {
+				t.Errorf("Response for %s does not contain %q", tc.path, s)
+			}
+		}
+	}
+
+	ids := []struct {
+		path string
+		id   string
+	}{
+		{path: getMap + "&LAYERS=geography-class-png", id: "geography-class-png"},
+		{path: getMap + "&LAYERS=geography-class-jpg,geography-class-png", id: "geography-class-jpg,geography-class-png"},
+		{path: getMap + "&LAYERS=geography-class-png,foo", id: ""},
+		{path: getMap + "&LAYERS=geography-class-png&layers=geography-class-jpg", id: ""},
+	}
+	for _, tc := range ids {
+		if id := svc.idFromRequest(httptest.NewRequest("GET", tc.path, nil)); id != tc.id {
+			t.Error("idFromRequest returned unexpected ID for:", tc.path, id, "expected:", tc.id)
+		}
+	}
+
+	// maps that require too many tiles at the minimum zoom level of a layer
+	// are not returned as blank images
+	ts := svc.tilesets["geography-class-png"]
+	ts.minzoom, ts.maxzoom = 3, 3
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", getMap+"&LAYERS=geography-class-png&CRS=CRS:84&BBOX=-180,-85,180,85&FORMAT=image/png", nil))
+	if w.Code != 400 || !strings.Contains(w.Body.String(), `code="InvalidParameterValue"`) {
+		t.Error("Unexpected response for map requiring too many tiles:", w.Code, w.Body.String())
+	}
+}
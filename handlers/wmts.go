@@ -0,0 +1,474 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	mbtiles "github.com/brendan-ward/mbtiles-go"
+)
+
+// root of WMTS is at /wmts, which handles KVP requests
+// RESTful requests are served under /wmts/1.0.0
+
+const WMTSRoot = "/wmts"
+const WMTSServiceRoot = WMTSRoot + "/1.0.0"
+const WMTSCapabilitiesPath = WMTSServiceRoot + "/WMTSCapabilities.xml"
+
+const (
+	wmtsTileMatrixSet  = "GoogleMapsCompatible"
+	wmtsWellKnownScale = "urn:ogc:def:wkss:OGC:1.0:GoogleMapsCompatible"
+	wmtsCRS            = "urn:ogc:def:crs:EPSG::3857"
+	wmtsStyle          = "default"
+
+	// standardized rendering pixel size of 0.28 mm used to calculate scale
+	// denominators
+	wmtsPixelSize = 0.00028
+)
+
+type wmtsCapabilities struct {
+	XMLName            xml.Name               `xml:"Capabilities"`
+	Xmlns              string                 `xml:"xmlns,attr"`
+	XmlnsOWS           string                 `xml:"xmlns:ows,attr"`
+	XmlnsXlink         string                 `xml:"xmlns:xlink,attr"`
+	Version            string                 `xml:"version,attr"`
+	ServiceTitle       string                 `xml:"ows:ServiceIdentification>ows:Title"`
+	ServiceType        string                 `xml:"ows:ServiceIdentification>ows:ServiceType"`
+	ServiceTypeVersion string                 `xml:"ows:ServiceIdentification>ows:ServiceTypeVersion"`
+	Operations         []wmtsOperation        `xml:"ows:OperationsMetadata>ows:Operation"`
+	Layers             []wmtsLayer            `xml:"Contents>Layer"`
+	TileMatrixSet      wmtsTileMatrixSetEntry `xml:"Contents>TileMatrixSet"`
+	ServiceMetadataURL wmtsLink               `xml:"ServiceMetadataURL"`
+}
+
+type wmtsLink struct {
+	Href string `xml:"xlink:href,attr"`
+}
+
+type wmtsOperation struct {
+	Name string        `xml:"name,attr"`
+	Get  []wmtsHTTPGet `xml:"ows:DCP>ows:HTTP>ows:Get"`
+}
+
+type wmtsHTTPGet struct {
+	Href       string            `xml:"xlink:href,attr"`
+	Constraint wmtsGetConstraint `xml:"ows:Constraint"`
+}
+
+type wmtsGetConstraint struct {
+	Name   string   `xml:"name,attr"`
+	Values []string `xml:"ows:AllowedValues>ows:Value"`
+}
+
+type wmtsBoundingBox struct {
+	CRS         string `xml:"crs,attr,omitempty"`
+	LowerCorner string `xml:"ows:LowerCorner"`
+	UpperCorner string `xml:"ows:UpperCorner"`
+}
+
+type wmtsLayer struct {
+	Title             string                `xml:"ows:Title"`
+	Abstract          string                `xml:"ows:Abstract,omitempty"`
+	WGS84BoundingBox  wmtsBoundingBox       `xml:"ows:WGS84BoundingBox"`
+	BoundingBox       wmtsBoundingBox       `xml:"ows:BoundingBox"`
+	Identifier        string                `xml:"ows:Identifier"`
+	Style             wmtsStyleEntry        `xml:"Style"`
+	Format            string                `xml:"Format"`
+	TileMatrixSetLink wmtsTileMatrixSetLink `xml:"TileMatrixSetLink"`
+	ResourceURL       wmtsResourceURL       `xml:"ResourceURL"`
+}
+
+type wmtsStyleEntry struct {
+	IsDefault  bool   `xml:"isDefault,attr"`
+	Identifier string `xml:"ows:Identifier"`
+}
+
+type wmtsTileMatrixSetLink struct {
+	TileMatrixSet string                 `xml:"TileMatrixSet"`
+	Limits        []wmtsTileMatrixLimits `xml:"TileMatrixSetLimits>TileMatrixLimits"`
+}
+
+type wmtsTileMatrixLimits struct {
+	TileMatrix string `xml:"TileMatrix"`
+	MinTileRow int64  `xml:"MinTileRow"`
+	MaxTileRow int64  `xml:"MaxTileRow"`
+	MinTileCol int64  `xml:"MinTileCol"`
+	MaxTileCol int64  `xml:"MaxTileCol"`
+}
+
+type wmtsResourceURL struct {
+	Format       string `xml:"format,attr"`
+	ResourceType string `xml:"resourceType,attr"`
+	Template     string `xml:"template,attr"`
+}
+
+type wmtsTileMatrixSetEntry struct {
+	Identifier        string           `xml:"ows:Identifier"`
+	BoundingBox       wmtsBoundingBox  `xml:"ows:BoundingBox"`
+	SupportedCRS      string           `xml:"ows:SupportedCRS"`
+	WellKnownScaleSet string           `xml:"WellKnownScaleSet"`
+	TileMatrices      []wmtsTileMatrix `xml:"TileMatrix"`
+}
+
+type wmtsTileMatrix struct {
+	Identifier       string `xml:"ows:Identifier"`
+	ScaleDenominator string `xml:"ScaleDenominator"`
+	TopLeftCorner    string `xml:"TopLeftCorner"`
+	TileWidth        int    `xml:"TileWidth"`
+	TileHeight       int    `xml:"TileHeight"`
+	MatrixWidth      int64  `xml:"MatrixWidth"`
+	MatrixHeight     int64  `xml:"MatrixHeight"`
+}
+
+type wmtsExceptionReport struct {
+	XMLName   xml.Name      `xml:"ows:ExceptionReport"`
+	XmlnsOWS  string        `xml:"xmlns:ows,attr"`
+	Version   string        `xml:"version,attr"`
+	Exception wmtsException `xml:"ows:Exception"`
+}
+
+type wmtsException struct {
+	Code    string `xml:"exceptionCode,attr"`
+	Locator string `xml:"locator,attr,omitempty"`
+	Text    string `xml:"ows:ExceptionText"`
+}
+
+// wmtsError writes an OWS exception report to w
+func wmtsError(w http.ResponseWriter, status int, code, locator, text string) {
+	bytes, _ := xml.MarshalIndent(wmtsExceptionReport{
+		XmlnsOWS:  "http://www.opengis.net/ows/1.1",
+		Version:   "1.0.0",
+		Exception: wmtsException{Code: code, Locator: locator, Text: text},
+	}, "", "  ")
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write(append([]byte(xml.Header), bytes...))
+}
+
+// queryValues returns all values of query parameter name, ignoring case, as
+// required for parameter names of OGC KVP requests.
+func queryValues(query url.Values, name string) []string {
+	var values []string
+	for k, v := range query {
+		if strings.EqualFold(k, name) {
+			values = append(values, v...)
+		}
+	}
+	return values
+}
+
+// queryValue returns the value of query parameter name, ignoring case.
+// Returns "" if the parameter is missing or repeated, so that a request never
+// resolves to different values depending on the order of its parameters.
+func queryValue(query url.Values, name string) string {
+	if values := queryValues(query, name); len(values) == 1 {
+		return values[0]
+	}
+	return ""
+}
+
+// queryRepeated returns the first of the parameter names that is repeated in
+// query, ignoring case, or "" if none are repeated.
+func queryRepeated(query url.Values, names ...string) string {
+	for _, name := range names {
+		if len(queryValues(query, name)) > 1 {
+			return name
+		}
+	}
+	return ""
+}
+
+// wmtsLayer returns the WMTS layer describing the tileset.  rootURL is the
+// scheme and host of the server.
+func (ts *Tileset) wmtsLayer(rootURL string) (*wmtsLayer, error) {
+	metadata, err := ts.db.ReadMetadata()
+	if err != nil {
+		return nil, err
+	}
+	description, _ := metadata["description"].(string)
+
+	bounds := ts.bounds[:]
+	xmin, ymin := lonLatToMercator(bounds[0], bounds[1])
+	xmax, ymax := lonLatToMercator(bounds[2], bounds[3])
+
+	var limits []wmtsTileMatrixLimits
+	for z := ts.minzoom; z <= ts.availableMaxZoom(); z++ {
+		minCol, minRow, maxCol, maxRow := tileRangeForBounds(bounds, z)
+		limits = append(limits, wmtsTileMatrixLimits{
+			TileMatrix: strconv.Itoa(z),
+			MinTileRow: minRow,
+			MaxTileRow: maxRow,
+			MinTileCol: minCol,
+			MaxTileCol: maxCol,
+		})
+	}
+
+	format := ts.tileformat.MimeType()
+	return &wmtsLayer{
+		Title:    ts.name,
+		Abstract: description,
+		WGS84BoundingBox: wmtsBoundingBox{
+			LowerCorner: formatFloat(bounds[0]) + " " + formatFloat(bounds[1]),
+			UpperCorner: formatFloat(bounds[2]) + " " + formatFloat(bounds[3]),
+		},
+		BoundingBox: wmtsBoundingBox{
+			CRS:         wmtsCRS,
+			LowerCorner: formatFloat(xmin) + " " + formatFloat(ymin),
+			UpperCorner: formatFloat(xmax) + " " + formatFloat(ymax),
+		},
+		Identifier: ts.id,
+		Style:      wmtsStyleEntry{IsDefault: true, Identifier: wmtsStyle},
+		Format:     format,
+		TileMatrixSetLink: wmtsTileMatrixSetLink{
+			TileMatrixSet: wmtsTileMatrixSet,
+			Limits:        limits,
+		},
+		ResourceURL: wmtsResourceURL{
+			Format:       format,
+			ResourceType: "tile",
+			Template:     fmt.Sprintf("%s%s/%s/{Style}/{TileMatrixSet}/{TileMatrix}/{TileRow}/{TileCol}.%s", rootURL, WMTSServiceRoot, ts.id, ts.tileformat.String()),
+		},
+	}, nil
+}
+
+// wmtsGoogleMapsCompatible returns the GoogleMapsCompatible TileMatrixSet from
+// zoom level 0 to maxzoom
+func wmtsGoogleMapsCompatible(maxzoom int) wmtsTileMatrixSetEntry {
+	corner := formatFloat(-earthCircumference) + " " + formatFloat(earthCircumference)
+	var matrices []wmtsTileMatrix
+	for z := 0; z <= maxzoom; z++ {
+		n := int64(1) << uint(z)
+		matrices = append(matrices, wmtsTileMatrix{
+			Identifier:       strconv.Itoa(z),
+			ScaleDenominator: formatFloat(initialResolution / math.Exp2(float64(z)) / wmtsPixelSize),
+			TopLeftCorner:    corner,
+			TileWidth:        int(tileSize256),
+			TileHeight:       int(tileSize256),
+			MatrixWidth:      n,
+			MatrixHeight:     n,
+		})
+	}
+
+	return wmtsTileMatrixSetEntry{
+		Identifier: wmtsTileMatrixSet,
+		BoundingBox: wmtsBoundingBox{
+			CRS:         wmtsCRS,
+			LowerCorner: formatFloat(-earthCircumference) + " " + formatFloat(-earthCircumference),
+			UpperCorner: formatFloat(earthCircumference) + " " + formatFloat(earthCircumference),
+		},
+		SupportedCRS:      wmtsCRS,
+		WellKnownScaleSet: wmtsWellKnownScale,
+		TileMatrices:      matrices,
+	}
+}
+
+// wmtsCapabilities returns the WMTS capabilities document listing each
+// tileset in the ServiceSet as a layer.  rootURL is the scheme and host of
+// the server.  Also returns the most recent modification time of the
+// tilesets.
+func (s *ServiceSet) wmtsCapabilities(rootURL string) (*wmtsCapabilities, time.Time, error) {
+	kvp := wmtsHTTPGet{Href: rootURL + WMTSRoot + "?", Constraint: wmtsGetConstraint{Name: "GetEncoding", Values: []string{"KVP"}}}
+	restful := func(href string) wmtsHTTPGet {
+		return wmtsHTTPGet{Href: href, Constraint: wmtsGetConstraint{Name: "GetEncoding", Values: []string{"RESTful"}}}
+	}
+	caps := &wmtsCapabilities{
+		Xmlns:              "http://www.opengis.net/wmts/1.0",
+		XmlnsOWS:           "http://www.opengis.net/ows/1.1",
+		XmlnsXlink:         "http://www.w3.org/1999/xlink",
+		Version:            "1.0.0",
+		ServiceTitle:       "mbtileserver",
+		ServiceType:        "OGC WMTS",
+		ServiceTypeVersion: "1.0.0",
+		Operations: []wmtsOperation{
+			{Name: "GetCapabilities", Get: []wmtsHTTPGet{kvp, restful(rootURL + WMTSCapabilitiesPath)}},
+			{Name: "GetTile", Get: []wmtsHTTPGet{kvp, restful(rootURL + WMTSServiceRoot + "/")}},
+		},
+		Layers:             []wmtsLayer{},
+		ServiceMetadataURL: wmtsLink{Href: rootURL + WMTSCapabilitiesPath},
+	}
+
+	// sort ids alpabetically
+	var ids []string
+	for id := range s.tilesets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var modTime time.Time
+	maxzoom := 0
+	for _, id := range ids {
+		ts := s.tilesets[id]
+		if ts.db == nil || !ts.published {
+			continue
+		}
+		layer, err := ts.wmtsLayer(rootURL)
+		if err != nil {
+			return nil, modTime, fmt.Errorf("could not create WMTS layer for tileset %q: %v", id, err)
+		}
+		caps.Layers = append(caps.Layers, *layer)
+
+		if ts.availableMaxZoom() > maxzoom {
+			maxzoom = ts.availableMaxZoom()
+		}
+		if ts.db.GetTimestamp().After(modTime) {
+			modTime = ts.db.GetTimestamp()
+		}
+	}
+	caps.TileMatrixSet = wmtsGoogleMapsCompatible(maxzoom)
+
+	return caps, modTime, nil
+}
+
+// wmtsCapabilitiesHandler is an http.HandlerFunc that returns the WMTS
+// capabilities document
+func (s *ServiceSet) wmtsCapabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	rootURL := fmt.Sprintf("%s://%s", scheme(r), getRequestHost(r))
+	caps, modTime, err := s.wmtsCapabilities(rootURL)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.logError("Could not create WMTS capabilities for %v: %v", r.URL.Path, err)
+		return
+	}
+
+	setCacheControl(w, s.cacheControl.ServiceList)
+	if err = writeXML(w, r, caps, modTime); err != nil {
+		s.logError("Could not write WMTS capabilities for %v: %v", r.URL.Path, err)
+	}
+}
+
+// wmtsHandler is an http.HandlerFunc for WMTS KVP requests
+func (s *ServiceSet) wmtsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != WMTSRoot && r.URL.Path != WMTSRoot+"/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	if name := queryRepeated(query, "SERVICE", "REQUEST", "VERSION", "LAYER", "STYLE", "TILEMATRIXSET", "TILEMATRIX", "TILEROW", "TILECOL", "FORMAT"); name != "" {
+		wmtsError(w, http.StatusBadRequest, "InvalidParameterValue", strings.ToLower(name), name+" must not be repeated")
+		return
+	}
+	if service := queryValue(query, "SERVICE"); !strings.EqualFold(service, "WMTS") {
+		wmtsError(w, http.StatusBadRequest, "InvalidParameterValue", "service", "SERVICE must be WMTS")
+		return
+	}
+
+	switch request := queryValue(query, "REQUEST"); {
+	case strings.EqualFold(request, "GetCapabilities"):
+		s.wmtsCapabilitiesHandler(w, r)
+
+	case strings.EqualFold(request, "GetTile"):
+		for _, name := range []string{"VERSION", "LAYER", "TILEMATRIXSET", "TILEMATRIX", "TILEROW", "TILECOL", "FORMAT"} {
+			if queryValue(query, name) == "" {
+				wmtsError(w, http.StatusBadRequest, "MissingParameterValue", strings.ToLower(name), name+" is required")
+				return
+			}
+		}
+		if version := queryValue(query, "VERSION"); version != "1.0.0" {
+			wmtsError(w, http.StatusBadRequest, "InvalidParameterValue", "version", "VERSION must be 1.0.0")
+			return
+		}
+
+		ts, ok := s.tilesets[queryValue(query, "LAYER")]
+		if !ok || ts == nil || !ts.published {
+			wmtsError(w, http.StatusBadRequest, "InvalidParameterValue", "layer", "LAYER does not exist")
+			return
+		}
+
+		format, ok := tileFormatFromMimeType(queryValue(query, "FORMAT"))
+		if !ok {
+			wmtsError(w, http.StatusBadRequest, "InvalidParameterValue", "format", "FORMAT is not supported")
+			return
+		}
+
+		ts.wmtsTile(w, r, queryValue(query, "STYLE"), queryValue(query, "TILEMATRIXSET"),
+			queryValue(query, "TILEMATRIX"), queryValue(query, "TILEROW"), queryValue(query, "TILECOL"), format)
+
+	case request == "":
+		wmtsError(w, http.StatusBadRequest, "MissingParameterValue", "request", "REQUEST is required")
+
+	default:
+		wmtsError(w, http.StatusNotImplemented, "OperationNotSupported", "request", "REQUEST is not supported")
+	}
+}
+
+// wmtsServiceHandler is an http.HandlerFunc for RESTful WMTS requests.
+// Requests for tiles are routed to the tileset.
+func (s *ServiceSet) wmtsServiceHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case WMTSServiceRoot, WMTSServiceRoot + "/", WMTSCapabilitiesPath:
+		s.wmtsCapabilitiesHandler(w, r)
+	default:
+		s.tilesetHandler(w, r)
+	}
+}
+
+// wmtsTileHandler is an http.HandlerFunc for RESTful WMTS tile requests of the
+// form /wmts/1.0.0/<id>/{Style}/{TileMatrixSet}/{TileMatrix}/{TileRow}/{TileCol}.<format>
+func (ts *Tileset) wmtsTileHandler(w http.ResponseWriter, r *http.Request) {
+	if ts == nil || !ts.published {
+		tileNotFoundHandler(w, r, ts.tileformat, ts.tilesize, ts.svc.returnMissingImageTile404)
+		return
+	}
+
+	pcs := strings.Split(strings.TrimPrefix(r.URL.Path, WMTSServiceRoot+"/"+ts.id+"/"), "/")
+	if len(pcs) != 5 || pcs[4] == "" {
+		wmtsError(w, http.StatusBadRequest, "MissingParameterValue", "", "requested path is too short")
+		return
+	}
+
+	col, ext := pcs[4], ""
+	if i := strings.LastIndex(col, "."); i >= 0 {
+		col, ext = col[:i], col[i:]
+	}
+	format, ok := tileFormatFromExtension(ext)
+	if !ok {
+		wmtsError(w, http.StatusBadRequest, "InvalidParameterValue", "format", "format is not supported")
+		return
+	}
+
+	ts.wmtsTile(w, r, pcs[0], pcs[1], pcs[2], pcs[3], col, format)
+}
+
+// wmtsTile writes the tile at the tile matrix, row, and column in the
+// requested format to w.  Missing tiles use the same responses as the XYZ
+// tile API.
+func (ts *Tileset) wmtsTile(w http.ResponseWriter, r *http.Request, style, tileMatrixSet, tileMatrix, row, col string, format mbtiles.TileFormat) {
+	// wait up to 30 seconds to see if tileset is ready and return it if possible
+	if ts.isLockedWithTimeout(30 * time.Second) {
+		tilesetLockedHandler(w, r)
+		return
+	}
+
+	if style != "" && style != wmtsStyle {
+		wmtsError(w, http.StatusBadRequest, "InvalidParameterValue", "style", "style must be "+wmtsStyle)
+		return
+	}
+	if tileMatrixSet != wmtsTileMatrixSet {
+		wmtsError(w, http.StatusBadRequest, "InvalidParameterValue", "tilematrixset", "tile matrix set must be "+wmtsTileMatrixSet)
+		return
+	}
+
+	tc, _, err := tileCoordFromString(tileMatrix, col, row)
+	if err != nil {
+		wmtsError(w, http.StatusBadRequest, "TileOutOfRange", "tilematrix", err.Error())
+		return
+	}
+
+	// tiles in GoogleMapsCompatible are 256 pixels
+	ts.writeTile(w, r, tc, "."+format.String(), ts.standardTileSize())
+}
+
+// wmtsLayerFromRequest returns the layer of a WMTS KVP request, if any
+func wmtsLayerFromRequest(r *http.Request) string {
+	if r.URL.Path != WMTSRoot && r.URL.Path != WMTSRoot+"/" {
+		return ""
+	}
+	return queryValue(r.URL.Query(), "LAYER")
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_WMTSHandlers(t *testing.T) {
+	svc := newTestServiceSet(t, &ServiceSetConfig{EnableWMTS: true}, "geography-class-png", "world_cities")
+	handler := svc.Handler()
+
+	getTile := "/wmts?SERVICE=WMTS&REQUEST=GetTile&VERSION=1.0.0&STYLE=default&TILEMATRIXSET=GoogleMapsCompatible"
+
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+		contains    []string
+	}{
+		{path: "/wmts?service=WMTS&request=GetCapabilities", status: 200, contentType: "application/xml", contains: []string{
+			`<ows:Identifier>geography-class-png</ows:Identifier>`,
+			`<ows:Identifier>world_cities</ows:Identifier>`,
+			`template="http://example.com/wmts/1.0.0/geography-class-png/{Style}/{TileMatrixSet}/{TileMatrix}/{TileRow}/{TileCol}.png"`,
+			`<WellKnownScaleSet>urn:ogc:def:wkss:OGC:1.0:GoogleMapsCompatible</WellKnownScaleSet>`,
+			`<ScaleDenominator>559082264.0287178</ScaleDenominator>`,
+		}},
+		{path: "/wmts/1.0.0/WMTSCapabilities.xml", status: 200, contentType: "application/xml", contains: []string{
+			`<ows:Identifier>world_cities</ows:Identifier>`,
+		}},
+		{path: getTile + "&LAYER=geography-class-png&TILEMATRIX=1&TILEROW=0&TILECOL=1&FORMAT=image/png", status: 200, contentType: "image/png"},
+		{path: getTile + "&LAYER=geography-class-png&TILEMATRIX=1&TILEROW=0&TILECOL=1&FORMAT=image/jpeg", status: 200, contentType: "image/jpeg"},
+		{path: getTile + "&LAYER=geography-class-png&TILEMATRIX=1&TILEROW=2&TILECOL=1&FORMAT=image/png", status: 400, contains: []string{`exceptionCode="TileOutOfRange"`}},
+		{path: getTile + "&LAYER=geography-class-png&TILEMATRIX=-1&TILEROW=0&TILECOL=0&FORMAT=image/png", status: 400, contains: []string{`exceptionCode="TileOutOfRange"`}},
+		{path: getTile + "&LAYER=foo&TILEMATRIX=1&TILEROW=0&TILECOL=1&FORMAT=image/png", status: 400, contains: []string{`locator="layer"`}},
+		{path: getTile + "&LAYER=geography-class-png&TILEMATRIX=1&TILEROW=0&FORMAT=image/png", status: 400, contains: []string{`exceptionCode="MissingParameterValue"`}},
+		{path: getTile + "&LAYER=geography-class-png&layer=world_cities&TILEMATRIX=1&TILEROW=0&TILECOL=0&FORMAT=image/png", status: 400, contains: []string{`locator="layer"`, "LAYER must not be repeated"}},
+		{path: getTile + "&LAYER=geography-class-png&LAYER=world_cities&TILEMATRIX=1&TILEROW=0&TILECOL=0&FORMAT=image/png", status: 400, contains: []string{`locator="layer"`}},
+		{path: "/wmts?service=WMTS&request=GetFeatureInfo", status: 501, contains: []string{`exceptionCode="OperationNotSupported"`}},
+		{path: "/wmts/1.0.0/world_cities/default/GoogleMapsCompatible/1/0/0.pbf", status: 200, contentType: "application/x-protobuf"},
+		{path: "/wmts/1.0.0/geography-class-png/default/GoogleMapsCompatible/1/0/1.png", status: 200, contentType: "image/png"},
+		{path: "/wmts/1.0.0/geography-class-png/default/foo/1/0/1.png", status: 400, contains: []string{`locator="tilematrixset"`}},
+	}
+
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != tc.status {
+			t.Error("Unexpected status code for:", tc.path, w.Code, "expected:", tc.status)
+			continue
+		}
+		if tc.contentType != "" && w.Header().Get("Content-Type") != tc.contentType {
+			t.Error("Unexpected content type for:", tc.path, w.Header().Get("Content-Type"), "expected:", tc.contentType)
+			continue
+		}
+		for _, s := range tc.contains {
+			if !strings.Contains(w.Body.String(), s) {
+				t.Errorf("Response for %s does not contain %q", tc.path, s)
+			}
+		}
+	}
+
+	ids := []struct {
+		path string
+		id   string
+	}{
+		{path: "/wmts/1.0.0/world_cities/default/GoogleMapsCompatible/1/0/0.pbf", id: "world_cities"},
+		{path: getTile + "&LAYER=geography-class-png&TILEMATRIX=1&TILEROW=0&TILECOL=1&FORMAT=image/png", id: "geography-class-png"},
+		{path: getTile + "&LAYER=foo", id: ""},
+		{path: getTile + "&LAYER=geography-class-png&layer=world_cities", id: ""},
+		{path: "/wmts/1.0.0/WMTSCapabilities.xml", id: ""},
+	}
+	for _, tc := range ids {
+		if id := svc.idFromRequest(httptest.NewRequest("GET", tc.path, nil)); id != tc.id {
+			t.Error("idFromRequest returned unexpected ID for:", tc.path, id, "expected:", tc.id)
+		}
+	}
+
+	// tiles of unpublished tilesets are not available
+	svc.tilesets["world_cities"].published = false
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/wmts/1.0.0/world_cities/default/GoogleMapsCompatible/1/0/0.pbf", nil))
+	if w.Code != 204 {
+		t.Error("Unexpected status code for tile of unpublished tileset:", w.Code, "expected:", 204)
+	}
+}
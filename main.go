@@ -63,6 +63,8 @@ var (
 	host                string
 	port                int
 	tilePath            string
+	stylesDir           string
+	fontsDir            string
 	certificate         string
 	privateKey          string
 	rootURLStr          string
@@ -76,13 +78,39 @@ var (
 	enableReloadFSWatch bool
 	generateIDs         bool
 	enableArcGIS        bool
+	enableTMS           bool
+	enableWMTS          bool
+	enableOGCAPI        bool
+	enableWMS           bool
+	enableTileServerGL  bool
+	enableStaticMaps    bool
+	staticMapMaxSize    int
+	enableQuery         bool
+	enableTerrain       bool
+	enableGeoJSON       bool
+	enableTileStats     bool
 	disablePreview      bool
+	disableThumbnails   bool
 	disableTileJSON     bool
+	tileJSONVersion     string
 	disableServiceList  bool
 	tilesOnly           bool
 	basemapStyleURL     string
 	basemapTilesURL     string
 	missingImageTile404 bool
+
+	cacheControlTiles        string
+	cacheControlMissingTiles string
+	cacheControlTileJSON     string
+	cacheControlPreview      string
+	cacheControlServiceList  string
+	tilesetCacheControl      []string
+
+	enableOverzoom  bool
+	overzoomMaxZoom int
+	tilesetOverzoom []string
+	jpegQuality     int
+	tileCacheSize   int
 )
 
 func init() {
@@ -90,6 +118,8 @@ func init() {
 	flags.StringVar(&host, "host", "0.0.0.0", "IP address to listen on. Default is all interfaces.")
 	flags.IntVarP(&port, "port", "p", -1, "Server port.  Default is 443 if --cert or --tls options are used, otherwise 8000.")
 	flags.StringVarP(&tilePath, "dir", "d", "./tilesets", "Directory containing mbtiles files.  Can be a comma-delimited list of directories.")
+	flags.StringVar(&stylesDir, "styles-dir", "", "Directory containing map styles, each in a subdirectory with style.json and optional sprite files")
+	flags.StringVar(&fontsDir, "fonts-dir", "", "Directory containing glyph fonts, each in a subdirectory with <range>.pbf files")
 	flags.BoolVarP(&generateIDs, "generate-ids", "", false, "Automatically generate tileset IDs instead of using relative path")
 	flags.StringVarP(&certificate, "cert", "c", "", "X.509 TLS certificate filename.  If present, will be used to enable SSL on the server.")
 	flags.StringVarP(&privateKey, "key", "k", "", "TLS private key")
@@ -100,13 +130,26 @@ func init() {
 	flags.BoolVarP(&redirect, "redirect", "r", false, "Redirect HTTP to HTTPS")
 
 	flags.BoolVarP(&enableArcGIS, "enable-arcgis", "", false, "Enable ArcGIS Mapserver endpoints")
+	flags.BoolVarP(&enableTMS, "enable-tms", "", false, "Enable TMS (Tile Map Service) endpoints")
+	flags.BoolVarP(&enableWMTS, "enable-wmts", "", false, "Enable OGC WMTS (Web Map Tile Service) endpoints")
+	flags.BoolVarP(&enableOGCAPI, "enable-ogcapi", "", false, "Enable OGC API - Tiles endpoints")
+	flags.BoolVarP(&enableWMS, "enable-wms", "", false, "Enable OGC WMS (Web Map Service) endpoints for image tilesets")
+	flags.BoolVarP(&enableTileServerGL, "enable-tileserver-gl", "", false, "Enable TileServer GL compatible endpoints (/data, /styles, /index.json)")
+	flags.BoolVarP(&enableStaticMaps, "enable-static-maps", "", false, "Enable static map images of image tilesets")
+	flags.IntVar(&staticMapMaxSize, "static-map-max-size", 2048, "Maximum width or height of static map images in pixels (max 4096)")
+	flags.BoolVarP(&enableQuery, "enable-query", "", false, "Enable feature queries of vector tilesets and pixel (elevation) queries of image tilesets")
+	flags.BoolVarP(&enableTerrain, "enable-terrain", "", false, "Enable hillshade and slope tiles of elevation tilesets")
+	flags.BoolVarP(&enableGeoJSON, "enable-geojson", "", false, "Enable GeoJSON export of features of vector tilesets")
+	flags.BoolVarP(&enableTileStats, "enable-tilestats", "", false, "Enable computing tilestats of vector tilesets from their tiles in the background")
 	flags.BoolVarP(&enableReloadFSWatch, "enable-fs-watch", "", false, "Enable reloading of tilesets by watching filesystem")
 	flags.BoolVarP(&enableReloadSignal, "enable-reload-signal", "", false, "Enable graceful reload using HUP signal to the server process")
 
 	flags.BoolVarP(&disablePreview, "disable-preview", "", false, "Disable map preview for each tileset (enabled by default)")
+	flags.BoolVarP(&disableThumbnails, "disable-thumbnails", "", false, "Disable thumbnail image for each tileset (enabled by default)")
 	flags.BoolVarP(&disableTileJSON, "disable-tilejson", "", false, "Disable TileJSON endpoint for each tileset (enabled by default)")
+	flags.StringVar(&tileJSONVersion, "tilejson-version", "2.1.0", "Default TileJSON version returned by TileJSON endpoint (2.1.0 or 3.0.0)")
 	flags.BoolVarP(&disableServiceList, "disable-svc-list", "", false, "Disable services list endpoint (enabled by default)")
-	flags.BoolVarP(&tilesOnly, "tiles-only", "", false, "Only enable tile endpoints (shortcut for --disable-svc-list --disable-tilejson --disable-preview)")
+	flags.BoolVarP(&tilesOnly, "tiles-only", "", false, "Only enable tile endpoints (shortcut for --disable-svc-list --disable-tilejson --disable-preview --disable-thumbnails)")
 
 	flags.StringVar(&sentryDSN, "dsn", "", "Sentry DSN")
 
@@ -115,6 +158,19 @@ func init() {
 
 	flags.BoolVarP(&missingImageTile404, "missing-image-tile-404", "", false, "Return HTTP 404 error code when image tile is misssing instead of default behavior to return blank PNG")
 
+	flags.StringVar(&cacheControlTiles, "cache-control-tiles", "", "Cache-Control header for tiles, e.g., \"max-age=86400, stale-while-revalidate=3600\"")
+	flags.StringVar(&cacheControlMissingTiles, "cache-control-missing-tiles", "", "Cache-Control header for blank or empty responses for missing tiles")
+	flags.StringVar(&cacheControlTileJSON, "cache-control-tilejson", "", "Cache-Control header for TileJSON endpoints")
+	flags.StringVar(&cacheControlPreview, "cache-control-preview", "", "Cache-Control header for map preview endpoints")
+	flags.StringVar(&cacheControlServiceList, "cache-control-svc-list", "", "Cache-Control header for services list endpoint")
+	flags.StringArrayVar(&tilesetCacheControl, "tileset-cache-control", nil, "Cache-Control header override for a tileset endpoint, as <tileset_id>:<tiles|missing-tiles|tilejson|preview>=<value>.  Can be repeated.")
+
+	flags.BoolVarP(&enableOverzoom, "enable-overzoom", "", false, "Enable synthesizing image and vector tiles above the max zoom of a tileset or missing within it from their nearest ancestor tile")
+	flags.IntVar(&overzoomMaxZoom, "overzoom-max-zoom", 22, "Maximum zoom level of tiles synthesized using --enable-overzoom (max 30)")
+	flags.StringArrayVar(&tilesetOverzoom, "tileset-overzoom", nil, "Overzoom override for a tileset, as <tileset_id>=<true|false|max zoom>.  Can be repeated.")
+	flags.IntVar(&jpegQuality, "jpeg-quality", 90, "Quality (1-100) used when encoding JPEG tiles, such as when transcoding PNG tiles requested as JPEG")
+	flags.IntVar(&tileCacheSize, "tile-cache-size", 0, "Maximum total size in MB of tiles cached in memory, shared by all tilesets (0 to disable)")
+
 	flags.BoolVarP(&verbose, "verbose", "v", false, "Verbose logging")
 
 	if env := os.Getenv("HOST"); env != "" {
@@ -133,6 +189,14 @@ func init() {
 		tilePath = env
 	}
 
+	if env := os.Getenv("STYLES_DIR"); env != "" {
+		stylesDir = env
+	}
+
+	if env := os.Getenv("FONTS_DIR"); env != "" {
+		fontsDir = env
+	}
+
 	if env := os.Getenv("GENERATE_IDS"); env != "" {
 		p, err := strconv.ParseBool(env)
 		if err != nil {
@@ -188,6 +252,98 @@ func init() {
 		enableArcGIS = p
 	}
 
+	if env := os.Getenv("ENABLE_TMS"); env != "" {
+		p, err := strconv.ParseBool(env)
+		if err != nil {
+			log.Fatalln("ENABLE_TMS must be a bool(true/false)")
+		}
+		enableTMS = p
+	}
+
+	if env := os.Getenv("ENABLE_WMTS"); env != "" {
+		p, err := strconv.ParseBool(env)
+		if err != nil {
+			log.Fatalln("ENABLE_WMTS must be a bool(true/false)")
+		}
+		enableWMTS = p
+	}
+
+	if env := os.Getenv("ENABLE_OGCAPI"); env != "" {
+		p, err := strconv.ParseBool(env)
+		if err != nil {
+			log.Fatalln("ENABLE_OGCAPI must be a bool(true/false)")
+		}
+		enableOGCAPI = p
+	}
+
+	if env := os.Getenv("ENABLE_WMS"); env != "" {
+		p, err := strconv.ParseBool(env)
+		if err != nil {
+			log.Fatalln("ENABLE_WMS must be a bool(true/false)")
+		}
+		enableWMS = p
+	}
+
+	if env := os.Getenv("ENABLE_TILESERVER_GL"); env != "" {
+		p, err := strconv.ParseBool(env)
+		if err != nil {
+			log.Fatalln("ENABLE_TILESERVER_GL must be a bool(true/false)")
+		}
+		enableTileServerGL = p
+	}
+
+	if env := os.Getenv("ENABLE_STATIC_MAPS"); env != "" {
+		p, err := strconv.ParseBool(env)
+		if err != nil {
+			log.Fatalln("ENABLE_STATIC_MAPS must be a bool(true/false)")
+		}
+		enableStaticMaps = p
+	}
+
+	if env := os.Getenv("STATIC_MAP_MAX_SIZE"); env != "" {
+		p, err := strconv.Atoi(env)
+		if err != nil {
+			log.Fatalln("STATIC_MAP_MAX_SIZE must be a number")
+		}
+		staticMapMaxSize = p
+	}
+
+	if env := os.Getenv("ENABLE_QUERY"); env != "" {
+		p, err := strconv.ParseBool(env)
+		if err != nil {
+			log.Fatalln("ENABLE_QUERY must be a bool(true/false)")
+		}
+		enableQuery = p
+	}
+
+	if env := os.Getenv("ENABLE_TERRAIN"); env != "" {
+		p, err := strconv.ParseBool(env)
+		if err != nil {
+			log.Fatalln("ENABLE_TERRAIN must be a bool(true/false)")
+		}
+		enableTerrain = p
+	}
+
+	if env := os.Getenv("ENABLE_GEOJSON"); env != "" {
+		p, err := strconv.ParseBool(env)
+		if err != nil {
+			log.Fatalln("ENABLE_GEOJSON must be a bool(true/false)")
+		}
+		enableGeoJSON = p
+	}
+
+	if env := os.Getenv("ENABLE_TILESTATS"); env != "" {
+		p, err := strconv.ParseBool(env)
+		if err != nil {
+			log.Fatalln("ENABLE_TILESTATS must be a bool(true/false)")
+		}
+		enableTileStats = p
+	}
+
+	if env := os.Getenv("TILEJSON_VERSION"); env != "" {
+		tileJSONVersion = env
+	}
+
 	if env := os.Getenv("ENABLE_FS_WATCH"); env != "" {
 		p, err := strconv.ParseBool(env)
 		if err != nil {
@@ -204,6 +360,66 @@ func init() {
 		enableReloadSignal = p
 	}
 
+	if env := os.Getenv("CACHE_CONTROL_TILES"); env != "" {
+		cacheControlTiles = env
+	}
+
+	if env := os.Getenv("CACHE_CONTROL_MISSING_TILES"); env != "" {
+		cacheControlMissingTiles = env
+	}
+
+	if env := os.Getenv("CACHE_CONTROL_TILEJSON"); env != "" {
+		cacheControlTileJSON = env
+	}
+
+	if env := os.Getenv("CACHE_CONTROL_PREVIEW"); env != "" {
+		cacheControlPreview = env
+	}
+
+	if env := os.Getenv("CACHE_CONTROL_SVC_LIST"); env != "" {
+		cacheControlServiceList = env
+	}
+
+	if env := os.Getenv("TILESET_CACHE_CONTROL"); env != "" {
+		tilesetCacheControl = strings.Split(env, ";")
+	}
+
+	if env := os.Getenv("ENABLE_OVERZOOM"); env != "" {
+		p, err := strconv.ParseBool(env)
+		if err != nil {
+			log.Fatalln("ENABLE_OVERZOOM must be a bool(true/false)")
+		}
+		enableOverzoom = p
+	}
+
+	if env := os.Getenv("OVERZOOM_MAX_ZOOM"); env != "" {
+		p, err := strconv.Atoi(env)
+		if err != nil {
+			log.Fatalln("OVERZOOM_MAX_ZOOM must be a number")
+		}
+		overzoomMaxZoom = p
+	}
+
+	if env := os.Getenv("TILESET_OVERZOOM"); env != "" {
+		tilesetOverzoom = strings.Split(env, ";")
+	}
+
+	if env := os.Getenv("JPEG_QUALITY"); env != "" {
+		p, err := strconv.Atoi(env)
+		if err != nil {
+			log.Fatalln("JPEG_QUALITY must be a number")
+		}
+		jpegQuality = p
+	}
+
+	if env := os.Getenv("TILE_CACHE_SIZE"); env != "" {
+		p, err := strconv.Atoi(env)
+		if err != nil {
+			log.Fatalln("TILE_CACHE_SIZE must be a number")
+		}
+		tileCacheSize = p
+	}
+
 	if env := os.Getenv("VERBOSE"); env != "" {
 		p, err := strconv.ParseBool(env)
 		if err != nil {
@@ -243,6 +459,7 @@ func serve() {
 		disableServiceList = true
 		disableTileJSON = true
 		disablePreview = true
+		disableThumbnails = true
 	}
 
 	if disablePreview {
@@ -250,12 +467,37 @@ func serve() {
 		basemapTilesURL = ""
 	}
 
+	if overzoomMaxZoom < 1 || overzoomMaxZoom > handlers.MaxZoomLevel {
+		log.Fatalf("Value for --overzoom-max-zoom must be between 1 and %d", handlers.MaxZoomLevel)
+	}
+
 	if !strings.HasPrefix(rootURLStr, "/") {
 		log.Fatalln("Value for --root-url must start with \"/\"")
 	}
 	if strings.HasSuffix(rootURLStr, "/") {
 		log.Fatalln("Value for --root-url must not end with \"/\"")
 	}
+	// endpoints served at fixed paths that the root URL must not replace
+	fixedRoots := []struct {
+		enabled bool
+		name    string
+		paths   []string
+	}{
+		{enableTileServerGL, "TileServer GL", []string{handlers.TileServerGLDataRoot, handlers.StylesRoot}},
+		{enableTMS, "TMS", []string{handlers.TMSRoot, handlers.TMSServiceRoot}},
+		{enableWMTS, "WMTS", []string{handlers.WMTSRoot, handlers.WMTSServiceRoot}},
+		{enableOGCAPI, "OGC API - Tiles", []string{handlers.OGCAPIRoot}},
+		{enableWMS, "WMS", []string{handlers.WMSRoot}},
+		{stylesDir != "", "styles", []string{handlers.StylesRoot}},
+		{fontsDir != "", "fonts", []string{handlers.FontsRoot}},
+	}
+	for _, r := range fixedRoots {
+		for _, p := range r.paths {
+			if r.enabled && rootURLStr == p {
+				log.Fatalf("Value for --root-url must not be %q when %s endpoints are enabled", rootURLStr, r.name)
+			}
+		}
+	}
 
 	rootURL, err := url.Parse(rootURLStr)
 	if err != nil {
@@ -294,19 +536,54 @@ func serve() {
 		}
 	}
 
+	tilesetConfigs := make(map[string]handlers.TilesetConfig)
+	if err := parseTilesetCacheControl(tilesetCacheControl, tilesetConfigs); err != nil {
+		log.Fatalln(err)
+	}
+	if err := parseTilesetOverzoom(tilesetOverzoom, tilesetConfigs); err != nil {
+		log.Fatalln(err)
+	}
+
 	svcSet, err := handlers.New(&handlers.ServiceSetConfig{
 		RootURL:                   rootURL,
 		ErrorWriter:               &errorLogger{log: log.New()},
 		EnableServiceList:         !disableServiceList,
 		EnableTileJSON:            !disableTileJSON,
 		EnablePreview:             !disablePreview,
+		EnableThumbnails:          !disableThumbnails,
 		EnableArcGIS:              enableArcGIS,
+		EnableTMS:                 enableTMS,
+		EnableWMTS:                enableWMTS,
+		EnableOGCAPI:              enableOGCAPI,
+		EnableWMS:                 enableWMS,
+		EnableTileServerGL:        enableTileServerGL,
+		EnableStaticMaps:          enableStaticMaps,
+		StaticMapMaxSize:          staticMapMaxSize,
+		EnableQuery:               enableQuery,
+		EnableTerrain:             enableTerrain,
+		EnableGeoJSON:             enableGeoJSON,
+		EnableTileStats:           enableTileStats,
+		TileJSONVersion:           tileJSONVersion,
+		StylesDir:                 stylesDir,
+		FontsDir:                  fontsDir,
 		BasemapStyleURL:           basemapStyleURL,
 		BasemapTilesURL:           basemapTilesURL,
 		ReturnMissingImageTile404: missingImageTile404,
+		CacheControl: handlers.CacheControl{
+			Tiles:        cacheControlTiles,
+			MissingTiles: cacheControlMissingTiles,
+			TileJSON:     cacheControlTileJSON,
+			Preview:      cacheControlPreview,
+			ServiceList:  cacheControlServiceList,
+		},
+		Tilesets:        tilesetConfigs,
+		EnableOverzoom:  enableOverzoom,
+		OverzoomMaxZoom: overzoomMaxZoom,
+		JPEGQuality:     jpegQuality,
+		TileCacheSize:   int64(tileCacheSize) << 20,
 	})
 	if err != nil {
-		log.Fatalln("Could not construct ServiceSet")
+		log.Fatalln("Could not construct ServiceSet:", err)
 	}
 
 	for _, path := range strings.Split(tilePath, ",") {
@@ -377,6 +654,17 @@ func serve() {
 	// Get HTTP.Handler for the service set, and wrap for use in echo
 	e.GET("/*", echo.WrapHandler(svcSet.Handler()))
 
+	// TileServer GL compatible endpoints take precedence over those above
+	if enableTileServerGL {
+		tileServerGLHandler := echo.WrapHandler(svcSet.TileServerGLHandler())
+		for _, p := range handlers.TileServerGLPaths {
+			if strings.HasSuffix(p, "/") {
+				p += "*"
+			}
+			e.GET(p, tileServerGLHandler)
+		}
+	}
+
 	// Start the server
 	fmt.Println("\n--------------------------------------")
 	fmt.Println("Use Ctrl-C to exit the server")
@@ -570,6 +858,74 @@ func supervise() {
 	}
 }
 
+// parseTilesetCacheControl parses per-tileset Cache-Control overrides in the
+// form <tileset_id>:<endpoint>=<value> into tileset configurations.
+func parseTilesetCacheControl(values []string, configs map[string]handlers.TilesetConfig) error {
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		target, cacheControl, ok := strings.Cut(value, "=")
+		i := strings.LastIndex(target, ":")
+		if !ok || i < 1 {
+			return fmt.Errorf("Value for --tileset-cache-control must be in the form <tileset_id>:<endpoint>=<value>: %q", value)
+		}
+		id, endpoint := target[:i], target[i+1:]
+
+		cfg := configs[id]
+		switch endpoint {
+		case "tiles":
+			cfg.CacheControl.Tiles = cacheControl
+		case "missing-tiles":
+			cfg.CacheControl.MissingTiles = cacheControl
+		case "tilejson":
+			cfg.CacheControl.TileJSON = cacheControl
+		case "preview":
+			cfg.CacheControl.Preview = cacheControl
+		default:
+			return fmt.Errorf("Endpoint for --tileset-cache-control must be one of tiles, missing-tiles, tilejson, preview: %q", value)
+		}
+		configs[id] = cfg
+	}
+	return nil
+}
+
+// parseTilesetOverzoom parses per-tileset overzoom overrides in the form
+// <tileset_id>=<true|false|max zoom> into tileset configurations.
+func parseTilesetOverzoom(values []string, configs map[string]handlers.TilesetConfig) error {
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		i := strings.LastIndex(value, "=")
+		if i < 1 {
+			return fmt.Errorf("Value for --tileset-overzoom must be in the form <tileset_id>=<true|false|max zoom>: %q", value)
+		}
+		id, setting := value[:i], value[i+1:]
+
+		cfg := configs[id]
+		switch setting {
+		case "true", "false":
+			enabled := setting == "true"
+			cfg.Overzoom = &enabled
+		default:
+			maxZoom, err := strconv.Atoi(setting)
+			if err != nil || maxZoom < 1 || maxZoom > handlers.MaxZoomLevel {
+				return fmt.Errorf("Value for --tileset-overzoom must be in the form <tileset_id>=<true|false|max zoom>: %q", value)
+			}
+			enabled := true
+			cfg.Overzoom = &enabled
+			cfg.OverzoomMaxZoom = maxZoom
+		}
+		configs[id] = cfg
+	}
+	return nil
+}
+
 // errorLogger wraps logrus logger so that we can pass it into the handlers
 type errorLogger struct {
 	log *log.Logger
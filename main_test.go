@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/consbio/mbtileserver/handlers"
+)
+
+func Test_ParseTilesetOverzoom(t *testing.T) {
+	tests := []struct {
+		value    string
+		overzoom bool
+		maxZoom  int
+	}{
+		{value: "foo=true", overzoom: true, maxZoom: 0},
+		{value: "foo=false", overzoom: false, maxZoom: 0},
+		{value: "foo=1", overzoom: true, maxZoom: 1},
+		{value: "foo=14", overzoom: true, maxZoom: 14},
+		{value: "nested/foo=30", overzoom: true, maxZoom: 30},
+	}
+
+	for _, tc := range tests {
+		configs := make(map[string]handlers.TilesetConfig)
+		if err := parseTilesetOverzoom([]string{tc.value}, configs); err != nil {
+			t.Error("Could not parse tileset overzoom:", tc.value, err)
+			continue
+		}
+		for _, cfg := range configs {
+			if cfg.Overzoom == nil || *cfg.Overzoom != tc.overzoom {
+				t.Error("Unexpected overzoom for:", tc.value, cfg.Overzoom, "expected:", tc.overzoom)
+			}
+			if cfg.OverzoomMaxZoom != tc.maxZoom {
+				t.Error("Unexpected overzoom max zoom for:", tc.value, cfg.OverzoomMaxZoom, "expected:", tc.maxZoom)
+			}
+		}
+	}
+
+	invalid := []string{"foo=0", "foo=-1", "foo=31", "foo=bar", "foo=1.5", "foo=t", "foo", "=true"}
+	for _, value := range invalid {
+		if err := parseTilesetOverzoom([]string{value}, make(map[string]handlers.TilesetConfig)); err == nil {
+			t.Error("parseTilesetOverzoom did not raise expected error for:", value)
+		}
+	}
+}